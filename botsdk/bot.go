@@ -0,0 +1,345 @@
+// Package botsdk is a client for writing chat bots against the chat
+// service's BotService.
+//
+// A bot connects with the API key its owner got from the gateway's
+// /bots/create endpoint, subscribes to rooms and then receives their events
+// while sending messages and reactions:
+//
+//	bot, err := botsdk.Connect(ctx, conn, apiKey)
+//	if err != nil {
+//		return err
+//	}
+//	defer bot.Close()
+//	if err := bot.Subscribe(ctx, roomID, ""); err != nil {
+//		return err
+//	}
+//	return bot.Run(ctx, func(event *chat.ChatEvent) {
+//		if msg := event.GetChat(); msg != nil && !bot.Own(msg) {
+//			bot.Send(ctx, msg.RoomId, "You said: "+msg.Content)
+//		}
+//	})
+//
+// Failed requests return gRPC status errors, so status.Code tells why.
+package botsdk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClosed is returned by requests once the bot was closed or the server
+// ended the session.
+var ErrClosed = errors.New("botsdk: session closed")
+
+// Bot is a connected bot session. Its methods may be called from any
+// goroutine, including from the handler passed to Run.
+type Bot struct {
+	stream chat.BotService_ConnectClient
+	cancel context.CancelFunc
+	ready  *chat.BotReady
+
+	sendMu  sync.Mutex // serializes stream.Send
+	nextRef atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]chan *chat.BotEvent // ref -> waiting request
+	queue   []*chat.ChatEvent              // received, not yet delivered
+	err     error                          // why the session ended
+
+	queued    chan struct{} // signaled when queue grows
+	events    chan *chat.ChatEvent
+	done      chan struct{} // closed when the session ends
+	closing   chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// Connect starts a bot session over conn, a connection to the chat service's
+// bot port (BOT_PORT), authenticated with the bot's API key. The session
+// lasts until Close is called, ctx is canceled or the connection fails.
+func Connect(ctx context.Context, conn grpc.ClientConnInterface, apiKey string) (*Bot, error) {
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+apiKey))
+	stream, err := chat.NewBotServiceClient(conn).Connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	ready := first.GetReady()
+	if ready == nil {
+		cancel()
+		return nil, errors.New("botsdk: expected a ready event")
+	}
+
+	b := &Bot{
+		stream:  stream,
+		cancel:  cancel,
+		ready:   ready,
+		pending: make(map[string]chan *chat.BotEvent),
+		queued:  make(chan struct{}, 1),
+		events:  make(chan *chat.ChatEvent),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	go b.receive()
+	go b.deliver()
+	return b, nil
+}
+
+// ID is the bot's ID.
+func (b *Bot) ID() string { return b.ready.BotId }
+
+// UserID is who the bot acts as in rooms.
+func (b *Bot) UserID() string { return b.ready.UserId }
+
+// Name is the name the bot's messages are shown with.
+func (b *Bot) Name() string { return b.ready.Name }
+
+// Own reports whether the bot sent a message. Bots receive their own
+// messages like everyone else in the room.
+func (b *Bot) Own(msg *chat.Message) bool { return msg.GetUserId() == b.ready.UserId }
+
+// Events returns the events of the subscribed rooms, in the order they
+// happened. The channel is closed when the session ends. Events are
+// buffered, so a slow reader never holds up requests.
+func (b *Bot) Events() <-chan *chat.ChatEvent { return b.events }
+
+// Err returns why the session ended, or nil while it is running or if it
+// was closed.
+func (b *Bot) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(b.err, ErrClosed) {
+		return nil
+	}
+	return b.err
+}
+
+// Run calls handle with every event until the session ends or ctx is
+// canceled. It returns nil if the session was closed.
+func (b *Bot) Run(ctx context.Context, handle func(event *chat.ChatEvent)) error {
+	for {
+		select {
+		case event, ok := <-b.events:
+			if !ok {
+				return b.Err()
+			}
+			handle(event)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close ends the session. Events not yet read from Events are dropped.
+func (b *Bot) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.sendMu.Lock()
+		err = b.stream.CloseSend()
+		b.sendMu.Unlock()
+		b.cancel()
+		b.finish(ErrClosed)
+		close(b.closing)
+	})
+	return err
+}
+
+// Subscribe joins a room, with its password if it has one and the bot was
+// not invited. The room's joined and history events arrive before
+// Subscribe returns.
+func (b *Bot) Subscribe(ctx context.Context, roomID, password string) error {
+	_, err := b.do(ctx, &chat.BotRequest{Action: &chat.BotRequest_Subscribe{
+		Subscribe: &chat.BotSubscribe{RoomId: roomID, Password: password},
+	}})
+	return err
+}
+
+// Unsubscribe leaves a room.
+func (b *Bot) Unsubscribe(ctx context.Context, roomID string) error {
+	_, err := b.do(ctx, &chat.BotRequest{Action: &chat.BotRequest_Unsubscribe{
+		Unsubscribe: &chat.BotUnsubscribe{RoomId: roomID},
+	}})
+	return err
+}
+
+// Send sends a message to a subscribed room and returns it as stored.
+func (b *Bot) Send(ctx context.Context, roomID, content string) (*chat.Message, error) {
+	return b.send(ctx, &chat.BotSend{RoomId: roomID, Content: content})
+}
+
+// Reply answers a message in its thread.
+func (b *Bot) Reply(ctx context.Context, msg *chat.Message, content string) (*chat.Message, error) {
+	parentID := msg.ParentId
+	if parentID == 0 {
+		parentID = msg.Id
+	}
+	return b.send(ctx, &chat.BotSend{RoomId: msg.RoomId, Content: content, ParentId: parentID})
+}
+
+// Emote sends an action, like a user's /me.
+func (b *Bot) Emote(ctx context.Context, roomID, action string) (*chat.Message, error) {
+	return b.send(ctx, &chat.BotSend{RoomId: roomID, Content: action, Emote: true})
+}
+
+func (b *Bot) send(ctx context.Context, send *chat.BotSend) (*chat.Message, error) {
+	result, err := b.do(ctx, &chat.BotRequest{Action: &chat.BotRequest_Send{Send: send}})
+	if err != nil {
+		return nil, err
+	}
+	return result.Message, nil
+}
+
+// React adds the bot's reaction to a message.
+func (b *Bot) React(ctx context.Context, messageID int64, emoji string) error {
+	_, err := b.do(ctx, &chat.BotRequest{Action: &chat.BotRequest_React{
+		React: &chat.BotReact{MessageId: messageID, Emoji: emoji},
+	}})
+	return err
+}
+
+// Unreact takes the bot's reaction to a message back.
+func (b *Bot) Unreact(ctx context.Context, messageID int64, emoji string) error {
+	_, err := b.do(ctx, &chat.BotRequest{Action: &chat.BotRequest_React{
+		React: &chat.BotReact{MessageId: messageID, Emoji: emoji, Remove: true},
+	}})
+	return err
+}
+
+// do sends a request and waits for its result.
+func (b *Bot) do(ctx context.Context, req *chat.BotRequest) (*chat.BotResult, error) {
+	req.Ref = strconv.FormatUint(b.nextRef.Add(1), 10)
+	answer := make(chan *chat.BotEvent, 1)
+
+	b.mu.Lock()
+	if b.err != nil {
+		err := b.err
+		b.mu.Unlock()
+		return nil, err
+	}
+	b.pending[req.Ref] = answer
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, req.Ref)
+		b.mu.Unlock()
+	}()
+
+	b.sendMu.Lock()
+	err := b.stream.Send(req)
+	b.sendMu.Unlock()
+	if err != nil {
+		// The reason comes from Recv, which ends the session
+		<-b.done
+		return nil, b.sessionErr()
+	}
+
+	select {
+	case event := <-answer:
+		if e := event.GetError(); e != nil {
+			return nil, status.Error(codes.Code(e.Code), e.Message)
+		}
+		return event.GetResult(), nil
+	case <-b.done:
+		return nil, b.sessionErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bot) sessionErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// receive reads the stream, handing results to the requests waiting for
+// them and queueing room events, until the session ends.
+func (b *Bot) receive() {
+	for {
+		event, err := b.stream.Recv()
+		if err != nil {
+			if err == io.EOF || status.Code(err) == codes.Canceled {
+				err = ErrClosed
+			}
+			b.finish(err)
+			return
+		}
+
+		b.mu.Lock()
+		if event.Ref != "" {
+			if answer, ok := b.pending[event.Ref]; ok {
+				answer <- event
+			}
+		} else if chatEvent := event.GetChat(); chatEvent != nil {
+			b.queue = append(b.queue, chatEvent)
+			select {
+			case b.queued <- struct{}{}:
+			default:
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// deliver moves queued events to the events channel. It closes the
+// channel once the session has ended and every event was delivered, or
+// when the bot is closed.
+func (b *Bot) deliver() {
+	defer close(b.events)
+	for {
+		b.mu.Lock()
+		var next *chat.ChatEvent
+		if len(b.queue) > 0 {
+			next = b.queue[0]
+			b.queue = b.queue[1:]
+		}
+		b.mu.Unlock()
+
+		if next == nil {
+			select {
+			case <-b.queued:
+				continue
+			case <-b.done:
+				// receive may have queued more before the session ended
+				b.mu.Lock()
+				empty := len(b.queue) == 0
+				b.mu.Unlock()
+				if empty {
+					return
+				}
+				continue
+			}
+		}
+		select {
+		case b.events <- next:
+		case <-b.closing:
+			return
+		}
+	}
+}
+
+// finish ends the session for the given reason, unless it already ended.
+func (b *Bot) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return
+	}
+	b.err = err
+	close(b.done)
+}
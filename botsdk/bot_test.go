@@ -0,0 +1,166 @@
+package botsdk
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testKey = "bot_test"
+
+// reversingServer answers each pair of requests in reverse order, echoing
+// sends and failing everything else, and pushes one chat event first.
+type reversingServer struct {
+	chat.UnimplementedBotServiceServer
+}
+
+func (reversingServer) Connect(stream chat.BotService_ConnectServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if keys := md.Get("authorization"); len(keys) == 0 || keys[0] != "Bearer "+testKey {
+		return status.Error(codes.Unauthenticated, "invalid bot API key")
+	}
+	ready := &chat.BotReady{BotId: "b1", UserId: "bot:b1", Name: "Test"}
+	if err := stream.Send(&chat.BotEvent{Event: &chat.BotEvent_Ready{Ready: ready}}); err != nil {
+		return err
+	}
+	event := &chat.ChatEvent{RoomId: "r1", Event: &chat.ChatEvent_Chat{Chat: &chat.Message{Content: "welcome"}}}
+	if err := stream.Send(&chat.BotEvent{Event: &chat.BotEvent_Chat{Chat: event}}); err != nil {
+		return err
+	}
+
+	for {
+		var pair []*chat.BotRequest
+		for len(pair) < 2 {
+			req, err := stream.Recv()
+			if err != nil {
+				return nil
+			}
+			pair = append(pair, req)
+		}
+		for i := len(pair) - 1; i >= 0; i-- {
+			answer := &chat.BotEvent{Ref: pair[i].Ref}
+			if send := pair[i].GetSend(); send != nil {
+				answer.Event = &chat.BotEvent_Result{Result: &chat.BotResult{Message: &chat.Message{Content: send.Content}}}
+			} else {
+				answer.Event = &chat.BotEvent_Error{Error: &chat.BotError{Code: uint32(codes.NotFound), Message: "room not found"}}
+			}
+			if err := stream.Send(answer); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func dial(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	chat.RegisterBotServiceServer(s, reversingServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConnect(t *testing.T) {
+	conn := dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Connect(ctx, conn, "bot_wrong"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Connect with a wrong key = %v, want Unauthenticated", err)
+	}
+
+	bot, err := Connect(ctx, conn, testKey)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer bot.Close()
+	if bot.ID() != "b1" || bot.UserID() != "bot:b1" || bot.Name() != "Test" {
+		t.Fatalf("bot = %s %s %s, want the ready frame's b1 bot:b1 Test", bot.ID(), bot.UserID(), bot.Name())
+	}
+	if !bot.Own(&chat.Message{UserId: "bot:b1"}) || bot.Own(&chat.Message{UserId: "alice"}) {
+		t.Fatal("Own does not match the bot's user ID")
+	}
+
+	select {
+	case event := <-bot.Events():
+		if event.GetChat().GetContent() != "welcome" {
+			t.Fatalf("event = %v, want the welcome message", event)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for an event")
+	}
+}
+
+func TestRequestsMatchResultsByRef(t *testing.T) {
+	conn := dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bot, err := Connect(ctx, conn, testKey)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer bot.Close()
+
+	// The server answers the second request of each pair first
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		msg, err := bot.Send(ctx, "r1", "first")
+		if err != nil || msg.Content != "first" {
+			t.Errorf("Send first = %v, %v", msg, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		err := bot.Subscribe(ctx, "missing", "")
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Subscribe = %v, want NotFound", err)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestClose(t *testing.T) {
+	conn := dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bot, err := Connect(ctx, conn, testKey)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	if err := bot.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := bot.Send(ctx, "r1", "late"); err != ErrClosed {
+		t.Fatalf("Send after Close = %v, want ErrClosed", err)
+	}
+	if err := bot.Run(ctx, func(*chat.ChatEvent) {}); err != nil {
+		t.Fatalf("Run after Close = %v, want nil", err)
+	}
+	if bot.Err() != nil {
+		t.Fatalf("Err after Close = %v, want nil", bot.Err())
+	}
+}
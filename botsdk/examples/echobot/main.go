@@ -0,0 +1,92 @@
+// Command echobot repeats what people say in its rooms, answering in
+// threads when the message was part of one.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"go-grpc-basic/botsdk"
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	chat_host, found := os.LookupEnv("CHAT_HOST")
+	if !found {
+		chat_host = "localhost"
+	}
+
+	bot_port, found := os.LookupEnv("BOT_PORT")
+	if !found {
+		bot_port = "50055"
+	}
+
+	api_key, found := os.LookupEnv("BOT_API_KEY")
+	if !found {
+		log.Fatalf("BOT_API_KEY is required")
+	}
+
+	// BOT_ROOMS is a comma-separated list of room IDs, each optionally
+	// followed by ":password"
+	rooms, found := os.LookupEnv("BOT_ROOMS")
+	if !found {
+		log.Fatalf("BOT_ROOMS is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	chat_conn, err := grpc.Dial(strings.Join([]string{chat_host, bot_port}, ":"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to chat service: %v", err)
+	}
+	defer chat_conn.Close()
+
+	bot, err := botsdk.Connect(ctx, chat_conn, api_key)
+	if err != nil {
+		log.Fatalf("Failed to connect bot: %v", err)
+	}
+	defer bot.Close()
+	log.Printf("Connected as %s", bot.Name())
+
+	for _, room := range strings.Split(rooms, ",") {
+		room_id, password, _ := strings.Cut(strings.TrimSpace(room), ":")
+		if room_id == "" {
+			continue
+		}
+		if err := bot.Subscribe(ctx, room_id, password); err != nil {
+			log.Fatalf("Failed to subscribe to %s: %v", room_id, err)
+		}
+		log.Printf("Subscribed to %s", room_id)
+	}
+
+	err = bot.Run(ctx, func(event *chat.ChatEvent) {
+		msg := event.GetChat()
+		if msg == nil {
+			msg = event.GetReply().GetMessage()
+		}
+		// Bots answering each other would never stop
+		if msg == nil || msg.Bot || bot.Own(msg) || msg.Content == "" {
+			return
+		}
+
+		var err error
+		if msg.ParentId != 0 {
+			_, err = bot.Reply(ctx, msg, msg.Content)
+		} else {
+			_, err = bot.Send(ctx, msg.RoomId, msg.Content)
+		}
+		if err != nil {
+			log.Printf("Failed to echo message %d: %v", msg.Id, err)
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("Bot session ended: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxAttachmentsPerMessage caps how many files one message can carry.
+	maxAttachmentsPerMessage = 10
+	// maxFilenameLength is in bytes.
+	maxFilenameLength = 255
+	// unsentAttachmentTTL is how long uploads wait to be sent before they
+	// are removed.
+	unsentAttachmentTTL = 24 * time.Hour
+)
+
+// allowedAttachmentTypes are the content types, as detected by
+// http.DetectContentType, that may be uploaded.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"application/zip":           true,
+	"text/plain; charset=utf-8": true,
+}
+
+// thumbnailTypes are the image types the service can make thumbnails of.
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+func attachmentKey(id string) string { return "attachments/" + id }
+func thumbnailKey(id string) string  { return "thumbnails/" + id }
+
+// uploadAttachment stores a file the user uploaded to a room. The content
+// type is detected from the data rather than trusted from the client.
+func (h *Hub) uploadAttachment(ctx context.Context, userID, roomID, filename string, data []byte) (*StoredAttachment, error) {
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.notArchived(room); err != nil {
+		return nil, err
+	}
+	if room.Ephemeral {
+		return nil, status.Error(codes.FailedPrecondition, "ephemeral rooms do not keep attachments")
+	}
+	member, err := h.isMember(ctx, room, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, status.Error(codes.PermissionDenied, "join the room first")
+	}
+	return h.storeAttachment(ctx, room, userID, filename, data)
+}
+
+// storeAttachment checks and stores a file for the uploader to send to a
+// room.
+func (h *Hub) storeAttachment(ctx context.Context, room *Room, uploaderID, filename string, data []byte) (*StoredAttachment, error) {
+	if len(data) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty file")
+	}
+	if int64(len(data)) > h.maxAttachmentSize {
+		return nil, status.Error(codes.ResourceExhausted, "file too large")
+	}
+	contentType := http.DetectContentType(data)
+	if !allowedAttachmentTypes[contentType] {
+		return nil, status.Errorf(codes.InvalidArgument, "file type %s is not allowed", contentType)
+	}
+
+	a := &StoredAttachment{
+		ID:          uuid.New().String(),
+		RoomID:      room.ID,
+		UploaderID:  uploaderID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		if width, height, err := imageSize(data); err == nil {
+			a.Width, a.Height = width, height
+		}
+	}
+
+	if err := h.blobs.Put(ctx, attachmentKey(a.ID), bytes.NewReader(data), a.Size, contentType); err != nil {
+		return nil, err
+	}
+	if thumbnailTypes[contentType] {
+		// Images without a thumbnail are still sent, just not previewed
+		thumb, thumbType, err := thumbnail(data)
+		if err != nil {
+			log.Printf("Error making thumbnail: %v", err)
+		} else if err := h.blobs.Put(ctx, thumbnailKey(a.ID), bytes.NewReader(thumb), int64(len(thumb)), thumbType); err != nil {
+			log.Printf("Error storing thumbnail: %v", err)
+		} else {
+			a.ThumbnailType = thumbType
+		}
+	}
+
+	if err := h.attachments.SaveAttachment(ctx, a); err != nil {
+		h.deleteBlobs(ctx, []StoredAttachment{*a})
+		return nil, err
+	}
+	return a, nil
+}
+
+// cleanFilename makes a client-supplied filename safe to store and to show:
+// the last path element, without control characters, capped in length.
+// Bidirectional controls go too, since they can disguise "gnp.exe" as
+// "exe.png".
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// openAttachment returns an attachment the user may download along with its
+// contents, or those of its thumbnail. Unsent attachments are only visible
+// to their uploader.
+func (h *Hub) openAttachment(ctx context.Context, userID, id string, thumbnail bool) (*StoredAttachment, io.ReadCloser, error) {
+	a, err := h.attachments.GetAttachment(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, status.Error(codes.NotFound, "attachment not found")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if a.MessageID == 0 {
+		if a.UploaderID != userID {
+			return nil, nil, status.Error(codes.NotFound, "attachment not found")
+		}
+	} else {
+		room, err := h.room(a.RoomID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := h.canRead(ctx, room, userID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key := attachmentKey(a.ID)
+	if thumbnail {
+		if a.ThumbnailType == "" {
+			return nil, nil, status.Error(codes.NotFound, "attachment has no thumbnail")
+		}
+		key = thumbnailKey(a.ID)
+	}
+	body, err := h.blobs.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		return nil, nil, status.Error(codes.NotFound, "attachment not found")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, body, nil
+}
+
+// resolveAttachments turns the IDs sent with a message into attachments for
+// SaveMessage, which checks that they can be sent.
+func resolveAttachments(ids []string) ([]StoredAttachment, error) {
+	if len(ids) > maxAttachmentsPerMessage {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d attachments per message", maxAttachmentsPerMessage)
+	}
+	attachments := make([]StoredAttachment, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			return nil, status.Error(codes.InvalidArgument, "invalid attachment")
+		}
+		seen[id] = true
+		attachments = append(attachments, StoredAttachment{ID: id})
+	}
+	return attachments, nil
+}
+
+// deleteBlobs removes the stored contents of attachments. Failures leave
+// unreachable blobs behind, so they are only logged.
+func (h *Hub) deleteBlobs(ctx context.Context, attachments []StoredAttachment) {
+	for _, a := range attachments {
+		if err := h.blobs.Delete(ctx, attachmentKey(a.ID)); err != nil {
+			log.Printf("Error deleting attachment: %v", err)
+		}
+		if a.ThumbnailType != "" {
+			if err := h.blobs.Delete(ctx, thumbnailKey(a.ID)); err != nil {
+				log.Printf("Error deleting thumbnail: %v", err)
+			}
+		}
+	}
+}
+
+// sweepUploads periodically removes attachments that were uploaded but
+// never sent.
+func (h *Hub) sweepUploads(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		unsent, err := h.attachments.UnsentAttachments(ctx, time.Now().Add(-unsentAttachmentTTL))
+		if err != nil {
+			log.Printf("Error listing unsent attachments: %v", err)
+			continue
+		}
+		if len(unsent) == 0 {
+			continue
+		}
+		ids := make([]string, len(unsent))
+		for i, a := range unsent {
+			ids[i] = a.ID
+		}
+		if err := h.attachments.DeleteAttachments(ctx, ids); err != nil {
+			log.Printf("Error deleting unsent attachments: %v", err)
+			continue
+		}
+		h.deleteBlobs(ctx, unsent)
+	}
+}
+
+func chatAttachmentsFrom(attachments []StoredAttachment) []*chat.Attachment {
+	if len(attachments) == 0 {
+		return nil
+	}
+	out := make([]*chat.Attachment, len(attachments))
+	for i, a := range attachments {
+		out[i] = chatAttachmentFrom(&a)
+	}
+	return out
+}
+
+func chatAttachmentFrom(a *StoredAttachment) *chat.Attachment {
+	return &chat.Attachment{
+		Id:           a.ID,
+		Filename:     a.Filename,
+		ContentType:  a.ContentType,
+		Size:         a.Size,
+		Width:        int32(a.Width),
+		Height:       int32(a.Height),
+		HasThumbnail: a.ThumbnailType != "",
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newAttachmentHub returns a test hub keeping blobs on disk, with a small
+// size limit.
+func newAttachmentHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := newTestHub(t)
+	blobs, err := newDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("newDiskBlobStore: %v", err)
+	}
+	hub.blobs = blobs
+	hub.maxAttachmentSize = 64 << 10
+	return hub
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStoreAttachment(t *testing.T) {
+	hub := newAttachmentHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	data := encodePNG(t, 300, 100)
+
+	a, err := hub.storeAttachment(ctx, room, "alice", "../../photos/cat.png", data)
+	if err != nil {
+		t.Fatalf("storeAttachment: %v", err)
+	}
+	if a.Filename != "cat.png" || a.ContentType != "image/png" || a.Size != int64(len(data)) || a.Width != 300 || a.Height != 100 {
+		t.Fatalf("attachment = %+v", a)
+	}
+	if a.ThumbnailType != "image/png" {
+		t.Fatalf("thumbnail type = %q, want image/png", a.ThumbnailType)
+	}
+
+	r, err := hub.blobs.Get(ctx, attachmentKey(a.ID))
+	if err != nil {
+		t.Fatalf("Get blob: %v", err)
+	}
+	stored, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(stored, data) {
+		t.Fatal("stored blob differs from the upload")
+	}
+	if _, err := hub.attachments.GetAttachment(ctx, a.ID); err != nil {
+		t.Fatalf("GetAttachment: %v", err)
+	}
+}
+
+func TestStoreAttachmentRejects(t *testing.T) {
+	hub := newAttachmentHub(t)
+	room := createTestRoom(t, hub, "alice")
+
+	tests := []struct {
+		name string
+		data []byte
+		code codes.Code
+	}{
+		{"empty", nil, codes.InvalidArgument},
+		{"too large", bytes.Repeat([]byte("a"), int(hub.maxAttachmentSize)+1), codes.ResourceExhausted},
+		{"html", []byte("<!DOCTYPE html><script>alert(1)</script>"), codes.InvalidArgument},
+		// Named like an image, sniffed as an executable
+		{"disguised", append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 64)...), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hub.storeAttachment(context.Background(), room, "alice", "photo.png", tt.data)
+			if status.Code(err) != tt.code {
+				t.Fatalf("storeAttachment = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	long := strings.Repeat("a", maxFilenameLength-1) + "é"
+	tests := map[string]string{
+		"report.pdf":             "report.pdf",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\notes.txt`:  "notes.txt",
+		"dir/":                   "dir",
+		"":                       "file",
+		".":                      "file",
+		"/":                      "file",
+		"..":                     "..",
+		"  spaced.txt  ":         "spaced.txt",
+		"bell\a\x00name\n.txt":   "bellname.txt",
+		"bad\xffutf8.txt":        "badutf8.txt",
+		"\u202egnp.exe":          "gnp.exe",
+		long:                     strings.Repeat("a", maxFilenameLength-1),
+		strings.Repeat("b", 300): strings.Repeat("b", maxFilenameLength),
+	}
+	for name, want := range tests {
+		if got := cleanFilename(name); got != want {
+			t.Errorf("cleanFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
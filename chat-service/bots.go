@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Bots are programs that take part in rooms over BotService, with an API
+// key instead of a login. Each acts as the user "bot:<id>", shown with the
+// bot's name, and joins rooms under the same rules as users do.
+
+const (
+	// maxBotsPerUser caps how many bots one user can own.
+	maxBotsPerUser = 10
+	// botKeyPrefix makes API keys recognizable, e.g. in leaked logs.
+	botKeyPrefix = "bot_"
+)
+
+// botUserID is who a bot acts as in rooms.
+func botUserID(botID string) string { return "bot:" + botID }
+
+func (h *Hub) createBot(ctx context.Context, ownerID, name string) (*chat.Bot, error) {
+	name = strings.TrimSpace(name)
+	if !validBotName(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "names are a single line of 1 to %d characters", maxBotNameLength)
+	}
+	existing, err := h.bots.Bots(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxBotsPerUser {
+		return nil, status.Errorf(codes.ResourceExhausted, "at most %d bots per user", maxBotsPerUser)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := botKeyPrefix + hex.EncodeToString(secret)
+	b := &StoredBot{
+		ID:      uuid.New().String(),
+		Name:    name,
+		OwnerID: ownerID,
+		KeyHash: hashToken(key),
+	}
+	if err := h.bots.SaveBot(ctx, b); err != nil {
+		return nil, err
+	}
+
+	created := chatBotFrom(*b)
+	created.ApiKey = key
+	return created, nil
+}
+
+func (h *Hub) listBots(ctx context.Context, ownerID string) ([]*chat.Bot, error) {
+	stored, err := h.bots.Bots(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	bots := make([]*chat.Bot, 0, len(stored))
+	for _, b := range stored {
+		bots = append(bots, chatBotFrom(b))
+	}
+	return bots, nil
+}
+
+// deleteBot removes one of the user's bots and ends its sessions.
+func (h *Hub) deleteBot(ctx context.Context, ownerID, id string) error {
+	b, err := h.bots.GetBot(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && b.OwnerID != ownerID) {
+		return status.Error(codes.NotFound, "bot not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.bots.DeleteBot(ctx, b.ID); err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients[botUserID(b.ID)] {
+		client.close()
+	}
+	return nil
+}
+
+// authenticateBot returns the bot whose API key is in the request's
+// authorization metadata.
+func (h *Hub) authenticateBot(ctx context.Context) (*StoredBot, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key string
+	if values := md.Get("authorization"); len(values) > 0 {
+		key, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if !strings.HasPrefix(key, botKeyPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "missing bot API key")
+	}
+
+	b, err := h.bots.BotByKey(ctx, hashToken(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.Unauthenticated, "invalid bot API key")
+	}
+	return b, err
+}
+
+type botServer struct {
+	chat.UnimplementedBotServiceServer
+	hub *Hub
+}
+
+// botSession is a bot's Connect stream. Its client receives room events
+// like a user's stream; results carries the answers to its requests.
+type botSession struct {
+	client  *Client
+	results chan *chat.BotEvent
+}
+
+func (s *botServer) Connect(stream chat.BotService_ConnectServer) error {
+	b, err := s.hub.authenticateBot(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	session := &botSession{
+		client: &Client{
+			send:      make(chan *chat.ChatEvent, 256),
+			done:      make(chan struct{}),
+			username:  b.Name,
+			userID:    botUserID(b.ID),
+			sessionID: uuid.New().String(),
+			rooms:     make(map[string]*Room),
+			typing:    make(map[string]*typingState),
+		},
+		results: make(chan *chat.BotEvent, 64),
+	}
+	ready := &chat.BotReady{BotId: b.ID, UserId: session.client.userID, Name: b.Name}
+	if err := stream.Send(&chat.BotEvent{Event: &chat.BotEvent_Ready{Ready: ready}}); err != nil {
+		return err
+	}
+
+	s.hub.addClient(session.client)
+	s.hub.setPresence(stream.Context(), session.client, "", true)
+	go session.readPump(s.hub, stream)
+	if err := session.writePump(stream); err != nil {
+		return err
+	}
+	// Tell the bot apart from a clean close when deleteBot ended the session
+	if _, err := s.hub.bots.GetBot(stream.Context(), b.ID); errors.Is(err, sql.ErrNoRows) {
+		return status.Error(codes.Unauthenticated, "bot was deleted")
+	}
+	return nil
+}
+
+func (s *botSession) readPump(hub *Hub, stream chat.BotService_ConnectServer) {
+	c := s.client
+	defer c.disconnect(hub)
+
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				log.Printf("Bot stream error: %v", err)
+			}
+			return
+		}
+
+		result, err := s.handle(ctx, hub, req)
+		event := &chat.BotEvent{Ref: req.Ref}
+		if err != nil {
+			event.Event = &chat.BotEvent_Error{Error: botError(err)}
+		} else {
+			event.Event = &chat.BotEvent_Result{Result: result}
+		}
+		select {
+		case s.results <- event:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handle carries out one request of the bot.
+func (s *botSession) handle(ctx context.Context, hub *Hub, req *chat.BotRequest) (*chat.BotResult, error) {
+	c := s.client
+	switch action := req.Action.(type) {
+	case *chat.BotRequest_Subscribe:
+		join := &chat.ChatRequest{RoomId: action.Subscribe.RoomId, Password: action.Subscribe.Password}
+		if err := c.join(ctx, hub, join); err != nil {
+			return nil, err
+		}
+		return &chat.BotResult{}, nil
+	case *chat.BotRequest_Unsubscribe:
+		if err := c.leave(ctx, hub, action.Unsubscribe.RoomId); err != nil {
+			return nil, err
+		}
+		return &chat.BotResult{}, nil
+	case *chat.BotRequest_Send:
+		send := action.Send
+		room, err := c.actionRoom(hub, send.RoomId)
+		if err != nil {
+			return nil, err
+		}
+		draft := StoredMessage{
+			UserID:   c.userID,
+			Username: c.name(),
+			Content:  send.Content,
+			ParentID: send.ParentId,
+			Emote:    send.Emote,
+			Bot:      true,
+		}
+		msg, err := hub.sendMessage(ctx, room, draft, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &chat.BotResult{Message: msg}, nil
+	case *chat.BotRequest_React:
+		react := action.React
+		if err := hub.react(ctx, c.userID, react.MessageId, react.Emoji, !react.Remove); err != nil {
+			return nil, err
+		}
+		return &chat.BotResult{}, nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unknown action")
+	}
+}
+
+// writePump sends the bot's room events and results down the stream until
+// the client is closed. Room events queued before a result go first, so a
+// subscribe's joined and history events arrive before its result.
+func (s *botSession) writePump(stream chat.BotService_ConnectServer) error {
+	c := s.client
+	sendEvents := func() error {
+		for {
+			select {
+			case event := <-c.send:
+				if err := stream.Send(&chat.BotEvent{Event: &chat.BotEvent_Chat{Chat: event}}); err != nil {
+					return err
+				}
+			default:
+				return nil
+			}
+		}
+	}
+
+	for {
+		select {
+		case event := <-c.send:
+			if err := stream.Send(&chat.BotEvent{Event: &chat.BotEvent_Chat{Chat: event}}); err != nil {
+				return err
+			}
+		case result := <-s.results:
+			if err := sendEvents(); err != nil {
+				return err
+			}
+			if err := stream.Send(result); err != nil {
+				return err
+			}
+		case <-c.done:
+			return sendEvents()
+		}
+	}
+}
+
+// botError describes a failed request, hiding internal errors.
+func botError(err error) *chat.BotError {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.Unknown || st.Code() == codes.Internal {
+		log.Printf("Error handling bot request: %v", err)
+		return &chat.BotError{Code: uint32(codes.Internal), Message: "internal error"}
+	}
+	return &chat.BotError{Code: uint32(st.Code()), Message: st.Message()}
+}
+
+func chatBotFrom(b StoredBot) *chat.Bot {
+	return &chat.Bot{
+		Id:        b.ID,
+		UserId:    botUserID(b.ID),
+		Name:      b.Name,
+		OwnerId:   b.OwnerID,
+		CreatedAt: b.CreatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go-grpc-basic/botsdk"
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakePresence stands in for the presence service, which knows nobody.
+type fakePresence struct {
+	presence.PresenceServiceClient
+}
+
+func (fakePresence) UpdatePresence(context.Context, *presence.UpdatePresenceRequest, ...grpc.CallOption) (*presence.UpdatePresenceResponse, error) {
+	return &presence.UpdatePresenceResponse{}, nil
+}
+
+func (fakePresence) GetPresence(context.Context, *presence.GetPresenceRequest, ...grpc.CallOption) (*presence.GetPresenceResponse, error) {
+	return &presence.GetPresenceResponse{}, nil
+}
+
+func (fakePresence) GetRoomPresence(context.Context, *presence.GetRoomPresenceRequest, ...grpc.CallOption) (*presence.GetRoomPresenceResponse, error) {
+	return &presence.GetRoomPresenceResponse{}, nil
+}
+
+// newTestHub runs a hub backed by a fresh SQLite database.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	store, err := newSQLiteStore(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("newSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	hub := newHub(fakePresence{}, store, store)
+	hub.moderators = make(map[string]bool)
+	hub.attachments = store
+	hub.bots = store
+	go hub.run()
+	return hub
+}
+
+// dialBotService serves BotService over an in-memory listener and returns a
+// connection to it.
+func dialBotService(t *testing.T, hub *Hub) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	chat.RegisterBotServiceServer(s, &botServer{hub: hub})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func createTestBot(t *testing.T, hub *Hub, ownerID string) *chat.Bot {
+	t.Helper()
+	b, err := hub.createBot(context.Background(), ownerID, "Echo")
+	if err != nil {
+		t.Fatalf("createBot: %v", err)
+	}
+	return b
+}
+
+func connectTestBot(t *testing.T, conn *grpc.ClientConn, apiKey string) *botsdk.Bot {
+	t.Helper()
+	bot, err := botsdk.Connect(testContext(t), conn, apiKey)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { bot.Close() })
+	return bot
+}
+
+func createTestRoom(t *testing.T, hub *Hub, ownerID string) *Room {
+	t.Helper()
+	room, err := hub.createRoom(context.Background(), ownerID, &chat.CreateRoomRequest{Name: "general", MaxMembers: 10})
+	if err != nil {
+		t.Fatalf("createRoom: %v", err)
+	}
+	return room
+}
+
+// nextEvent returns the bot's next event that matches, skipping others.
+func nextEvent(t *testing.T, bot *botsdk.Bot, match func(*chat.ChatEvent) bool) *chat.ChatEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-bot.Events():
+			if !ok {
+				t.Fatalf("session ended: %v", bot.Err())
+			}
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+func TestBotConnectRejectsBadKeys(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+
+	deleted := createTestBot(t, hub, "alice")
+	if err := hub.deleteBot(context.Background(), "alice", deleted.Id); err != nil {
+		t.Fatalf("deleteBot: %v", err)
+	}
+
+	for name, key := range map[string]string{
+		"missing": "",
+		"bad":     botKeyPrefix + "0000",
+		"deleted": deleted.ApiKey,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := botsdk.Connect(testContext(t), conn, key)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("Connect error = %v, want Unauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestBotDeleteEndsSession(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+	b := createTestBot(t, hub, "alice")
+	bot := connectTestBot(t, conn, b.ApiKey)
+
+	if err := hub.deleteBot(context.Background(), "bob", b.Id); status.Code(err) != codes.NotFound {
+		t.Fatalf("deleteBot by another user = %v, want NotFound", err)
+	}
+	if err := hub.deleteBot(context.Background(), "alice", b.Id); err != nil {
+		t.Fatalf("deleteBot: %v", err)
+	}
+
+	err := bot.Run(testContext(t), func(*chat.ChatEvent) {})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Run = %v, want Unauthenticated", err)
+	}
+}
+
+func TestBotReady(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+	b := createTestBot(t, hub, "alice")
+
+	stream, err := chat.NewBotServiceClient(conn).Connect(metadata.AppendToOutgoingContext(testContext(t), "authorization", "Bearer "+b.ApiKey))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	ready := event.GetReady()
+	if ready == nil {
+		t.Fatalf("first event = %v, want ready", event)
+	}
+	if ready.BotId != b.Id || ready.UserId != botUserID(b.Id) || ready.Name != "Echo" {
+		t.Fatalf("ready = %v, want bot %s as %s named Echo", ready, b.Id, botUserID(b.Id))
+	}
+}
+
+func TestBotSubscribeReceivesEvents(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+	bot := connectTestBot(t, conn, createTestBot(t, hub, "alice").ApiKey)
+	room := createTestRoom(t, hub, "alice")
+	ctx := testContext(t)
+
+	if err := bot.Subscribe(ctx, "missing", ""); status.Code(err) != codes.NotFound {
+		t.Fatalf("Subscribe to a missing room = %v, want NotFound", err)
+	}
+	if err := bot.Subscribe(ctx, room.ID, ""); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	// Joined and history are queued before Subscribe returns
+	nextEvent(t, bot, func(e *chat.ChatEvent) bool { return e.GetJoined() != nil })
+	nextEvent(t, bot, func(e *chat.ChatEvent) bool { return e.GetHistory() != nil })
+
+	_, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "alice", Username: "alice", Content: "hello bot"}, nil)
+	if err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	event := nextEvent(t, bot, func(e *chat.ChatEvent) bool { return e.GetChat() != nil })
+	if msg := event.GetChat(); msg.Content != "hello bot" || msg.UserId != "alice" || bot.Own(msg) {
+		t.Fatalf("chat event = %v, want alice's message", msg)
+	}
+
+	if err := bot.Unsubscribe(ctx, room.ID); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if _, err := bot.Send(ctx, room.ID, "gone"); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Send after Unsubscribe = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestBotSendAndReact(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+	bot := connectTestBot(t, conn, createTestBot(t, hub, "alice").ApiKey)
+	room := createTestRoom(t, hub, "alice")
+	ctx := testContext(t)
+	if err := bot.Subscribe(ctx, room.ID, ""); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Concurrent requests each get their own result back
+	var wg sync.WaitGroup
+	sent := make([]*chat.Message, 8)
+	for i := range sent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := bot.Send(ctx, room.ID, fmt.Sprintf("message %d", i))
+			if err != nil {
+				t.Errorf("Send %d: %v", i, err)
+				return
+			}
+			sent[i] = msg
+		}()
+	}
+	wg.Wait()
+	for i, msg := range sent {
+		if msg == nil {
+			continue
+		}
+		if want := fmt.Sprintf("message %d", i); msg.Content != want || !msg.Bot || !bot.Own(msg) {
+			t.Errorf("result %d = %v, want the bot's %q", i, msg, want)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	if _, err := bot.Send(ctx, room.ID, ""); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Send of an empty message = %v, want InvalidArgument", err)
+	}
+
+	if err := bot.React(ctx, sent[0].Id, "👍"); err != nil {
+		t.Fatalf("React: %v", err)
+	}
+	event := nextEvent(t, bot, func(e *chat.ChatEvent) bool { return e.GetReaction() != nil })
+	if r := event.GetReaction(); r.MessageId != sent[0].Id || r.Emoji != "👍" || r.UserId != bot.UserID() || !r.Added {
+		t.Fatalf("reaction event = %v, want the bot's 👍 on message %d", r, sent[0].Id)
+	}
+	if err := bot.Unreact(ctx, sent[0].Id, "👍"); err != nil {
+		t.Fatalf("Unreact: %v", err)
+	}
+}
+
+// TestBotResultRefs drives the stream directly to check that results and
+// errors carry the ref of their request.
+func TestBotResultRefs(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialBotService(t, hub)
+	b := createTestBot(t, hub, "alice")
+	room := createTestRoom(t, hub, "alice")
+
+	stream, err := chat.NewBotServiceClient(conn).Connect(metadata.AppendToOutgoingContext(testContext(t), "authorization", "Bearer "+b.ApiKey))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv ready: %v", err)
+	}
+
+	requests := []*chat.BotRequest{
+		{Ref: "join", Action: &chat.BotRequest_Subscribe{Subscribe: &chat.BotSubscribe{RoomId: room.ID}}},
+		{Ref: "send", Action: &chat.BotRequest_Send{Send: &chat.BotSend{RoomId: room.ID, Content: "hi"}}},
+		{Ref: "bad", Action: &chat.BotRequest_React{React: &chat.BotReact{MessageId: 999, Emoji: "👍"}}},
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send %s: %v", req.Ref, err)
+		}
+	}
+
+	answers := make(map[string]*chat.BotEvent)
+	for len(answers) < len(requests) {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if event.Ref != "" {
+			answers[event.Ref] = event
+		}
+	}
+	if answers["join"].GetResult() == nil {
+		t.Errorf("join answer = %v, want a result", answers["join"])
+	}
+	if msg := answers["send"].GetResult().GetMessage(); msg.GetContent() != "hi" {
+		t.Errorf("send answer = %v, want the sent message", answers["send"])
+	}
+	if e := answers["bad"].GetError(); e == nil || codes.Code(e.Code) != codes.NotFound {
+		t.Errorf("bad react answer = %v, want a NotFound error", answers["bad"])
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxConversationSize caps the participants of a direct conversation,
+// the caller included.
+const maxConversationSize = 8
+
+func (r *Room) hasParticipant(userID string) bool {
+	for _, p := range r.Participants {
+		if p == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// conversationID derives a conversation's room ID from its participants, so
+// the same set of users always shares one conversation.
+func conversationID(participants []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(participants, "\n")))
+	return "dm-" + hex.EncodeToString(sum[:16])
+}
+
+// openConversation returns the conversation between callerID and userIDs,
+// creating and storing it the first time.
+func (h *Hub) openConversation(ctx context.Context, callerID string, userIDs []string) (*Room, error) {
+	seen := map[string]bool{callerID: true}
+	participants := []string{callerID}
+	for _, id := range userIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			participants = append(participants, id)
+		}
+	}
+	if len(participants) < 2 {
+		return nil, status.Error(codes.InvalidArgument, "a conversation needs at least one other user")
+	}
+	if len(participants) > maxConversationSize {
+		return nil, status.Errorf(codes.InvalidArgument, "a conversation has at most %d participants", maxConversationSize)
+	}
+	sort.Strings(participants)
+	id := conversationID(participants)
+
+	h.openMu.Lock()
+	defer h.openMu.Unlock()
+
+	h.mu.RLock()
+	room, exists := h.rooms[id]
+	h.mu.RUnlock()
+	if exists {
+		return room, nil
+	}
+
+	room = &Room{
+		ID:           id,
+		Name:         strings.Join(participants, ", "),
+		MaxMembers:   len(participants),
+		Members:      make(map[*Client]bool),
+		CreatedBy:    callerID,
+		CreatedAt:    time.Now(),
+		Direct:       true,
+		Participants: participants,
+	}
+	err := h.roomStore.SaveRoom(ctx, &StoredRoom{
+		ID:           room.ID,
+		Name:         room.Name,
+		Owner:        room.CreatedBy,
+		MaxMembers:   room.MaxMembers,
+		CreatedAt:    room.CreatedAt,
+		Direct:       true,
+		Participants: participants,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.rooms[room.ID] = room
+	h.mu.Unlock()
+	return room, nil
+}
+
+// participantPresence returns every participant of a conversation with
+// their presence as visible to viewerID. Participants the presence service
+// has never seen are reported offline.
+func (h *Hub) participantPresence(ctx context.Context, room *Room, viewerID string) ([]*chat.Member, error) {
+	resp, err := h.presence.GetPresence(ctx, &presence.GetPresenceRequest{
+		UserIds:  room.Participants,
+		ViewerId: viewerID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*presence.UserPresence, len(resp.GetPresences()))
+	for _, p := range resp.GetPresences() {
+		known[p.UserId] = p
+	}
+
+	members := make([]*chat.Member, 0, len(room.Participants))
+	for _, userID := range room.Participants {
+		member := &chat.Member{UserId: userID}
+		if p, ok := known[userID]; ok {
+			member.Online = p.Online
+			member.LastActive = p.LastActive
+			member.ActiveConnections = p.ActiveConnections
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// listConversations returns the user's conversations, most recently active
+// first.
+func (h *Hub) listConversations(ctx context.Context, userID string) ([]*chat.Conversation, error) {
+	h.mu.RLock()
+	var rooms []*Room
+	for _, room := range h.rooms {
+		if room.Direct && room.hasParticipant(userID) {
+			rooms = append(rooms, room)
+		}
+	}
+	h.mu.RUnlock()
+
+	conversations := make([]*chat.Conversation, 0, len(rooms))
+	for _, room := range rooms {
+		conv := &chat.Conversation{}
+
+		last, err := h.messages.MessagesBefore(ctx, room.ID, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(last) > 0 {
+			conv.LastMessage = chatMessageFrom(last[0])
+		}
+		if conv.Unread, err = h.messages.UnreadCount(ctx, room.ID, userID); err != nil {
+			return nil, err
+		}
+		if conv.Participants, err = h.participantPresence(ctx, room, userID); err != nil {
+			log.Printf("Error fetching participant presence: %v", err)
+		}
+
+		h.mu.RLock()
+		conv.Room = roomInfo(room)
+		h.mu.RUnlock()
+		conversations = append(conversations, conv)
+	}
+
+	sort.Slice(conversations, func(i, j int) bool {
+		return lastActivity(conversations[i]) > lastActivity(conversations[j])
+	})
+	return conversations, nil
+}
+
+func lastActivity(conv *chat.Conversation) int64 {
+	if conv.LastMessage != nil {
+		return conv.LastMessage.Time
+	}
+	return conv.Room.CreatedAt
+}
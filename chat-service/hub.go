@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// historyOnJoin is how many recent messages a client receives on join.
+	historyOnJoin = 50
+	// maxHistoryPage caps the page size of ListMessages.
+	maxHistoryPage = 100
+)
+
+func newHub(presenceClient presence.PresenceServiceClient, messages MessageStore, roomStore RoomStore) *Hub {
+	return &Hub{
+		rooms:      make(map[string]*Room),
+		clients:    make(map[string]map[*Client]bool),
+		register:   make(chan Membership),
+		unregister: make(chan Membership),
+		broadcast:  make(chan BroadcastMessage),
+		closeRoom:  make(chan RoomClosure),
+		presence:   presenceClient,
+		messages:   messages,
+		roomStore:  roomStore,
+
+		presenceUpdates: make(chan *presence.PresenceUpdate, 64),
+		presenceRooms:   make(map[string][]string),
+	}
+}
+
+// loadRooms restores persisted rooms into the hub.
+func (h *Hub) loadRooms(ctx context.Context) error {
+	stored, err := h.roomStore.ListRooms(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range stored {
+		h.rooms[r.ID] = &Room{
+			ID:           r.ID,
+			Name:         r.Name,
+			PasswordHash: r.PasswordHash,
+			MaxMembers:   r.MaxMembers,
+			Members:      make(map[*Client]bool),
+			CreatedBy:    r.Owner,
+			CreatedAt:    r.CreatedAt,
+			Archived:     r.Archived,
+			Direct:       r.Direct,
+			Participants: r.Participants,
+			Topic:        r.Topic,
+		}
+	}
+	return nil
+}
+
+// addClient indexes a new stream by its user, so conversations reach it.
+func (h *Hub) addClient(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	streams, ok := h.clients[c.userID]
+	if !ok {
+		streams = make(map[*Client]bool)
+		h.clients[c.userID] = streams
+	}
+	streams[c] = true
+}
+
+func (h *Hub) removeClient(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients[c.userID], c)
+	if len(h.clients[c.userID]) == 0 {
+		delete(h.clients, c.userID)
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case m := <-h.register:
+			h.mu.Lock()
+			m.Room.Members[m.Client] = true
+			h.mu.Unlock()
+			// Notify room about new member
+			if !m.Room.Direct {
+				h.broadcastToRoom(m.Room.ID, systemEvent(m.Room.ID, fmt.Sprintf("%s joined the room", m.Client.name())))
+				h.webhooks.emit(m.Room.ID, "join", webhookUser(m.Client))
+			}
+
+		case m := <-h.unregister:
+			room := m.Room
+			if _, ok := room.Members[m.Client]; ok {
+				h.mu.Lock()
+				delete(room.Members, m.Client)
+				h.mu.Unlock()
+				// Notify room about member leaving
+				if !room.Direct {
+					h.broadcastToRoom(room.ID, systemEvent(room.ID, fmt.Sprintf("%s left the room", m.Client.name())))
+					h.webhooks.emit(room.ID, "leave", webhookUser(m.Client))
+				}
+			}
+			if len(room.Members) == 0 && room.Ephemeral {
+				h.mu.Lock()
+				delete(h.rooms, room.ID)
+				h.mu.Unlock()
+			}
+
+		case closure := <-h.closeRoom:
+			h.disconnectRoom(closure)
+
+		case msg := <-h.broadcast:
+			if msg.UserID != "" {
+				h.sendToUser(msg.UserID, msg.Event)
+			} else {
+				h.broadcastToRoom(msg.RoomID, msg.Event)
+			}
+
+		case update := <-h.presenceUpdates:
+			h.forwardPresence(update)
+		}
+	}
+}
+
+func systemEvent(roomID, content string) *chat.ChatEvent {
+	return &chat.ChatEvent{
+		RoomId: roomID,
+		Event:  &chat.ChatEvent_System{System: &chat.Notice{Content: content}},
+	}
+}
+
+func errorEvent(roomID, message string) *chat.ChatEvent {
+	return &chat.ChatEvent{
+		RoomId: roomID,
+		Event:  &chat.ChatEvent_Error{Error: &chat.Error{Message: message}},
+	}
+}
+
+// disconnectRoom tells every member of a room, or only closure.UserID, why
+// they are disconnected and removes them from it. Their streams stay open
+// for their other rooms. It must only be called from the run loop.
+func (h *Hub) disconnectRoom(closure RoomClosure) {
+	notice := &chat.ChatEvent{
+		RoomId: closure.RoomID,
+		Event:  &chat.ChatEvent_RoomClosed{RoomClosed: &chat.Notice{Content: closure.Reason}},
+	}
+
+	h.mu.RLock()
+	room, exists := h.rooms[closure.RoomID]
+	h.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	for client := range room.Members {
+		if closure.UserID != "" && client.userID != closure.UserID {
+			continue
+		}
+		select {
+		case client.send <- notice:
+		default:
+		}
+		h.mu.Lock()
+		delete(room.Members, client)
+		h.mu.Unlock()
+		client.removeRoom(room.ID)
+		if closure.UserID != "" {
+			h.webhooks.emit(room.ID, "leave", webhookUser(client))
+		}
+		// Off the run loop: stopTyping waits on it.
+		go func() {
+			if closure.UserID != "" {
+				// The room stays open, so its members see the user go
+				client.stopTyping(h, room)
+			} else {
+				client.clearTyping(room.ID)
+			}
+			h.setPresence(context.Background(), client, room.ID, false)
+		}()
+	}
+}
+
+// broadcastToRoom sends an event to the streams that joined the room and,
+// for conversations, to every stream of the participants. Slow streams are
+// dropped.
+func (h *Hub) broadcastToRoom(roomID string, event *chat.ChatEvent) {
+	h.mu.RLock()
+	room, exists := h.rooms[roomID]
+	if !exists {
+		h.mu.RUnlock()
+		return
+	}
+
+	recipients := room.Members
+	if room.Direct {
+		recipients = make(map[*Client]bool)
+		for client := range room.Members {
+			recipients[client] = true
+		}
+		for _, userID := range room.Participants {
+			for client := range h.clients[userID] {
+				recipients[client] = true
+			}
+		}
+	}
+
+	var dropped []*Client
+	for client := range recipients {
+		select {
+		case client.send <- event:
+		default:
+			client.close()
+			dropped = append(dropped, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(dropped) > 0 {
+		h.mu.Lock()
+		for _, client := range dropped {
+			delete(room.Members, client)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// sendToUser sends an event to every stream of a user.
+func (h *Hub) sendToUser(userID string, event *chat.ChatEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[userID] {
+		select {
+		case client.send <- event:
+		default:
+			client.close()
+		}
+	}
+}
+
+// room looks up a room by ID.
+func (h *Hub) room(roomID string) (*Room, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	room, exists := h.rooms[roomID]
+	if !exists {
+		return nil, status.Error(codes.NotFound, "room not found")
+	}
+	return room, nil
+}
+
+// roomInfo describes a room. h.mu must be held.
+func roomInfo(room *Room) *chat.Room {
+	return &chat.Room{
+		Id:           room.ID,
+		Name:         room.Name,
+		CreatedBy:    room.CreatedBy,
+		MaxMembers:   int32(room.MaxMembers),
+		Members:      int32(len(room.Members)),
+		HasPassword:  len(room.PasswordHash) > 0,
+		Ephemeral:    room.Ephemeral,
+		Archived:     room.Archived,
+		CreatedAt:    room.CreatedAt.UnixMilli(),
+		Direct:       room.Direct,
+		Participants: room.Participants,
+		Topic:        room.Topic,
+	}
+}
+
+func (h *Hub) createRoom(ctx context.Context, owner string, req *chat.CreateRoomRequest) (*Room, error) {
+	if req.Name == "" || req.MaxMembers < 2 {
+		return nil, status.Error(codes.InvalidArgument, "invalid room parameters")
+	}
+
+	room := &Room{
+		ID:         uuid.New().String(),
+		Name:       req.Name,
+		MaxMembers: int(req.MaxMembers),
+		Members:    make(map[*Client]bool),
+		CreatedBy:  owner,
+		CreatedAt:  time.Now(),
+		Ephemeral:  req.Ephemeral,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		room.PasswordHash = hash
+	}
+
+	if !room.Ephemeral {
+		err := h.roomStore.SaveRoom(ctx, &StoredRoom{
+			ID:           room.ID,
+			Name:         room.Name,
+			Owner:        room.CreatedBy,
+			MaxMembers:   room.MaxMembers,
+			PasswordHash: room.PasswordHash,
+			CreatedAt:    room.CreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.mu.Lock()
+	h.rooms[room.ID] = room
+	h.mu.Unlock()
+	return room, nil
+}
+
+// listRooms returns open rooms, or archived ones, oldest first.
+func (h *Hub) listRooms(ctx context.Context, userID string, archived bool) ([]*chat.Room, error) {
+	h.mu.RLock()
+	listed := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		if room.Archived == archived && !room.Direct {
+			listed = append(listed, room)
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool { return listed[i].CreatedAt.Before(listed[j].CreatedAt) })
+	rooms := make([]*chat.Room, 0, len(listed))
+	for _, room := range listed {
+		rooms = append(rooms, roomInfo(room))
+	}
+	h.mu.RUnlock()
+
+	// Unread counts only mean something to the rooms' members
+	for i, room := range listed {
+		member, err := h.isMember(ctx, room, userID)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			if rooms[i].Unread, err = h.messages.UnreadCount(ctx, room.ID, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rooms, nil
+}
+
+// ownedRoom returns the room if userID owns it.
+func (h *Hub) ownedRoom(roomID, userID string) (*Room, error) {
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Direct {
+		return nil, status.Error(codes.FailedPrecondition, "conversations cannot be deleted or archived")
+	}
+	if room.CreatedBy != userID {
+		return nil, status.Error(codes.PermissionDenied, "only the room owner can do that")
+	}
+	return room, nil
+}
+
+// deleteRoom removes a room and its history and disconnects its members.
+func (h *Hub) deleteRoom(ctx context.Context, room *Room) error {
+	if !room.Ephemeral {
+		attachments, err := h.attachments.RoomAttachments(ctx, room.ID)
+		if err != nil {
+			return err
+		}
+		if err := h.roomStore.DeleteRoom(ctx, room.ID); err != nil {
+			return err
+		}
+		h.deleteBlobs(ctx, attachments)
+		h.webhooks.removeRoom(room.ID)
+	}
+
+	h.closeRoom <- RoomClosure{RoomID: room.ID, Reason: "The room was deleted"}
+	h.mu.Lock()
+	delete(h.rooms, room.ID)
+	h.mu.Unlock()
+	return nil
+}
+
+// archiveRoom archives or restores a room. Archived rooms keep their
+// history but cannot be joined.
+func (h *Hub) archiveRoom(ctx context.Context, room *Room, archived bool) error {
+	if room.Ephemeral {
+		return status.Error(codes.FailedPrecondition, "ephemeral rooms cannot be archived")
+	}
+	if err := h.roomStore.SetRoomArchived(ctx, room.ID, archived); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	room.Archived = archived
+	h.mu.Unlock()
+	if archived {
+		h.closeRoom <- RoomClosure{RoomID: room.ID, Reason: "The room was archived"}
+	}
+	return nil
+}
+
+// notArchived fails if the room is archived, which archiveRoom may change
+// at any time.
+func (h *Hub) notArchived(room *Room) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if room.Archived {
+		return status.Error(codes.FailedPrecondition, "room is archived")
+	}
+	return nil
+}
+
+// isMember reports whether the user joined the room, either earlier or with
+// a stream that is still connected, or takes part in the conversation.
+func (h *Hub) isMember(ctx context.Context, room *Room, userID string) (bool, error) {
+	if room.Direct {
+		return room.hasParticipant(userID), nil
+	}
+
+	h.mu.RLock()
+	for client := range room.Members {
+		if client.userID == userID {
+			h.mu.RUnlock()
+			return true, nil
+		}
+	}
+	h.mu.RUnlock()
+
+	if room.Ephemeral {
+		return false, nil
+	}
+	return h.roomStore.IsMember(ctx, room.ID, userID)
+}
+
+// canRead reports whether the user may read the room's history: anyone for
+// open rooms, only the owner and members for password protected ones and
+// only participants for conversations.
+func (h *Hub) canRead(ctx context.Context, room *Room, userID string) error {
+	if !room.Direct && (len(room.PasswordHash) == 0 || room.CreatedBy == userID) {
+		return nil
+	}
+	member, err := h.isMember(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return status.Error(codes.PermissionDenied, "join the room to read its history")
+	}
+	return nil
+}
+
+// admit checks that the user may join the room and that it is not full,
+// then records the membership. The password is only needed by users who
+// are not members yet.
+func (h *Hub) admit(ctx context.Context, room *Room, userID, password string) error {
+	if err := h.notArchived(room); err != nil {
+		return err
+	}
+	if room.Direct {
+		if !room.hasParticipant(userID) {
+			return status.Error(codes.PermissionDenied, "not a participant")
+		}
+		return nil
+	}
+
+	if len(room.PasswordHash) > 0 && room.CreatedBy != userID {
+		member, err := h.isMember(ctx, room, userID)
+		if err != nil {
+			return err
+		}
+		if !member && bcrypt.CompareHashAndPassword(room.PasswordHash, []byte(password)) != nil {
+			return status.Error(codes.PermissionDenied, "invalid password")
+		}
+	}
+
+	// Refused users must not be stored as members
+	h.mu.RLock()
+	full := len(room.Members) >= room.MaxMembers
+	h.mu.RUnlock()
+	if full {
+		return status.Error(codes.ResourceExhausted, "room is full")
+	}
+
+	if room.Ephemeral {
+		return nil
+	}
+	return h.roomStore.AddMember(ctx, room.ID, userID)
+}
+
+// sendMessage persists a message with the uploaded attachments and
+// broadcasts it to the room. The draft names the author and carries the
+// content, thread and emote flag of the message.
+func (h *Hub) sendMessage(ctx context.Context, room *Room, draft StoredMessage, attachmentIDs []string) (*chat.Message, error) {
+	if draft.Content == "" && (len(attachmentIDs) == 0 || draft.Emote) {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	attachments, err := resolveAttachments(attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.notArchived(room); err != nil {
+		return nil, err
+	}
+	parentID := draft.ParentID
+	if parentID != 0 {
+		parent, err := h.threadParent(ctx, room, parentID)
+		if err != nil {
+			return nil, err
+		}
+		parentID = parent.ID
+	}
+
+	// Persist before broadcasting so the message has its ID
+	stored := &draft
+	stored.RoomID = room.ID
+	stored.ParentID = parentID
+	stored.Attachments = attachments
+	if err := h.messages.SaveMessage(ctx, stored); err != nil {
+		if errors.Is(err, errAttachmentUnavailable) {
+			return nil, status.Error(codes.FailedPrecondition, "attachment not found or already sent")
+		}
+		return nil, err
+	}
+	// Nobody has unread messages of their own
+	if !stored.Bot {
+		if _, err := h.messages.MarkRead(ctx, room.ID, stored.UserID, stored.ID); err != nil {
+			log.Printf("Error marking message read: %v", err)
+		}
+	}
+
+	msg := chatMessageFrom(*stored)
+	if parentID != 0 {
+		if err := h.broadcastReply(ctx, room, msg); err != nil {
+			return nil, err
+		}
+	} else {
+		h.broadcast <- BroadcastMessage{
+			RoomID: room.ID,
+			Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Chat{Chat: msg}},
+		}
+	}
+	h.webhooks.emit(room.ID, "message", msg)
+
+	// The message is sent either way; a failure only costs notifications
+	if err := h.recordMentions(ctx, room, stored); err != nil {
+		log.Printf("Error recording mentions: %v", err)
+	}
+	return msg, nil
+}
+
+// roomMembers returns the current members of a room along with their
+// presence state, as tracked by the presence service and as visible to
+// viewerID. An empty viewerID sees only what members share with everyone.
+// The members of a conversation are its participants.
+func (h *Hub) roomMembers(ctx context.Context, roomID, viewerID string) ([]*chat.Member, error) {
+	h.mu.RLock()
+	room, exists := h.rooms[roomID]
+	h.mu.RUnlock()
+	if exists && room.Direct {
+		return h.participantPresence(ctx, room, viewerID)
+	}
+
+	resp, err := h.presence.GetRoomPresence(ctx, &presence.GetRoomPresenceRequest{
+		RoomId:   roomID,
+		ViewerId: viewerID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*chat.Member, 0, len(resp.GetMembers()))
+	for _, m := range resp.GetMembers() {
+		members = append(members, &chat.Member{
+			UserId:            m.UserId,
+			Online:            m.Online,
+			LastActive:        m.LastActive,
+			ActiveConnections: m.ActiveConnections,
+		})
+	}
+	return members, nil
+}
+
+// setPresence reports the client's session in a room to the presence
+// service. Each room a client joins is a separate presence session, next to
+// the session of the stream itself, which has no room.
+func (h *Hub) setPresence(ctx context.Context, c *Client, roomID string, online bool) error {
+	sessionID := c.sessionID
+	if roomID != "" {
+		sessionID += "/" + roomID
+	}
+	_, err := h.presence.UpdatePresence(ctx, &presence.UpdatePresenceRequest{
+		UserId:    c.userID,
+		Online:    online,
+		SessionId: sessionID,
+		RoomId:    roomID,
+	})
+	if err != nil {
+		log.Printf("Error updating presence: %v", err)
+	}
+	return err
+}
+
+// messagePage loads up to limit messages of a room older than beforeID.
+func (h *Hub) messagePage(ctx context.Context, roomID string, beforeID int64, limit int) (*chat.MessagePage, error) {
+	stored, err := h.messages.MessagesBefore(ctx, roomID, beforeID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &chat.MessagePage{Messages: make([]*chat.Message, 0, limit)}
+	if len(stored) > limit {
+		page.HasMore = true
+		stored = stored[1:]
+	}
+	for _, msg := range stored {
+		page.Messages = append(page.Messages, chatMessageFrom(msg))
+	}
+	return page, nil
+}
+
+func chatMessageFrom(msg StoredMessage) *chat.Message {
+	return &chat.Message{
+		Id:          msg.ID,
+		RoomId:      msg.RoomID,
+		UserId:      msg.UserID,
+		Username:    msg.Username,
+		Content:     msg.Content,
+		Html:        renderMarkdown(msg.Content),
+		Time:        msg.CreatedAt.UnixMilli(),
+		EditedAt:    unixMilli(msg.EditedAt),
+		Deleted:     !msg.DeletedAt.IsZero(),
+		ParentId:    msg.ParentID,
+		ReplyCount:  msg.ReplyCount,
+		LastReplyAt: unixMilli(msg.LastReplyAt),
+		Reactions:   chatReactionsFrom(msg.Reactions),
+		Attachments: chatAttachmentsFrom(msg.Attachments),
+		Previews:    chatPreviewsFrom(msg.Previews),
+		Emote:       msg.Emote,
+		Bot:         msg.Bot,
+	}
+}
+
+// unixMilli is t in unix milliseconds, or 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// callerContext is the context of an RPC the gateway makes for userID.
+func callerContext(userID string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user-id", userID))
+}
+
+func TestFullRoomRefusesMembership(t *testing.T) {
+	hub := newTestHub(t)
+	room, err := hub.createRoom(context.Background(), "alice", &chat.CreateRoomRequest{Name: "small", MaxMembers: 2})
+	if err != nil {
+		t.Fatalf("createRoom: %v", err)
+	}
+	for _, userID := range []string{"alice", "bob"} {
+		c := &Client{userID: userID, username: userID, send: make(chan *chat.ChatEvent, 8), rooms: make(map[string]*Room)}
+		if err := c.join(callerContext(userID), hub, &chat.ChatRequest{RoomId: room.ID}); err != nil {
+			t.Fatalf("%s join: %v", userID, err)
+		}
+	}
+
+	// The run loop adds streams to the room after join returns
+	for {
+		hub.mu.RLock()
+		n := len(room.Members)
+		hub.mu.RUnlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s := &chatServer{hub: hub}
+	ctx := callerContext("mallory")
+	if _, err := s.JoinRoom(ctx, &chat.JoinRoomRequest{RoomId: room.ID}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("JoinRoom = %v, want ResourceExhausted", err)
+	}
+	mallory := &Client{userID: "mallory", username: "mallory", send: make(chan *chat.ChatEvent, 8), rooms: make(map[string]*Room)}
+	if err := mallory.join(ctx, hub, &chat.ChatRequest{RoomId: room.ID}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("join = %v, want ResourceExhausted", err)
+	}
+
+	if member, err := hub.roomStore.IsMember(context.Background(), room.ID, "mallory"); err != nil || member {
+		t.Fatalf("IsMember = %v, %v, want a refused user not stored", member, err)
+	}
+	_, err = s.SendMessage(ctx, &chat.SendMessageRequest{RoomId: room.ID, Content: "let me in"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("SendMessage = %v, want PermissionDenied", err)
+	}
+}
+
+func TestArchiveWhileSending(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			_, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "alice", Username: "alice", Content: "hi"}, nil)
+			if err != nil && status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("sendMessage: %v", err)
+				return
+			}
+		}
+	}()
+	if err := hub.archiveRoom(ctx, room, true); err != nil {
+		t.Fatalf("archiveRoom: %v", err)
+	}
+	<-done
+
+	_, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "alice", Username: "alice", Content: "hi"}, nil)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("sendMessage to an archived room = %v, want FailedPrecondition", err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Incoming webhooks let other systems, such as build servers, post into a
+// room with a token instead of joining it. Their messages are bot messages
+// from the user "webhook:<id>", shown with the webhook's name unless the
+// message overrides it.
+
+const (
+	// maxBotNameLength is in characters.
+	maxBotNameLength = 32
+	// incomingBurst is how many messages a webhook can post at once before
+	// its rate limit applies.
+	incomingBurst = 10
+)
+
+// webhookUserID is the author of an incoming webhook's messages.
+func webhookUserID(webhookID string) string { return "webhook:" + webhookID }
+
+// tokenBucket allows bursts of up to its capacity and refills at a steady
+// rate.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token for key. If there is none, it returns how long until
+// there is.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// rateLimitError tells the caller to wait before trying again, with the
+// delay as RetryInfo details.
+func rateLimitError(wait time.Duration) error {
+	wait = time.Duration(math.Ceil(wait.Seconds())) * time.Second
+	st := status.Newf(codes.ResourceExhausted, "rate limit exceeded, try again in %s", wait)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// validBotName reports whether name can be shown as the author of a bot
+// message.
+func validBotName(name string) bool {
+	return name != "" && validLine(name) && utf8.RuneCountInString(name) <= maxBotNameLength
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func (h *Hub) createIncomingWebhook(ctx context.Context, userID string, req *chat.CreateIncomingWebhookRequest) (*chat.IncomingWebhook, error) {
+	room, err := h.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if room.Direct || room.Ephemeral {
+		return nil, status.Error(codes.FailedPrecondition, "conversations and ephemeral rooms cannot have webhooks")
+	}
+	if !h.canManageWebhooks(room.ID, userID) {
+		return nil, status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+	}
+	name := strings.TrimSpace(req.Name)
+	if !validBotName(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "names are a single line of 1 to %d characters", maxBotNameLength)
+	}
+	existing, err := h.webhooks.store.IncomingWebhooks(ctx, room.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxWebhooksPerRoom {
+		return nil, status.Errorf(codes.ResourceExhausted, "at most %d incoming webhooks per room", maxWebhooksPerRoom)
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	w := &StoredIncomingWebhook{
+		ID:        uuid.New().String(),
+		RoomID:    room.ID,
+		Name:      name,
+		TokenHash: hashToken(hex.EncodeToString(token)),
+		CreatedBy: userID,
+	}
+	if err := h.webhooks.store.SaveIncomingWebhook(ctx, w); err != nil {
+		return nil, err
+	}
+
+	created := chatIncomingWebhookFrom(*w)
+	created.Token = hex.EncodeToString(token)
+	return created, nil
+}
+
+func (h *Hub) listIncomingWebhooks(ctx context.Context, userID, roomID string) ([]*chat.IncomingWebhook, error) {
+	if _, err := h.room(roomID); err != nil {
+		return nil, err
+	}
+	if !h.canManageWebhooks(roomID, userID) {
+		return nil, status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+	}
+
+	stored, err := h.webhooks.store.IncomingWebhooks(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]*chat.IncomingWebhook, 0, len(stored))
+	for _, w := range stored {
+		webhooks = append(webhooks, chatIncomingWebhookFrom(w))
+	}
+	return webhooks, nil
+}
+
+func (h *Hub) deleteIncomingWebhook(ctx context.Context, userID, id string) error {
+	w, err := h.webhooks.store.GetIncomingWebhook(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Error(codes.NotFound, "webhook not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !h.canManageWebhooks(w.RoomID, userID) {
+		return status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+	}
+	if err := h.webhooks.store.DeleteIncomingWebhook(ctx, w.ID); err != nil {
+		return err
+	}
+	h.incomingLimits.forget(w.ID)
+	return nil
+}
+
+// postWebhookMessage sends a message to the room of the incoming webhook
+// the token belongs to, storing its files as attachments first.
+func (h *Hub) postWebhookMessage(ctx context.Context, req *chat.PostWebhookMessageRequest) (*chat.Message, error) {
+	w, err := h.webhooks.store.GetIncomingWebhook(ctx, req.WebhookId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// Unknown webhooks and wrong tokens look the same to the caller
+	if w == nil || subtle.ConstantTimeCompare(w.TokenHash, hashToken(req.Token)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid webhook token")
+	}
+	if ok, wait := h.incomingLimits.allow(w.ID); !ok {
+		return nil, rateLimitError(wait)
+	}
+
+	name := w.Name
+	if req.Username != "" {
+		name = strings.TrimSpace(req.Username)
+		if !validBotName(name) {
+			return nil, status.Errorf(codes.InvalidArgument, "usernames are a single line of 1 to %d characters", maxBotNameLength)
+		}
+	}
+	if len(req.Attachments) > maxAttachmentsPerMessage {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d attachments per message", maxAttachmentsPerMessage)
+	}
+	room, err := h.room(w.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.notArchived(room); err != nil {
+		return nil, err
+	}
+
+	userID := webhookUserID(w.ID)
+	var stored []StoredAttachment
+	var attachmentIDs []string
+	for _, file := range req.Attachments {
+		a, err := h.storeAttachment(ctx, room, userID, file.Filename, file.Data)
+		if err != nil {
+			h.deleteUnsent(ctx, stored)
+			return nil, err
+		}
+		stored = append(stored, *a)
+		attachmentIDs = append(attachmentIDs, a.ID)
+	}
+
+	msg, err := h.sendMessage(ctx, room, StoredMessage{
+		UserID:   userID,
+		Username: name,
+		Content:  req.Text,
+		Bot:      true,
+	}, attachmentIDs)
+	if err != nil {
+		h.deleteUnsent(ctx, stored)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// deleteUnsent removes attachments stored for a message that was not sent.
+func (h *Hub) deleteUnsent(ctx context.Context, attachments []StoredAttachment) {
+	if len(attachments) == 0 {
+		return
+	}
+	ids := make([]string, len(attachments))
+	for i, a := range attachments {
+		ids[i] = a.ID
+	}
+	if err := h.attachments.DeleteAttachments(ctx, ids); err != nil {
+		// sweepUploads tries again later
+		log.Printf("Error deleting unsent attachments: %v", err)
+		return
+	}
+	h.deleteBlobs(ctx, attachments)
+}
+
+func chatIncomingWebhookFrom(w StoredIncomingWebhook) *chat.IncomingWebhook {
+	return &chat.IncomingWebhook{
+		Id:        w.ID,
+		RoomId:    w.RoomID,
+		Name:      w.Name,
+		CreatedBy: w.CreatedBy,
+		CreatedAt: w.CreatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createTestIncomingWebhook(t *testing.T, hub *Hub, room *Room) *chat.IncomingWebhook {
+	t.Helper()
+	w, err := hub.createIncomingWebhook(context.Background(), room.CreatedBy, &chat.CreateIncomingWebhookRequest{RoomId: room.ID, Name: "CI"})
+	if err != nil {
+		t.Fatalf("createIncomingWebhook: %v", err)
+	}
+	return w
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	for i := range 2 {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within the burst refused", i)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok {
+		t.Fatal("request past the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait = %v, want up to a second at one request a second", wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("another key shares the bucket")
+	}
+	l.forget("a")
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("a forgotten key is still limited")
+	}
+}
+
+func TestPostWebhookMessage(t *testing.T) {
+	hub := newWebhookHub(t)
+	room := createTestRoom(t, hub, "alice")
+	w := createTestIncomingWebhook(t, hub, room)
+	ctx := context.Background()
+
+	msg, err := hub.postWebhookMessage(ctx, &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: w.Token, Text: "build passed"})
+	if err != nil {
+		t.Fatalf("postWebhookMessage: %v", err)
+	}
+	if msg.UserId != webhookUserID(w.Id) || msg.Username != "CI" || !msg.Bot || msg.RoomId != room.ID {
+		t.Fatalf("message = %v, want a bot message from the webhook", msg)
+	}
+
+	msg, err = hub.postWebhookMessage(ctx, &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: w.Token, Text: "hi", Username: " Deploys "})
+	if err != nil {
+		t.Fatalf("postWebhookMessage: %v", err)
+	}
+	if msg.Username != "Deploys" {
+		t.Fatalf("username = %q, want the override", msg.Username)
+	}
+
+	tests := []struct {
+		name string
+		req  *chat.PostWebhookMessageRequest
+		code codes.Code
+	}{
+		{"wrong token", &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: "guess", Text: "hi"}, codes.Unauthenticated},
+		{"unknown webhook", &chat.PostWebhookMessageRequest{WebhookId: "nope", Token: w.Token, Text: "hi"}, codes.Unauthenticated},
+		{"multi-line username", &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: w.Token, Text: "hi", Username: "a\nb"}, codes.InvalidArgument},
+		{"empty message", &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: w.Token}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := hub.postWebhookMessage(ctx, tt.req); status.Code(err) != tt.code {
+				t.Fatalf("postWebhookMessage = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestPostWebhookMessageRateLimit(t *testing.T) {
+	hub := newWebhookHub(t)
+	room := createTestRoom(t, hub, "alice")
+	w := createTestIncomingWebhook(t, hub, room)
+	other := createTestIncomingWebhook(t, hub, room)
+	ctx := context.Background()
+	post := func(w *chat.IncomingWebhook) error {
+		_, err := hub.postWebhookMessage(ctx, &chat.PostWebhookMessageRequest{WebhookId: w.Id, Token: w.Token, Text: "spam"})
+		return err
+	}
+
+	for i := range incomingBurst {
+		if err := post(w); err != nil {
+			t.Fatalf("post %d within the burst: %v", i, err)
+		}
+	}
+	err := post(w)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("post past the burst = %v, want ResourceExhausted", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = info
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() <= 0 {
+		t.Fatalf("details = %v, want a retry delay", status.Convert(err).Details())
+	}
+
+	// Each webhook has its own limit
+	if err := post(other); err != nil {
+		t.Fatalf("post to another webhook: %v", err)
+	}
+	// Refused posts are not sent
+	msgs, err := hub.messages.MessagesBefore(ctx, room.ID, 0, 100)
+	if err != nil {
+		t.Fatalf("MessagesBefore: %v", err)
+	}
+	if len(msgs) != incomingBurst+1 {
+		t.Fatalf("%d messages stored, want %d", len(msgs), incomingBurst+1)
+	}
+}
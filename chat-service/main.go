@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	port, found := os.LookupEnv("CHAT_PORT")
+	if !found {
+		port = "50054"
+	}
+
+	// Bots connect on their own port, so exposing it to them does not expose
+	// ChatService, which trusts the gateway's user metadata
+	bot_port, found := os.LookupEnv("BOT_PORT")
+	if !found {
+		bot_port = "50055"
+	}
+
+	presence_host, found := os.LookupEnv("PRESENCE_HOST")
+	if !found {
+		presence_host = "localhost"
+	}
+
+	presence_port, found := os.LookupEnv("PRESENCE_PORT")
+	if !found {
+		presence_port = "50052"
+	}
+
+	chat_db, found := os.LookupEnv("CHAT_DB")
+	if !found {
+		chat_db = "chat.db"
+	}
+
+	chat_moderators := make(map[string]bool)
+	if moderators, found := os.LookupEnv("CHAT_MODERATORS"); found {
+		for _, userID := range strings.Split(moderators, ",") {
+			if userID = strings.TrimSpace(userID); userID != "" {
+				chat_moderators[userID] = true
+			}
+		}
+	}
+
+	attachment_max_bytes := int64(10 << 20)
+	if value, found := os.LookupEnv("ATTACHMENT_MAX_BYTES"); found {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid ATTACHMENT_MAX_BYTES: %q", value)
+		}
+		attachment_max_bytes = n
+	}
+
+	blob_store, found := os.LookupEnv("BLOB_STORE")
+	if !found {
+		blob_store = "disk"
+	}
+
+	blob_dir, found := os.LookupEnv("BLOB_DIR")
+	if !found {
+		blob_dir = "blobs"
+	}
+
+	s3_region, found := os.LookupEnv("S3_REGION")
+	if !found {
+		s3_region = "us-east-1"
+	}
+
+	webhook_max_attempts := 6
+	if value, found := os.LookupEnv("WEBHOOK_MAX_ATTEMPTS"); found {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid WEBHOOK_MAX_ATTEMPTS: %q", value)
+		}
+		webhook_max_attempts = n
+	}
+
+	webhook_retry_delay := 30 * time.Second
+	if value, found := os.LookupEnv("WEBHOOK_RETRY_DELAY"); found {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid WEBHOOK_RETRY_DELAY: %q", value)
+		}
+		webhook_retry_delay = d
+	}
+
+	incoming_webhook_rate := 30
+	if value, found := os.LookupEnv("INCOMING_WEBHOOK_RATE"); found {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid INCOMING_WEBHOOK_RATE: %q", value)
+		}
+		incoming_webhook_rate = n
+	}
+
+	// Shared with the gateway, the only client ChatService may trust
+	gateway_secret := os.Getenv("GATEWAY_SECRET")
+
+	// Lets webhooks reach private addresses, for testing against local servers
+	webhook_allow_private := os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+
+	presence_conn, err := grpc.Dial(strings.Join([]string{presence_host, presence_port}, ":"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer presence_conn.Close()
+
+	presence_client := presence.NewPresenceServiceClient(presence_conn)
+
+	chat_store, err := newSQLiteStore(chat_db)
+	if err != nil {
+		log.Fatalf("Failed to open chat store: %v", err)
+	}
+	defer chat_store.Close()
+
+	var blobs BlobStore
+	switch blob_store {
+	case "disk":
+		blobs, err = newDiskBlobStore(blob_dir)
+	case "s3":
+		var s3 *s3BlobStore
+		s3, err = newS3BlobStore(os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET"), s3_region, os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+		if err == nil {
+			err = s3.ensureBucket(context.Background())
+		}
+		blobs = s3
+	default:
+		log.Fatalf("Unknown BLOB_STORE %q, expected disk or s3", blob_store)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open blob store: %v", err)
+	}
+
+	hub := newHub(presence_client, chat_store, chat_store)
+	hub.moderators = chat_moderators
+	hub.attachments = chat_store
+	hub.blobs = blobs
+	hub.maxAttachmentSize = attachment_max_bytes
+	hub.webhooks = newWebhookDispatcher(chat_store, newWebhookClient(webhook_allow_private), webhook_max_attempts, webhook_retry_delay)
+	hub.incomingLimits = newRateLimiter(incoming_webhook_rate, incomingBurst)
+	hub.bots = chat_store
+	if err := hub.loadRooms(context.Background()); err != nil {
+		log.Fatalf("Failed to load rooms: %v", err)
+	}
+	if err := hub.webhooks.load(context.Background()); err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+	go hub.run()
+	go hub.webhooks.run(context.Background())
+	go hub.watchPresence(context.Background())
+	go hub.sweepUploads(context.Background(), time.Hour)
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	bot_lis, err := net.Listen("tcp", ":"+bot_port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	bot_server := grpc.NewServer()
+	chat.RegisterBotServiceServer(bot_server, &botServer{hub: hub})
+	go func() {
+		log.Printf("Bot service running on :%s", bot_port)
+		log.Fatal(bot_server.Serve(bot_lis))
+	}()
+
+	var chat_options []grpc.ServerOption
+	if gateway_secret != "" {
+		auth := gatewayAuth{secret: []byte(gateway_secret)}
+		chat_options = append(chat_options, grpc.UnaryInterceptor(auth.unary), grpc.StreamInterceptor(auth.stream))
+	} else {
+		log.Printf("GATEWAY_SECRET is not set; anyone who can reach :%s can act as any user", port)
+	}
+
+	s := grpc.NewServer(chat_options...)
+	chat.RegisterChatServiceServer(s, &chatServer{hub: hub})
+
+	log.Printf("Chat service running on :%s", port)
+	log.Fatal(s.Serve(lis))
+}
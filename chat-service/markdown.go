@@ -0,0 +1,225 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Messages are written in a small Markdown subset: fenced code blocks,
+// `inline code`, **bold**, [links](https://example.com), bare URLs and
+// lists. renderMarkdown turns it into HTML that is safe to insert into a
+// page: every character of the message is escaped, and the only markup is
+// the tags below, whose attributes are built by the renderer.
+
+var (
+	// listItemPattern matches "- item", "* item", "+ item" and "1. item".
+	listItemPattern = regexp.MustCompile(`^\s*(?:([-*+])|(\d{1,9})[.)])\s+(.*)$`)
+	// inlinePattern matches, in order of precedence, inline code, bold
+	// text, links and bare URLs.
+	inlinePattern = regexp.MustCompile("`([^`]+)`" +
+		`|\*\*(.+?)\*\*` +
+		`|\[([^\]]+)\]\(([^()\s]+)\)` +
+		`|(https?://[^\s<>"]+)`)
+	// codeLanguagePattern limits the language of a code block, which
+	// becomes a class name.
+	codeLanguagePattern = regexp.MustCompile(`^[\w+#-]{1,20}$`)
+)
+
+// renderMarkdown renders a message's content as HTML.
+func renderMarkdown(content string) string {
+	var b strings.Builder
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			i = writeCodeBlock(&b, lines, i)
+
+		case listItemPattern.MatchString(line):
+			i = writeList(&b, lines, i)
+
+		default:
+			// A paragraph runs until a blank line or another block
+			b.WriteString("<p>")
+			for first := true; i < len(lines); i++ {
+				line := lines[i]
+				trimmed := strings.TrimSpace(line)
+				if trimmed == "" || strings.HasPrefix(trimmed, "```") || listItemPattern.MatchString(line) {
+					break
+				}
+				if !first {
+					b.WriteString("<br>")
+				}
+				first = false
+				writeInline(&b, line, true)
+			}
+			b.WriteString("</p>")
+		}
+	}
+	return b.String()
+}
+
+// writeCodeBlock writes the fenced code block starting at lines[start] and
+// returns the index of the line after it. An unclosed block runs to the
+// end of the message.
+func writeCodeBlock(b *strings.Builder, lines []string, start int) int {
+	language := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[start]), "```"))
+	end := start + 1
+	for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+		end++
+	}
+
+	b.WriteString("<pre><code")
+	if codeLanguagePattern.MatchString(language) {
+		b.WriteString(` class="language-` + html.EscapeString(language) + `"`)
+	}
+	b.WriteString(">")
+	b.WriteString(html.EscapeString(strings.Join(lines[start+1:min(end, len(lines))], "\n")))
+	b.WriteString("</code></pre>")
+	return end + 1
+}
+
+// writeList writes the list starting at lines[start] and returns the index
+// of the line after it. A list ends at the first line that is not an item
+// of the same kind.
+func writeList(b *strings.Builder, lines []string, start int) int {
+	first := listItemPattern.FindStringSubmatch(lines[start])
+	ordered := first[1] == ""
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if n, _ := strconv.Atoi(first[2]); ordered && n != 1 {
+		b.WriteString(` start="` + strconv.Itoa(n) + `"`)
+	}
+	b.WriteString(">")
+
+	i := start
+	for ; i < len(lines); i++ {
+		item := listItemPattern.FindStringSubmatch(lines[i])
+		if item == nil || (item[1] == "") != ordered {
+			break
+		}
+		b.WriteString("<li>")
+		writeInline(b, item[3], true)
+		b.WriteString("</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return i
+}
+
+// writeInline writes a line of text with its inline markup. Link text
+// cannot contain further links.
+func writeInline(b *strings.Builder, text string, links bool) {
+	for text != "" {
+		m := inlinePattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			writeText(b, text)
+			return
+		}
+		writeText(b, text[:m[0]])
+		match := text[m[0]:m[1]]
+		rest := text[m[1]:]
+
+		switch {
+		case m[2] >= 0:
+			b.WriteString("<code>" + html.EscapeString(text[m[2]:m[3]]) + "</code>")
+
+		case m[4] >= 0:
+			b.WriteString("<strong>")
+			writeInline(b, text[m[4]:m[5]], links)
+			b.WriteString("</strong>")
+
+		case m[6] >= 0:
+			href, ok := safeURL(text[m[8]:m[9]])
+			if !links || !ok {
+				writeText(b, match)
+				break
+			}
+			writeLinkStart(b, href)
+			writeInline(b, text[m[6]:m[7]], false)
+			b.WriteString("</a>")
+
+		default:
+			// Punctuation after a URL usually belongs to the sentence
+			raw := trimURL(match)
+			rest = match[len(raw):] + rest
+			href, ok := safeURL(raw)
+			if !links || !ok {
+				writeText(b, raw)
+				break
+			}
+			writeLinkStart(b, href)
+			b.WriteString(html.EscapeString(raw))
+			b.WriteString("</a>")
+		}
+		text = rest
+	}
+}
+
+func writeLinkStart(b *strings.Builder, href string) {
+	b.WriteString(`<a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener noreferrer nofollow">`)
+}
+
+// writeText writes plain text, marking up @mentions.
+func writeText(b *strings.Builder, text string) {
+	last := 0
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		name := strings.TrimRight(text[m[2]:m[3]], ".-")
+		if name == "" {
+			continue
+		}
+		at := m[2] - 1
+		b.WriteString(html.EscapeString(text[last:at]))
+		b.WriteString(`<span class="mention" data-mention="` + html.EscapeString(name) + `">@` + html.EscapeString(name) + `</span>`)
+		last = m[2] + len(name)
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+}
+
+// safeURL returns the normalized URL if it is an absolute http, https or
+// mailto URL; anything else, such as javascript: URLs, is not linked.
+func safeURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+// trimURL drops trailing punctuation from a bare URL, keeping closing
+// parentheses that have an opening one in the URL.
+func trimURL(raw string) string {
+	for raw != "" {
+		last := raw[len(raw)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'*", last) >= 0:
+		case last == ')' && strings.Count(raw, "(") < strings.Count(raw, ")"):
+		default:
+			return raw
+		}
+		raw = raw[:len(raw)-1]
+	}
+	return raw
+}
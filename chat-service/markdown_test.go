@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+// link is the markup renderMarkdown uses for a link to href.
+func link(href, text string) string {
+	return `<a href="` + href + `" target="_blank" rel="noopener noreferrer nofollow">` + text + `</a>`
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		// Only absolute http, https and mailto URLs are linked
+		{"javascript", "[x](javascript:alert%281%29)", "<p>[x](javascript:alert%281%29)</p>"},
+		{"mixed case javascript", "[x](JaVaScRiPt:alert%281%29)", "<p>[x](JaVaScRiPt:alert%281%29)</p>"},
+		{"data", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>"},
+		{"vbscript", "[x](vbscript:x)", "<p>[x](vbscript:x)</p>"},
+		{"entity in scheme", "[x](jav&#x09;ascript:x)", "<p>[x](jav&amp;#x09;ascript:x)</p>"},
+		{"protocol relative", "[x](//evil.example)", "<p>[x](//evil.example)</p>"},
+		{"no host", "[x](https://)", "<p>[x](https://)</p>"},
+		{"bare javascript", "javascript:alert(1)", "<p>javascript:alert(1)</p>"},
+		{"mixed case https", "[x](HTTPS://example.com/a)", "<p>" + link("https://example.com/a", "x") + "</p>"},
+		{"mailto", "[x](mailto:a@example.com)", "<p>" + link("mailto:a@example.com", "x") + "</p>"},
+
+		// Quotes and angle brackets stay text
+		{"quotes in link", `[a"b<c>](https://example.com/?q="><script>)`,
+			"<p>" + link("https://example.com/?q=&#34;&gt;&lt;script&gt;", "a&#34;b&lt;c&gt;") + "</p>"},
+		{"quote ends bare URL", `https://example.com/"onmouseover=x`,
+			"<p>" + link("https://example.com/", "https://example.com/") + "&#34;onmouseover=x</p>"},
+		{"apostrophe in bare URL", "https://example.com/a'b<c",
+			"<p>" + link("https://example.com/a&#39;b", "https://example.com/a&#39;b") + "&lt;c</p>"},
+
+		// Code block languages are class names, or dropped
+		{"code language", "```go\n<b>\n```", `<pre><code class="language-go">&lt;b&gt;</code></pre>`},
+		{"code language breakout", "```\"><img src=x onerror=alert(1)>\nx\n```", "<pre><code>x</code></pre>"},
+		{"code language attribute", "```js onload=x\ny\n```", "<pre><code>y</code></pre>"},
+		{"unclosed code", "```\n<b>", "<pre><code>&lt;b&gt;</code></pre>"},
+
+		// Emphasis nests inside links and the other way round, links don't
+		{"bold in link", "[**bold** link](https://example.com)", "<p>" + link("https://example.com", "<strong>bold</strong> link") + "</p>"},
+		{"link in bold", "**[in](https://example.com)**", "<p><strong>" + link("https://example.com", "in") + "</strong></p>"},
+		{"link in link", "[[a](https://b.example)](https://c.example)",
+			"<p>" + link("https://b.example", "[a") + "](" + link("https://c.example", "https://c.example") + ")</p>"},
+
+		// Raw HTML is never passed through
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"img", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>"},
+		{"entities", "&amp; &lt;", "<p>&amp;amp; &amp;lt;</p>"},
+		{"html in list", "- a <i>\n- b", "<ul><li>a &lt;i&gt;</li><li>b</li></ul>"},
+		{"html in code", "`<b>`", "<p><code>&lt;b&gt;</code></p>"},
+
+		// Mentions
+		{"mention", "hi @bob.", `<p>hi <span class="mention" data-mention="bob">@bob</span>.</p>`},
+		{"mention trailing dash", "@al-", `<p><span class="mention" data-mention="al">@al</span>-</p>`},
+		{"email", "mail@host.example", "<p>mail@host.example</p>"},
+		{"at before html", "@<b>x", "<p>@&lt;b&gt;x</p>"},
+		{"mention in code", "`@bob`", "<p><code>@bob</code></p>"},
+		{"mention in link", "[@bob](https://example.com)",
+			"<p>" + link("https://example.com", `<span class="mention" data-mention="bob">@bob</span>`) + "</p>"},
+		{"mention in list", "1. @bob\n3. b", `<ol><li><span class="mention" data-mention="bob">@bob</span></li><li>b</li></ol>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.content); got != tt.want {
+				t.Errorf("renderMarkdown(%q)\n got %q\nwant %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invite makes a user a member of a room on behalf of one of its members
+// and sends them an invitation on all of their streams. Only moderators
+// may invite to a room with a password, since the invitation lets the user
+// in without it.
+func (h *Hub) invite(ctx context.Context, room *Room, inviterID, inviterName, userID string) error {
+	if userID == "" {
+		return status.Error(codes.InvalidArgument, "missing user")
+	}
+	if room.Direct {
+		return status.Error(codes.FailedPrecondition, "no one can be invited to a conversation")
+	}
+	if err := h.notArchived(room); err != nil {
+		return err
+	}
+	if userID == inviterID {
+		return status.Error(codes.InvalidArgument, "you cannot invite yourself")
+	}
+	member, err := h.isMember(ctx, room, inviterID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return status.Error(codes.PermissionDenied, "join the room first")
+	}
+
+	if len(room.PasswordHash) > 0 {
+		if !h.isModerator(room, inviterID) {
+			return status.Error(codes.PermissionDenied, "only moderators can invite to a room with a password")
+		}
+		// Ephemeral rooms keep no memberships to let the user in by
+		if room.Ephemeral {
+			return status.Error(codes.FailedPrecondition, "ephemeral rooms with a password do not take invitations")
+		}
+	}
+	if !room.Ephemeral {
+		if err := h.roomStore.AddMember(ctx, room.ID, userID); err != nil {
+			return err
+		}
+	}
+
+	h.mu.RLock()
+	info := roomInfo(room)
+	h.mu.RUnlock()
+	invitation := &chat.Invitation{Room: info, InviterId: inviterID, InviterName: inviterName}
+	h.broadcast <- BroadcastMessage{
+		UserID: userID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Invite{Invite: invitation}},
+	}
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  systemEvent(room.ID, fmt.Sprintf("%s invited %s", inviterName, userID)),
+	}
+	return nil
+}
+
+// kick takes a user's membership of a room on behalf of one of its
+// moderators and disconnects the user's streams from it. The owner cannot
+// be kicked. Users may join again, with the password if the room has one.
+func (h *Hub) kick(ctx context.Context, room *Room, moderatorID, moderatorName, userID, reason string) error {
+	if userID == "" {
+		return status.Error(codes.InvalidArgument, "missing user")
+	}
+	if !validLine(reason) {
+		return status.Errorf(codes.InvalidArgument, "reasons are a single line of at most %d characters", maxLineLength)
+	}
+	if room.Direct {
+		return status.Error(codes.FailedPrecondition, "no one can be kicked from a conversation")
+	}
+	if !h.isModerator(room, moderatorID) {
+		return status.Error(codes.PermissionDenied, "only moderators can kick")
+	}
+	if userID == moderatorID {
+		return status.Error(codes.InvalidArgument, "you cannot kick yourself")
+	}
+	if userID == room.CreatedBy {
+		return status.Error(codes.PermissionDenied, "the room owner cannot be kicked")
+	}
+	member, err := h.isMember(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return status.Error(codes.NotFound, "not a member of the room")
+	}
+
+	if !room.Ephemeral {
+		if err := h.roomStore.RemoveMember(ctx, room.ID, userID); err != nil {
+			return err
+		}
+	}
+
+	notice := fmt.Sprintf("You were removed from the room by %s", moderatorName)
+	announcement := fmt.Sprintf("%s was removed from the room by %s", userID, moderatorName)
+	if reason != "" {
+		notice += ": " + reason
+		announcement += ": " + reason
+	}
+	h.closeRoom <- RoomClosure{RoomID: room.ID, Reason: notice, UserID: userID}
+	h.broadcast <- BroadcastMessage{RoomID: room.ID, Event: systemEvent(room.ID, announcement)}
+	return nil
+}
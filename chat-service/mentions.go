@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Mention kinds: a user mentioned by name, or everyone in the room through
+// @room or @here.
+const (
+	mentionUser = "user"
+	mentionRoom = "room"
+	mentionHere = "here"
+)
+
+// mentionPattern matches @name at the start of a message or after a
+// character that cannot be part of an email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.-]+)`)
+
+// mentionedNames returns the lower-cased names mentioned in content.
+func mentionedNames(content string) map[string]bool {
+	names := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// Trailing punctuation ends a sentence, not a name
+		if name := strings.TrimRight(match[1], ".-"); name != "" {
+			names[strings.ToLower(name)] = true
+		}
+	}
+	return names
+}
+
+// mentionable returns who can be mentioned in a room, mapping user IDs to
+// usernames where known, and which of them are present for @here: the
+// room's current members, or for conversations the participants with an
+// open stream.
+func (h *Hub) mentionable(ctx context.Context, room *Room) (map[string]string, map[string]bool, error) {
+	members := make(map[string]string)
+	present := make(map[string]bool)
+
+	if room.Direct {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		for _, userID := range room.Participants {
+			members[userID] = ""
+			present[userID] = len(h.clients[userID]) > 0
+		}
+		return members, present, nil
+	}
+
+	if !room.Ephemeral {
+		stored, err := h.roomStore.Members(ctx, room.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, userID := range stored {
+			members[userID] = ""
+		}
+	}
+	members[room.CreatedBy] = ""
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range room.Members {
+		members[client.userID] = client.name()
+		present[client.userID] = true
+	}
+	return members, present, nil
+}
+
+// recordMentions stores the mentions in a new message and notifies the
+// mentioned users on all of their streams. Only the room's members can be
+// mentioned, and never by themselves.
+func (h *Hub) recordMentions(ctx context.Context, room *Room, msg *StoredMessage) error {
+	names := mentionedNames(msg.Content)
+	if len(names) == 0 {
+		return nil
+	}
+
+	members, present, err := h.mentionable(ctx, room)
+	if err != nil {
+		return err
+	}
+
+	var mentions []StoredMention
+	for userID, username := range members {
+		if userID == msg.UserID {
+			continue
+		}
+
+		var kind string
+		switch {
+		case names[strings.ToLower(userID)] || (username != "" && names[strings.ToLower(username)]):
+			kind = mentionUser
+		case names[mentionHere] && present[userID]:
+			kind = mentionHere
+		case names[mentionRoom]:
+			kind = mentionRoom
+		default:
+			continue
+		}
+		mentions = append(mentions, StoredMention{UserID: userID, Kind: kind, Message: *msg})
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].UserID < mentions[j].UserID })
+
+	if err := h.messages.SaveMentions(ctx, mentions); err != nil {
+		return err
+	}
+	for _, m := range mentions {
+		h.broadcast <- BroadcastMessage{
+			UserID: m.UserID,
+			Event: &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Mention{
+				Mention: chatMentionFrom(m, room),
+			}},
+		}
+	}
+	return nil
+}
+
+// listMentions returns a page of the user's mentions, newest first. Mentions
+// in rooms the user can no longer read, e.g. after being kicked, are left
+// out.
+func (h *Hub) listMentions(ctx context.Context, userID string, beforeID int64, limit int, unreadOnly bool) (*chat.ListMentionsResponse, error) {
+	stored, err := h.messages.Mentions(ctx, userID, beforeID, limit+1, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &chat.ListMentionsResponse{Mentions: make([]*chat.Mention, 0, limit)}
+	if len(stored) > limit {
+		resp.HasMore = true
+		stored = stored[:limit]
+	}
+	readable := make(map[string]bool)
+	for _, m := range stored {
+		room, err := h.room(m.Message.RoomID)
+		if err != nil {
+			continue
+		}
+		canRead, checked := readable[room.ID]
+		if !checked {
+			err := h.canRead(ctx, room, userID)
+			if err != nil && status.Code(err) != codes.PermissionDenied {
+				return nil, err
+			}
+			canRead = err == nil
+			readable[room.ID] = canRead
+		}
+		if canRead {
+			resp.Mentions = append(resp.Mentions, chatMentionFrom(m, room))
+		}
+	}
+	return resp, nil
+}
+
+func chatMentionFrom(m StoredMention, room *Room) *chat.Mention {
+	mention := &chat.Mention{
+		Id:      m.ID,
+		Kind:    m.Kind,
+		Message: chatMessageFrom(m.Message),
+		Read:    m.Read,
+	}
+	if !room.Direct {
+		mention.RoomName = room.Name
+	}
+	return mention
+}
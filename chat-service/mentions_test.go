@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go-grpc-basic/proto/chat"
+)
+
+func TestListMentionsAfterKick(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+	room, err := hub.createRoom(ctx, "alice", &chat.CreateRoomRequest{Name: "private", MaxMembers: 10, Password: "secret"})
+	if err != nil {
+		t.Fatalf("createRoom: %v", err)
+	}
+	if err := hub.admit(ctx, room, "bob", "secret"); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if _, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "alice", Username: "alice", Content: "@bob the code is 1234"}, nil); err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+
+	resp, err := hub.listMentions(ctx, "bob", 0, 10, false)
+	if err != nil || len(resp.Mentions) != 1 {
+		t.Fatalf("listMentions = %v, %v, want bob's mention", resp, err)
+	}
+
+	if err := hub.kick(ctx, room, "alice", "alice", "bob", ""); err != nil {
+		t.Fatalf("kick: %v", err)
+	}
+	resp, err = hub.listMentions(ctx, "bob", 0, 10, false)
+	if err != nil {
+		t.Fatalf("listMentions: %v", err)
+	}
+	if len(resp.Mentions) != 0 {
+		t.Fatalf("bob still sees %v after being kicked", resp.Mentions)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isModerator reports whether the user may delete other users' messages in
+// the room: its owner and the service-wide moderators. Conversations have
+// no moderators.
+func (h *Hub) isModerator(room *Room, userID string) bool {
+	if room.Direct {
+		return false
+	}
+	return room.CreatedBy == userID || h.moderators[userID]
+}
+
+// message loads a message that has not been deleted, along with its room.
+func (h *Hub) message(ctx context.Context, messageID int64) (*StoredMessage, *Room, error) {
+	msg, err := h.messages.GetMessage(ctx, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, status.Error(codes.NotFound, "message not found")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !msg.DeletedAt.IsZero() {
+		return nil, nil, status.Error(codes.NotFound, "message was deleted")
+	}
+
+	room, err := h.room(msg.RoomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, room, nil
+}
+
+// editMessage replaces the content of one of the user's messages and tells
+// the room.
+func (h *Hub) editMessage(ctx context.Context, userID string, messageID int64, content string) (*chat.Message, error) {
+	if content == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+
+	msg, room, err := h.message(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.UserID != userID {
+		return nil, status.Error(codes.PermissionDenied, "only the author can edit a message")
+	}
+	if err := h.notArchived(room); err != nil {
+		return nil, err
+	}
+	if msg.Content == content {
+		return chatMessageFrom(*msg), nil
+	}
+
+	edited, err := h.messages.EditMessage(ctx, messageID, content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "message was deleted")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated := chatMessageFrom(*edited)
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Edited{Edited: updated}},
+	}
+	return updated, nil
+}
+
+// deleteMessage removes a message on behalf of its author or a moderator
+// and tells the room.
+func (h *Hub) deleteMessage(ctx context.Context, userID string, messageID int64) error {
+	msg, room, err := h.message(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg.UserID != userID && !h.isModerator(room, userID) {
+		return status.Error(codes.PermissionDenied, "only the author or a moderator can delete a message")
+	}
+
+	if err := h.messages.DeleteMessage(ctx, messageID); err != nil {
+		return err
+	}
+	h.deleteBlobs(ctx, msg.Attachments)
+
+	tombstone := chatMessageFrom(StoredMessage{
+		ID:          msg.ID,
+		RoomID:      msg.RoomID,
+		UserID:      msg.UserID,
+		Username:    msg.Username,
+		CreatedAt:   msg.CreatedAt,
+		DeletedAt:   time.Now(),
+		ParentID:    msg.ParentID,
+		ReplyCount:  msg.ReplyCount,
+		LastReplyAt: msg.LastReplyAt,
+	})
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Deleted{Deleted: tombstone}},
+	}
+	return nil
+}
+
+// messageEdits returns the earlier versions of a message the user can read.
+func (h *Hub) messageEdits(ctx context.Context, userID string, messageID int64) ([]*chat.MessageEdit, error) {
+	_, room, err := h.message(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canRead(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	stored, err := h.messages.MessageEdits(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	edits := make([]*chat.MessageEdit, 0, len(stored))
+	for _, edit := range stored {
+		edits = append(edits, &chat.MessageEdit{Content: edit.Content, Time: edit.WrittenAt.UnixMilli()})
+	}
+	return edits, nil
+}
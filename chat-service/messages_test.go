@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sendTestMessage sends content to the room as userID.
+func sendTestMessage(t *testing.T, hub *Hub, room *Room, userID, content string, attachmentIDs ...string) int64 {
+	t.Helper()
+	msg, err := hub.sendMessage(context.Background(), room, StoredMessage{UserID: userID, Username: userID, Content: content}, attachmentIDs)
+	if err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	return msg.Id
+}
+
+func TestEditMessage(t *testing.T) {
+	hub := newTestHub(t)
+	hub.moderators["mod"] = true
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	id := sendTestMessage(t, hub, room, "bob", "helo")
+
+	for _, userID := range []string{"alice", "mod", "carol"} {
+		if _, err := hub.editMessage(ctx, userID, id, "hijacked"); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%s editing bob's message = %v, want PermissionDenied", userID, err)
+		}
+	}
+	if _, err := hub.editMessage(ctx, "bob", id, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("editing to nothing = %v, want InvalidArgument", err)
+	}
+
+	edited, err := hub.editMessage(ctx, "bob", id, "hello")
+	if err != nil {
+		t.Fatalf("editMessage: %v", err)
+	}
+	if edited.Content != "hello" || edited.EditedAt == 0 {
+		t.Fatalf("edited message = %v, want new content and edit time", edited)
+	}
+	edits, err := hub.messageEdits(ctx, "carol", id)
+	if err != nil {
+		t.Fatalf("messageEdits: %v", err)
+	}
+	if len(edits) != 1 || edits[0].Content != "helo" {
+		t.Fatalf("edits = %v, want the original content", edits)
+	}
+}
+
+func TestDeleteMessagePermissions(t *testing.T) {
+	hub := newTestHub(t)
+	hub.moderators["mod"] = true
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+
+	tests := []struct {
+		deleter string
+		code    codes.Code
+	}{
+		{"carol", codes.PermissionDenied},
+		{"bob", codes.OK},   // the author
+		{"alice", codes.OK}, // the room owner
+		{"mod", codes.OK},   // a service-wide moderator
+	}
+	for _, tt := range tests {
+		id := sendTestMessage(t, hub, room, "bob", "hi")
+		if err := hub.deleteMessage(ctx, tt.deleter, id); status.Code(err) != tt.code {
+			t.Errorf("%s deleting = %v, want %s", tt.deleter, err, tt.code)
+		}
+	}
+}
+
+func TestDeleteMessagePurges(t *testing.T) {
+	hub := newAttachmentHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	if err := hub.admit(ctx, room, "bob", ""); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+
+	a, err := hub.storeAttachment(ctx, room, "alice", "cat.png", encodePNG(t, 40, 30))
+	if err != nil {
+		t.Fatalf("storeAttachment: %v", err)
+	}
+	id := sendTestMessage(t, hub, room, "alice", "see https://example.com", a.ID)
+	edited, err := hub.editMessage(ctx, "alice", id, "look at https://example.com")
+	if err != nil {
+		t.Fatalf("editMessage: %v", err)
+	}
+	err = hub.messages.SetLinkPreviews(ctx, id, time.UnixMilli(edited.EditedAt), []StoredLinkPreview{{URL: "https://example.com", Title: "Example"}})
+	if err != nil {
+		t.Fatalf("SetLinkPreviews: %v", err)
+	}
+	if err := hub.react(ctx, "bob", id, "👍", true); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+
+	if err := hub.deleteMessage(ctx, "alice", id); err != nil {
+		t.Fatalf("deleteMessage: %v", err)
+	}
+
+	msg, err := hub.messages.GetMessage(ctx, id)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if msg.DeletedAt.IsZero() || msg.Content != "" || len(msg.Reactions) != 0 || len(msg.Previews) != 0 || len(msg.Attachments) != 0 {
+		t.Fatalf("deleted message = %+v, want a bare tombstone", msg)
+	}
+	if edits, err := hub.messages.MessageEdits(ctx, id); err != nil || len(edits) != 0 {
+		t.Fatalf("MessageEdits = %v, %v, want none", edits, err)
+	}
+	if _, err := hub.attachments.GetAttachment(ctx, a.ID); err == nil {
+		t.Fatal("the attachment is still stored")
+	}
+	for _, key := range []string{attachmentKey(a.ID), thumbnailKey(a.ID)} {
+		if r, err := hub.blobs.Get(ctx, key); err == nil {
+			r.Close()
+			t.Errorf("blob %s is still stored", key)
+		}
+	}
+
+	// Deleted messages are gone for every other action
+	if _, err := hub.editMessage(ctx, "alice", id, "back"); status.Code(err) != codes.NotFound {
+		t.Errorf("editing a deleted message = %v, want NotFound", err)
+	}
+	if err := hub.react(ctx, "bob", id, "👍", true); status.Code(err) != codes.NotFound {
+		t.Errorf("reacting to a deleted message = %v, want NotFound", err)
+	}
+	if err := hub.deleteMessage(ctx, "alice", id); status.Code(err) != codes.NotFound {
+		t.Errorf("deleting twice = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nickPattern limits names to what an @mention can match.
+var nickPattern = regexp.MustCompile(`^[\w.-]{1,32}$`)
+
+// reservedNamePrefixes start the user IDs of bots and incoming webhooks.
+var reservedNamePrefixes = []string{"bot:", "webhook:"}
+
+// name is the name the stream's user is shown with.
+func (c *Client) name() string {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	return c.username
+}
+
+// rename changes the name the stream's user is shown with from now on and
+// tells the stream and the rooms it joined. Messages already sent keep
+// their name.
+func (c *Client) rename(ctx context.Context, hub *Hub, name string) error {
+	if !nickPattern.MatchString(name) {
+		return status.Error(codes.InvalidArgument, "names are 1 to 32 letters, digits, '.', '-' or '_'")
+	}
+	taken, err := hub.nameTaken(ctx, c.userID, name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return status.Error(codes.AlreadyExists, "that name is taken")
+	}
+
+	c.nameMu.Lock()
+	old := c.username
+	c.username = name
+	c.nameMu.Unlock()
+	if old == name {
+		return nil
+	}
+
+	nick := &chat.Nick{UserId: c.userID, OldName: old, NewName: name}
+	c.reply(&chat.ChatEvent{Event: &chat.ChatEvent_Nick{Nick: nick}})
+	for _, room := range c.joinedRooms() {
+		hub.broadcast <- BroadcastMessage{
+			RoomID: room.ID,
+			Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Nick{Nick: nick}},
+		}
+	}
+	return nil
+}
+
+// nameTaken reports whether name belongs to a user other than userID: a
+// moderator, a connected user or one the store knows. Names that could
+// pass for a bot or a webhook are taken too. Names are compared the way
+// mentions match them.
+func (h *Hub) nameTaken(ctx context.Context, userID, name string) (bool, error) {
+	if strings.EqualFold(name, userID) {
+		return false, nil
+	}
+	for _, prefix := range reservedNamePrefixes {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return true, nil
+		}
+	}
+	for id := range h.moderators {
+		if strings.EqualFold(id, name) {
+			return true, nil
+		}
+	}
+	if h.nameInUse(userID, name) {
+		return true, nil
+	}
+	return h.roomStore.KnownUser(ctx, name)
+}
+
+// nameInUse reports whether a connected user other than userID goes by
+// name, as their ID or on one of their streams.
+func (h *Hub) nameInUse(userID, name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for id, streams := range h.clients {
+		if id == userID {
+			continue
+		}
+		if strings.EqualFold(id, name) {
+			return true
+		}
+		for client := range streams {
+			if strings.EqualFold(client.name(), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNameTaken(t *testing.T) {
+	hub := newTestHub(t)
+	hub.moderators["admin"] = true
+	ctx := context.Background()
+
+	room := createTestRoom(t, hub, "dave")
+	if _, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "carol", Username: "carol", Content: "hi"}, nil); err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	if err := hub.roomStore.AddMember(ctx, room.ID, "erin"); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	hub.addClient(&Client{userID: "frank", username: "zed"})
+
+	tests := map[string]bool{
+		"alice":      false, // the caller's own ID
+		"ALICE":      false,
+		"newcomer":   false,
+		"admin":      true, // an offline moderator
+		"Admin":      true,
+		"carol":      true, // sent a message
+		"dave":       true, // owns a room
+		"erin":       true, // joined a room
+		"frank":      true, // connected
+		"zed":        true, // frank's nick
+		"bot:123":    true,
+		"Webhook:42": true,
+	}
+	for name, want := range tests {
+		taken, err := hub.nameTaken(ctx, "alice", name)
+		if err != nil {
+			t.Fatalf("nameTaken(%q): %v", name, err)
+		}
+		if taken != want {
+			t.Errorf("nameTaken(%q) = %v, want %v", name, taken, want)
+		}
+	}
+}
+
+func TestRenameRejectsOtherUsersNames(t *testing.T) {
+	hub := newTestHub(t)
+	hub.moderators["admin"] = true
+	c := &Client{userID: "alice", username: "alice", send: make(chan *chat.ChatEvent, 8), rooms: make(map[string]*Room)}
+	ctx := context.Background()
+
+	for name, code := range map[string]codes.Code{
+		"admin":   codes.AlreadyExists,
+		"bot:123": codes.InvalidArgument,
+		"a b":     codes.InvalidArgument,
+	} {
+		if err := c.rename(ctx, hub, name); status.Code(err) != code {
+			t.Errorf("rename(%q) = %v, want %s", name, err, code)
+		}
+	}
+	if err := c.rename(ctx, hub, "al"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if c.name() != "al" {
+		t.Fatalf("name = %q, want al", c.name())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+)
+
+const (
+	presenceBackoffMin = 500 * time.Millisecond
+	presenceBackoffMax = 30 * time.Second
+)
+
+// watchPresence holds a StreamPresence subscription for the lifetime of ctx,
+// reconnecting with exponential backoff, and hands every update to the hub.
+// The subscription is anonymous, so clients only see presence that users
+// share with everyone.
+func (h *Hub) watchPresence(ctx context.Context) {
+	backoff := presenceBackoffMin
+	for {
+		stream, err := h.presence.StreamPresence(ctx, &presence.StreamPresenceRequest{})
+		if err == nil {
+			log.Println("Subscribed to presence updates")
+			for {
+				var update *presence.PresenceUpdate
+				update, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				backoff = presenceBackoffMin
+				h.presenceUpdates <- update
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err != io.EOF {
+			log.Printf("Presence stream error: %v", err)
+		}
+		log.Printf("Reconnecting to presence stream in %v", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, presenceBackoffMax)
+	}
+}
+
+// forwardPresence sends a presence update to every room the user is in or
+// just left and every conversation they take part in. It is the only way
+// presence changes reach clients. It must only be called from the hub's
+// run loop.
+func (h *Hub) forwardPresence(update *presence.PresenceUpdate) {
+	left := h.presenceRooms[update.UserId]
+	if len(update.RoomIds) > 0 {
+		h.presenceRooms[update.UserId] = update.RoomIds
+	} else {
+		delete(h.presenceRooms, update.UserId)
+	}
+
+	inRoom := make(map[string]bool)
+	for _, id := range left {
+		inRoom[id] = false
+	}
+	for _, id := range update.RoomIds {
+		inRoom[id] = true
+	}
+	h.mu.RLock()
+	for id, room := range h.rooms {
+		// Participants never leave a conversation
+		if room.Direct && room.hasParticipant(update.UserId) {
+			inRoom[id] = true
+		}
+	}
+	for id := range inRoom {
+		if _, ok := h.rooms[id]; !ok {
+			delete(inRoom, id)
+		}
+	}
+	h.mu.RUnlock()
+
+	for roomID, in := range inRoom {
+		h.broadcastToRoom(roomID, &chat.ChatEvent{
+			RoomId: roomID,
+			Event: &chat.ChatEvent_Presence{Presence: &chat.PresenceChange{
+				UserId:     update.UserId,
+				Online:     update.Online,
+				LastActive: update.LastActive,
+				InRoom:     in,
+			}},
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+)
+
+// presenceEvents returns the presence changes sent to c until none arrive
+// for a while.
+func presenceEvents(c *Client) []*chat.PresenceChange {
+	var changes []*chat.PresenceChange
+	for {
+		select {
+		case event := <-c.send:
+			if change := event.GetPresence(); change != nil {
+				changes = append(changes, change)
+			}
+		case <-time.After(100 * time.Millisecond):
+			return changes
+		}
+	}
+}
+
+func TestForwardPresenceFollowsRooms(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	alice := &Client{userID: "alice", username: "alice", send: make(chan *chat.ChatEvent, 8), rooms: make(map[string]*Room)}
+	if err := alice.addRoom(room); err != nil {
+		t.Fatalf("addRoom: %v", err)
+	}
+	hub.register <- Membership{Client: alice, Room: room}
+	presenceEvents(alice)
+
+	// bob joins, then leaves; each change reaches the room once
+	hub.presenceUpdates <- &presence.PresenceUpdate{UserId: "bob", Online: true, RoomIds: []string{room.ID}}
+	changes := presenceEvents(alice)
+	if len(changes) != 1 || changes[0].UserId != "bob" || !changes[0].Online || !changes[0].InRoom {
+		t.Fatalf("after bob joined got %v, want one change with bob in the room", changes)
+	}
+	hub.presenceUpdates <- &presence.PresenceUpdate{UserId: "bob", Online: true}
+	changes = presenceEvents(alice)
+	if len(changes) != 1 || changes[0].UserId != "bob" || changes[0].InRoom {
+		t.Fatalf("after bob left got %v, want one change with bob out of the room", changes)
+	}
+
+	// Rooms bob is not and was not in hear nothing
+	hub.presenceUpdates <- &presence.PresenceUpdate{UserId: "bob", Online: false}
+	if changes := presenceEvents(alice); len(changes) != 0 {
+		t.Fatalf("after bob went offline got %v, want nothing", changes)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxLinkPreviews caps the previews of one message.
+	maxLinkPreviews = 5
+	// maxPreviewURL and maxPreviewText bound the fields of a preview, in
+	// bytes.
+	maxPreviewURL  = 2048
+	maxPreviewText = 1024
+)
+
+// setLinkPreviews replaces the link previews of one of the user's messages
+// and sends them to the room.
+func (h *Hub) setLinkPreviews(ctx context.Context, userID string, req *chat.SetLinkPreviewsRequest) error {
+	msg, room, err := h.message(ctx, req.MessageId)
+	if err != nil {
+		return err
+	}
+	if msg.UserID != userID {
+		return status.Error(codes.PermissionDenied, "only the author can set link previews")
+	}
+	if len(req.Previews) > maxLinkPreviews {
+		return status.Errorf(codes.InvalidArgument, "at most %d link previews per message", maxLinkPreviews)
+	}
+
+	previews := make([]StoredLinkPreview, len(req.Previews))
+	for i, p := range req.Previews {
+		if !validPreviewURL(p.Url, true) || !validPreviewURL(p.ImageUrl, false) ||
+			!validPreviewText(p.Title) || !validPreviewText(p.Description) || !validPreviewText(p.SiteName) {
+			return status.Error(codes.InvalidArgument, "invalid link preview")
+		}
+		previews[i] = StoredLinkPreview{
+			URL:         p.Url,
+			Title:       p.Title,
+			Description: p.Description,
+			ImageURL:    p.ImageUrl,
+			SiteName:    p.SiteName,
+		}
+	}
+
+	var editedAt time.Time
+	if req.EditedAt != 0 {
+		editedAt = time.UnixMilli(req.EditedAt)
+	}
+	err = h.messages.SetLinkPreviews(ctx, msg.ID, editedAt, previews)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Error(codes.FailedPrecondition, "the message has changed")
+	}
+	if err != nil {
+		return err
+	}
+
+	unfurl := &chat.Unfurl{MessageId: msg.ID, Previews: chatPreviewsFrom(previews)}
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Unfurl{Unfurl: unfurl}},
+	}
+	return nil
+}
+
+// validPreviewURL reports whether s is an absolute http or https URL, or
+// empty if the URL is optional.
+func validPreviewURL(s string, required bool) bool {
+	if s == "" {
+		return !required
+	}
+	if len(s) > maxPreviewURL {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validPreviewText(s string) bool {
+	return len(s) <= maxPreviewText && utf8.ValidString(s)
+}
+
+func chatPreviewsFrom(previews []StoredLinkPreview) []*chat.LinkPreview {
+	if len(previews) == 0 {
+		return nil
+	}
+	out := make([]*chat.LinkPreview, len(previews))
+	for i, p := range previews {
+		out[i] = &chat.LinkPreview{
+			Url:         p.URL,
+			Title:       p.Title,
+			Description: p.Description,
+			ImageUrl:    p.ImageURL,
+			SiteName:    p.SiteName,
+		}
+	}
+	return out
+}
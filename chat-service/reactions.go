@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxReactionEmoji caps how many different emoji a message can collect.
+const maxReactionEmoji = 20
+
+// maxEmojiLength bounds an emoji in bytes, leaving room for multi-codepoint
+// sequences and :shortcodes:.
+const maxEmojiLength = 64
+
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	return !strings.ContainsFunc(emoji, unicode.IsSpace)
+}
+
+// react adds or takes back the user's reaction to a message in one of their
+// rooms and tells the room.
+func (h *Hub) react(ctx context.Context, userID string, messageID int64, emoji string, add bool) error {
+	if !validEmoji(emoji) {
+		return status.Error(codes.InvalidArgument, "invalid emoji")
+	}
+
+	msg, room, err := h.message(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	member, err := h.isMember(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return status.Error(codes.PermissionDenied, "join the room first")
+	}
+	if err := h.notArchived(room); err != nil {
+		return err
+	}
+
+	var count int64
+	var changed bool
+	if add {
+		used := slices.ContainsFunc(msg.Reactions, func(r StoredReaction) bool { return r.Emoji == emoji })
+		if !used && len(msg.Reactions) >= maxReactionEmoji {
+			return status.Error(codes.ResourceExhausted, "too many different reactions")
+		}
+		count, changed, err = h.messages.AddReaction(ctx, messageID, userID, emoji)
+	} else {
+		count, changed, err = h.messages.RemoveReaction(ctx, messageID, userID, emoji)
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	change := &chat.ReactionChange{
+		MessageId: messageID,
+		UserId:    userID,
+		Emoji:     emoji,
+		Added:     add,
+		Count:     count,
+	}
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Reaction{Reaction: change}},
+	}
+	h.webhooks.emit(room.ID, "reaction", change)
+	return nil
+}
+
+func chatReactionsFrom(reactions []StoredReaction) []*chat.Reaction {
+	converted := make([]*chat.Reaction, 0, len(reactions))
+	for _, r := range reactions {
+		converted = append(converted, &chat.Reaction{
+			Emoji:   r.Emoji,
+			Count:   int64(len(r.UserIDs)),
+			UserIds: r.UserIDs,
+		})
+	}
+	return converted
+}
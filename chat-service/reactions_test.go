@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reactionUsers returns who reacted to the message with emoji.
+func reactionUsers(t *testing.T, hub *Hub, messageID int64, emoji string) []string {
+	t.Helper()
+	msg, err := hub.messages.GetMessage(context.Background(), messageID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	for _, r := range msg.Reactions {
+		if r.Emoji == emoji {
+			return r.UserIDs
+		}
+	}
+	return nil
+}
+
+func TestReactAddAndRemove(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	for _, userID := range []string{"alice", "bob"} {
+		if err := hub.admit(ctx, room, userID, ""); err != nil {
+			t.Fatalf("admit: %v", err)
+		}
+	}
+	id := sendTestMessage(t, hub, room, "alice", "lunch?")
+
+	for _, userID := range []string{"alice", "bob", "bob"} {
+		if err := hub.react(ctx, userID, id, "👍", true); err != nil {
+			t.Fatalf("react: %v", err)
+		}
+	}
+	if users := reactionUsers(t, hub, id, "👍"); !slices.Equal(users, []string{"alice", "bob"}) {
+		t.Fatalf("reacted = %v, want alice and bob once each", users)
+	}
+
+	if err := hub.react(ctx, "bob", id, "👍", false); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	if err := hub.react(ctx, "bob", id, "🎉", false); err != nil {
+		t.Fatalf("taking back a missing reaction: %v", err)
+	}
+	if users := reactionUsers(t, hub, id, "👍"); !slices.Equal(users, []string{"alice"}) {
+		t.Fatalf("reacted = %v, want alice", users)
+	}
+
+	if err := hub.react(ctx, "alice", id, "👍", false); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	msg, err := hub.messages.GetMessage(ctx, id)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(msg.Reactions) != 0 {
+		t.Fatalf("reactions = %v, want none", msg.Reactions)
+	}
+}
+
+func TestReactRefusals(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	if err := hub.admit(ctx, room, "alice", ""); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	id := sendTestMessage(t, hub, room, "alice", "lunch?")
+
+	tests := []struct {
+		name      string
+		userID    string
+		messageID int64
+		emoji     string
+		code      codes.Code
+	}{
+		{"non-member", "mallory", id, "👍", codes.PermissionDenied},
+		{"empty emoji", "alice", id, "", codes.InvalidArgument},
+		{"emoji with spaces", "alice", id, "thumbs up", codes.InvalidArgument},
+		{"overlong emoji", "alice", id, strings.Repeat("👍", maxEmojiLength), codes.InvalidArgument},
+		{"invalid UTF-8", "alice", id, "\xff", codes.InvalidArgument},
+		{"missing message", "alice", id + 100, "👍", codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := hub.react(ctx, tt.userID, tt.messageID, tt.emoji, true); status.Code(err) != tt.code {
+				t.Fatalf("react = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestReactEmojiLimit(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	if err := hub.admit(ctx, room, "alice", ""); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	id := sendTestMessage(t, hub, room, "alice", "vote")
+
+	for i := range maxReactionEmoji {
+		if err := hub.react(ctx, "alice", id, fmt.Sprintf(":option%d:", i), true); err != nil {
+			t.Fatalf("react %d: %v", i, err)
+		}
+	}
+	if err := hub.react(ctx, "alice", id, ":onemore:", true); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("react past the limit = %v, want ResourceExhausted", err)
+	}
+	// Emoji already on the message can still collect reactions
+	if err := hub.admit(ctx, room, "bob", ""); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if err := hub.react(ctx, "bob", id, ":option0:", true); err != nil {
+		t.Fatalf("react with a used emoji: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// markRead moves the user's read marker in a room they belong to, tells
+// the room if it moved, and returns how many messages are still unread.
+func (h *Hub) markRead(ctx context.Context, room *Room, userID string, messageID int64) (int64, error) {
+	member, err := h.isMember(ctx, room, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !member {
+		return 0, status.Error(codes.PermissionDenied, "join the room first")
+	}
+
+	msg, err := h.messages.GetMessage(ctx, messageID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && msg.RoomID != room.ID) {
+		return 0, status.Error(codes.InvalidArgument, "invalid message_id")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	moved, err := h.messages.MarkRead(ctx, room.ID, userID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	if moved {
+		h.broadcast <- BroadcastMessage{
+			RoomID: room.ID,
+			Event: &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Read{Read: &chat.ReadReceipt{
+				UserId:    userID,
+				MessageId: messageID,
+			}}},
+		}
+	}
+	return h.messages.UnreadCount(ctx, room.ID, userID)
+}
+
+// readReceipts returns the read markers of a room, ordered by user ID.
+func (h *Hub) readReceipts(ctx context.Context, roomID string) ([]*chat.ReadReceipt, error) {
+	markers, err := h.messages.ReadMarkers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make([]*chat.ReadReceipt, 0, len(markers))
+	for userID, messageID := range markers {
+		receipts = append(receipts, &chat.ReadReceipt{UserId: userID, MessageId: messageID})
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].UserId < receipts[j].UserId })
+	return receipts, nil
+}
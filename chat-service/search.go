@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Search results are paged by offset; deep pages get expensive to rank.
+const (
+	searchPageSize  = 20
+	maxSearchOffset = 1000
+	// snippetWords is how much of a message stands in for a snippet when
+	// only its room name matched.
+	snippetWords = 16
+)
+
+// search finds messages matching req in the rooms the user can read.
+func (h *Hub) search(ctx context.Context, userID string, req *chat.SearchRequest) (*chat.SearchResponse, error) {
+	text := strings.TrimSpace(req.Query)
+	if text == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty query")
+	}
+	if req.Offset < 0 || req.Offset > maxSearchOffset || req.Limit < 0 || req.After < 0 || req.Before < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid search parameters")
+	}
+
+	var rooms []*Room
+	if req.RoomId != "" {
+		room, err := h.room(req.RoomId)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.canRead(ctx, room, userID); err != nil {
+			return nil, err
+		}
+		rooms = []*Room{room}
+	} else {
+		var err error
+		if rooms, err = h.readableRooms(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	query := SearchQuery{
+		Text:   text,
+		UserID: req.UserId,
+		Offset: int(req.Offset),
+		Limit:  searchPageSize,
+	}
+	if req.Limit > 0 {
+		query.Limit = min(int(req.Limit), maxHistoryPage)
+	}
+	if req.After > 0 {
+		query.After = time.UnixMilli(req.After)
+	}
+	if req.Before > 0 {
+		query.Before = time.UnixMilli(req.Before)
+	}
+	byID := make(map[string]*Room, len(rooms))
+	for _, room := range rooms {
+		query.RoomIDs = append(query.RoomIDs, room.ID)
+		byID[room.ID] = room
+	}
+
+	limit := query.Limit
+	query.Limit++
+	hits, err := h.messages.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &chat.SearchResponse{Results: make([]*chat.SearchResult, 0, limit)}
+	if len(hits) > limit {
+		resp.HasMore = true
+		hits = hits[:limit]
+	}
+	for _, hit := range hits {
+		result := &chat.SearchResult{
+			Message: chatMessageFrom(hit.Message),
+			Snippet: snippetHTML(hit.Snippet),
+		}
+		if hit.Snippet == "" {
+			result.Snippet = html.EscapeString(leadingWords(hit.Message.Content, snippetWords))
+		}
+		if room := byID[hit.Message.RoomID]; !room.Direct {
+			result.RoomName = room.Name
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+// readableRooms returns every room whose history the user may read.
+func (h *Hub) readableRooms(ctx context.Context, userID string) ([]*Room, error) {
+	h.mu.RLock()
+	all := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		all = append(all, room)
+	}
+	h.mu.RUnlock()
+
+	var readable []*Room
+	for _, room := range all {
+		err := h.canRead(ctx, room, userID)
+		if status.Code(err) == codes.PermissionDenied {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		readable = append(readable, room)
+	}
+	return readable, nil
+}
+
+// snippetHTML escapes a search snippet and marks its matches. Only whole
+// snippetStart/snippetEnd pairs become <mark>s; any other marker bytes are
+// dropped.
+func snippetHTML(snippet string) string {
+	var b strings.Builder
+	marked := false
+	for {
+		i := strings.IndexAny(snippet, snippetStart+snippetEnd)
+		if i < 0 {
+			break
+		}
+		b.WriteString(html.EscapeString(snippet[:i]))
+		switch {
+		case snippet[i:i+1] == snippetStart && !marked && strings.Contains(snippet[i+1:], snippetEnd):
+			b.WriteString("<mark>")
+			marked = true
+		case snippet[i:i+1] == snippetEnd && marked:
+			b.WriteString("</mark>")
+			marked = false
+		}
+		snippet = snippet[i+1:]
+	}
+	b.WriteString(html.EscapeString(snippet))
+	return b.String()
+}
+
+// leadingWords returns the first n words of a message, without snippet
+// markers.
+func leadingWords(content string, n int) string {
+	content = strings.NewReplacer(snippetStart, "", snippetEnd, "").Replace(content)
+	words := strings.Fields(content)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:n], " ") + "…"
+}
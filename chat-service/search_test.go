@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-grpc-basic/proto/chat"
+)
+
+func TestSnippetHTML(t *testing.T) {
+	tests := map[string]string{
+		"say \x02hello\x03 there": "say <mark>hello</mark> there",
+		"<b>\x02x\x03</b>":        "&lt;b&gt;<mark>x</mark>&lt;/b&gt;",
+		"stray \x03end":           "stray end",
+		"open \x02start":          "open start",
+		"\x02a\x02b\x03\x03":      "<mark>ab</mark>",
+		"\x02one\x03 \x02two\x03": "<mark>one</mark> <mark>two</mark>",
+		"plain & simple":          "plain &amp; simple",
+	}
+	for snippet, want := range tests {
+		if got := snippetHTML(snippet); got != want {
+			t.Errorf("snippetHTML(%q) = %q, want %q", snippet, got, want)
+		}
+	}
+}
+
+func searchTexts(t *testing.T, hub *Hub, userID, query string) []string {
+	t.Helper()
+	resp, err := hub.search(context.Background(), userID, &chat.SearchRequest{Query: query})
+	if err != nil {
+		t.Fatalf("search(%q): %v", query, err)
+	}
+	var snippets []string
+	for _, r := range resp.Results {
+		snippets = append(snippets, r.Snippet)
+	}
+	return snippets
+}
+
+func TestSearchIgnoresMarkersInMessages(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	msg := StoredMessage{UserID: "alice", Username: "alice", Content: "\x03</mark><script>x</script>\x02 kettle"}
+	if _, err := hub.sendMessage(context.Background(), room, msg, nil); err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+
+	snippets := searchTexts(t, hub, "alice", "kettle")
+	if len(snippets) != 1 {
+		t.Fatalf("found %q, want one message", snippets)
+	}
+	want := "&lt;/mark&gt;&lt;script&gt;x&lt;/script&gt; <mark>kettle</mark>"
+	if snippets[0] != want {
+		t.Fatalf("snippet = %q, want %q", snippets[0], want)
+	}
+}
+
+func TestSearchMatchesRoomNames(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	for _, content := range []string{"first post", "kettle is on"} {
+		if _, err := hub.sendMessage(ctx, room, StoredMessage{UserID: "alice", Username: "alice", Content: content}, nil); err != nil {
+			t.Fatalf("sendMessage: %v", err)
+		}
+	}
+
+	// Words can match the room name and the content between them
+	if got := searchTexts(t, hub, "alice", "General kettle"); len(got) != 1 || !strings.Contains(got[0], "<mark>kettle</mark>") {
+		t.Fatalf("general kettle found %q", got)
+	}
+	// Messages found by room name alone are shown from their start
+	got := searchTexts(t, hub, "alice", "general")
+	if len(got) != 2 || got[0] != "kettle is on" || got[1] != "first post" {
+		t.Fatalf("general found %q", got)
+	}
+	if got := searchTexts(t, hub, "alice", "random"); len(got) != 0 {
+		t.Fatalf("random found %q", got)
+	}
+}
@@ -0,0 +1,914 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"log"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type chatServer struct {
+	chat.UnimplementedChatServiceServer
+	hub *Hub
+}
+
+// gatewayAuth admits only calls carrying the gateway's shared secret in
+// x-gateway-secret. ChatService believes whatever user the caller claims to
+// act for, so nothing but the gateway may reach it.
+type gatewayAuth struct {
+	secret []byte
+}
+
+func (a gatewayAuth) check(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var secret string
+	if values := md.Get("x-gateway-secret"); len(values) > 0 {
+		secret = values[0]
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), a.secret) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid gateway secret")
+	}
+	return nil
+}
+
+func (a gatewayAuth) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := a.check(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a gatewayAuth) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// callerFrom returns the acting user from the x-user-id and x-username
+// request metadata. The username defaults to the user ID.
+func callerFrom(ctx context.Context) (userID, username string, err error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-user-id"); len(ids) > 0 {
+		userID = ids[0]
+	}
+	if userID == "" {
+		return "", "", status.Error(codes.Unauthenticated, "missing user")
+	}
+	username = userID
+	if names := md.Get("x-username"); len(names) > 0 && names[0] != "" {
+		username = names[0]
+	}
+	return userID, username, nil
+}
+
+func (s *chatServer) CreateRoom(ctx context.Context, req *chat.CreateRoomRequest) (*chat.Room, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.createRoom(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return roomInfo(room), nil
+}
+
+func (s *chatServer) ListRooms(ctx context.Context, req *chat.ListRoomsRequest) (*chat.ListRoomsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms, err := s.hub.listRooms(ctx, userID, req.Archived)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListRoomsResponse{Rooms: rooms}, nil
+}
+
+func (s *chatServer) JoinRoom(ctx context.Context, req *chat.JoinRoomRequest) (*chat.JoinRoomResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.admit(ctx, room, userID, req.Password); err != nil {
+		return nil, err
+	}
+
+	members, err := s.hub.roomMembers(ctx, room.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := s.hub.messagePage(ctx, room.ID, 0, historyOnJoin)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return &chat.JoinRoomResponse{Room: roomInfo(room), Members: members, History: history}, nil
+}
+
+func (s *chatServer) SendMessage(ctx context.Context, req *chat.SendMessageRequest) (*chat.Message, error) {
+	userID, username, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.hub.isMember(ctx, room, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, status.Error(codes.PermissionDenied, "join the room first")
+	}
+	draft := StoredMessage{UserID: userID, Username: username, Content: req.Content, ParentID: req.ParentId, Emote: req.Emote}
+	return s.hub.sendMessage(ctx, room, draft, req.AttachmentIds)
+}
+
+func (s *chatServer) DeleteRoom(ctx context.Context, req *chat.DeleteRoomRequest) (*chat.DeleteRoomResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.ownedRoom(req.RoomId, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.deleteRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	return &chat.DeleteRoomResponse{Success: true}, nil
+}
+
+func (s *chatServer) ArchiveRoom(ctx context.Context, req *chat.ArchiveRoomRequest) (*chat.Room, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.ownedRoom(req.RoomId, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.archiveRoom(ctx, room, req.Archived); err != nil {
+		return nil, err
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return roomInfo(room), nil
+}
+
+// ListMessages pages backwards through a room's history. Pass the oldest
+// message ID already seen as before_id to get the page preceding it.
+func (s *chatServer) ListMessages(ctx context.Context, req *chat.ListMessagesRequest) (*chat.MessagePage, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.BeforeId < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page")
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.canRead(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	limit := historyOnJoin
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxHistoryPage)
+	}
+	return s.hub.messagePage(ctx, room.ID, req.BeforeId, limit)
+}
+
+func (s *chatServer) ListRoomMembers(ctx context.Context, req *chat.ListRoomMembersRequest) (*chat.ListRoomMembersResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.hub.room(req.RoomId); err != nil {
+		return nil, err
+	}
+	members, err := s.hub.roomMembers(ctx, req.RoomId, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListRoomMembersResponse{Members: members}, nil
+}
+
+func (s *chatServer) OpenConversation(ctx context.Context, req *chat.OpenConversationRequest) (*chat.Room, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.openConversation(ctx, userID, req.UserIds)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return roomInfo(room), nil
+}
+
+func (s *chatServer) ListConversations(ctx context.Context, req *chat.ListConversationsRequest) (*chat.ListConversationsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations, err := s.hub.listConversations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListConversationsResponse{Conversations: conversations}, nil
+}
+
+func (s *chatServer) MarkRead(ctx context.Context, req *chat.MarkReadRequest) (*chat.MarkReadResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := s.hub.markRead(ctx, room, userID, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.MarkReadResponse{Unread: unread}, nil
+}
+
+func (s *chatServer) EditMessage(ctx context.Context, req *chat.EditMessageRequest) (*chat.Message, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.editMessage(ctx, userID, req.MessageId, req.Content)
+}
+
+func (s *chatServer) DeleteMessage(ctx context.Context, req *chat.DeleteMessageRequest) (*chat.DeleteMessageResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.deleteMessage(ctx, userID, req.MessageId); err != nil {
+		return nil, err
+	}
+	return &chat.DeleteMessageResponse{Success: true}, nil
+}
+
+func (s *chatServer) ListMessageEdits(ctx context.Context, req *chat.ListMessageEditsRequest) (*chat.ListMessageEditsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	edits, err := s.hub.messageEdits(ctx, userID, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListMessageEditsResponse{Edits: edits}, nil
+}
+
+func (s *chatServer) GetThread(ctx context.Context, req *chat.GetThreadRequest) (*chat.Thread, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.thread(ctx, userID, req.MessageId)
+}
+
+func (s *chatServer) ListMentions(ctx context.Context, req *chat.ListMentionsRequest) (*chat.ListMentionsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.BeforeId < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page")
+	}
+
+	limit := historyOnJoin
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxHistoryPage)
+	}
+	return s.hub.listMentions(ctx, userID, req.BeforeId, limit, req.UnreadOnly)
+}
+
+func (s *chatServer) SearchMessages(ctx context.Context, req *chat.SearchRequest) (*chat.SearchResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.search(ctx, userID, req)
+}
+
+func (s *chatServer) SetRoomTopic(ctx context.Context, req *chat.SetRoomTopicRequest) (*chat.Room, error) {
+	userID, username, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.setTopic(ctx, room, userID, username, req.Topic); err != nil {
+		return nil, err
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return roomInfo(room), nil
+}
+
+func (s *chatServer) InviteToRoom(ctx context.Context, req *chat.InviteToRoomRequest) (*chat.InviteToRoomResponse, error) {
+	userID, username, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.invite(ctx, room, userID, username, req.UserId); err != nil {
+		return nil, err
+	}
+	return &chat.InviteToRoomResponse{}, nil
+}
+
+func (s *chatServer) KickFromRoom(ctx context.Context, req *chat.KickFromRoomRequest) (*chat.KickFromRoomResponse, error) {
+	userID, username, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.hub.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.kick(ctx, room, userID, username, req.UserId, req.Reason); err != nil {
+		return nil, err
+	}
+	return &chat.KickFromRoomResponse{}, nil
+}
+
+func (s *chatServer) SetLinkPreviews(ctx context.Context, req *chat.SetLinkPreviewsRequest) (*chat.SetLinkPreviewsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.setLinkPreviews(ctx, userID, req); err != nil {
+		return nil, err
+	}
+	return &chat.SetLinkPreviewsResponse{}, nil
+}
+
+func (s *chatServer) CreateWebhook(ctx context.Context, req *chat.CreateWebhookRequest) (*chat.Webhook, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.createWebhook(ctx, userID, req)
+}
+
+// ListWebhooks lists a room's webhooks, or every webhook when room_id is
+// empty, which only moderators may do.
+func (s *chatServer) ListWebhooks(ctx context.Context, req *chat.ListWebhooksRequest) (*chat.ListWebhooksResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := s.hub.listWebhooks(ctx, userID, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListWebhooksResponse{Webhooks: webhooks}, nil
+}
+
+func (s *chatServer) DeleteWebhook(ctx context.Context, req *chat.DeleteWebhookRequest) (*chat.DeleteWebhookResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.deleteWebhook(ctx, userID, req.Id); err != nil {
+		return nil, err
+	}
+	return &chat.DeleteWebhookResponse{Success: true}, nil
+}
+
+// ListWebhookDeliveries pages backwards through the delivery log. Pass the
+// oldest delivery ID already seen as before_id to get the page preceding it.
+func (s *chatServer) ListWebhookDeliveries(ctx context.Context, req *chat.ListWebhookDeliveriesRequest) (*chat.ListWebhookDeliveriesResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.BeforeId < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page")
+	}
+
+	limit := historyOnJoin
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxHistoryPage)
+	}
+	return s.hub.webhookDeliveries(ctx, userID, req, limit)
+}
+
+func (s *chatServer) RetryWebhookDelivery(ctx context.Context, req *chat.RetryWebhookDeliveryRequest) (*chat.WebhookDelivery, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.retryDelivery(ctx, userID, req.DeliveryId)
+}
+
+func (s *chatServer) CreateIncomingWebhook(ctx context.Context, req *chat.CreateIncomingWebhookRequest) (*chat.IncomingWebhook, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.createIncomingWebhook(ctx, userID, req)
+}
+
+func (s *chatServer) ListIncomingWebhooks(ctx context.Context, req *chat.ListIncomingWebhooksRequest) (*chat.ListIncomingWebhooksResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := s.hub.listIncomingWebhooks(ctx, userID, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListIncomingWebhooksResponse{Webhooks: webhooks}, nil
+}
+
+func (s *chatServer) DeleteIncomingWebhook(ctx context.Context, req *chat.DeleteIncomingWebhookRequest) (*chat.DeleteIncomingWebhookResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.deleteIncomingWebhook(ctx, userID, req.Id); err != nil {
+		return nil, err
+	}
+	return &chat.DeleteIncomingWebhookResponse{Success: true}, nil
+}
+
+// PostWebhookMessage is authenticated by the webhook's token, so it takes
+// no caller.
+func (s *chatServer) PostWebhookMessage(ctx context.Context, req *chat.PostWebhookMessageRequest) (*chat.Message, error) {
+	return s.hub.postWebhookMessage(ctx, req)
+}
+
+func (s *chatServer) CreateBot(ctx context.Context, req *chat.CreateBotRequest) (*chat.Bot, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hub.createBot(ctx, userID, req.Name)
+}
+
+func (s *chatServer) ListBots(ctx context.Context, req *chat.ListBotsRequest) (*chat.ListBotsResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bots, err := s.hub.listBots(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ListBotsResponse{Bots: bots}, nil
+}
+
+func (s *chatServer) DeleteBot(ctx context.Context, req *chat.DeleteBotRequest) (*chat.DeleteBotResponse, error) {
+	userID, _, err := callerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.deleteBot(ctx, userID, req.Id); err != nil {
+		return nil, err
+	}
+	return &chat.DeleteBotResponse{Success: true}, nil
+}
+
+// attachmentChunkSize is the size of the chunks DownloadAttachment sends.
+const attachmentChunkSize = 32 << 10
+
+func (s *chatServer) UploadAttachment(stream chat.ChatService_UploadAttachmentServer) error {
+	userID, _, err := callerFrom(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "the first request must carry the attachment info")
+	}
+
+	var data []byte
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data = append(data, req.GetChunk()...)
+		if int64(len(data)) > s.hub.maxAttachmentSize {
+			return status.Error(codes.ResourceExhausted, "file too large")
+		}
+	}
+
+	a, err := s.hub.uploadAttachment(stream.Context(), userID, info.RoomId, info.Filename, data)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(chatAttachmentFrom(a))
+}
+
+func (s *chatServer) DownloadAttachment(req *chat.DownloadAttachmentRequest, stream chat.ChatService_DownloadAttachmentServer) error {
+	userID, _, err := callerFrom(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	a, body, err := s.hub.openAttachment(stream.Context(), userID, req.Id, req.Thumbnail)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	info := chatAttachmentFrom(a)
+	if req.Thumbnail {
+		info.ContentType = a.ThumbnailType
+	}
+	if err := stream.Send(&chat.DownloadAttachmentResponse{Part: &chat.DownloadAttachmentResponse_Info{Info: info}}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, attachmentChunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			chunk := &chat.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]}
+			if err := stream.Send(&chat.DownloadAttachmentResponse{Part: chunk}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// maxRoomsPerClient caps how many rooms a single Chat stream can follow.
+const maxRoomsPerClient = 20
+
+// Chat runs a live session until the client closes its side of the stream
+// or the hub drops it.
+func (s *chatServer) Chat(stream chat.ChatService_ChatServer) error {
+	userID, username, err := callerFrom(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	client := &Client{
+		send:      make(chan *chat.ChatEvent, 256),
+		done:      make(chan struct{}),
+		username:  username,
+		userID:    userID,
+		sessionID: uuid.New().String(),
+		rooms:     make(map[string]*Room),
+		typing:    make(map[string]*typingState),
+	}
+
+	s.hub.addClient(client)
+	s.hub.setPresence(stream.Context(), client, "", true)
+	go client.readPump(s.hub, stream)
+	return client.writePump(stream)
+}
+
+// close stops the client's stream once its pending events are sent.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// actionRoom returns the room an action applies to: one the client joined
+// or a conversation it takes part in.
+func (c *Client) actionRoom(hub *Hub, roomID string) (*Room, error) {
+	if room, ok := c.joinedRoom(roomID); ok {
+		return room, nil
+	}
+	if room, err := hub.room(roomID); err == nil && room.Direct && room.hasParticipant(c.userID) {
+		return room, nil
+	}
+	return nil, status.Error(codes.FailedPrecondition, "join the room first")
+}
+
+// joinedRoom returns the room if the client joined it.
+func (c *Client) joinedRoom(roomID string) (*Room, bool) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	room, ok := c.rooms[roomID]
+	return room, ok
+}
+
+// addRoom records that the client joined a room, unless it already did or
+// follows too many rooms.
+func (c *Client) addRoom(room *Room) error {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if _, ok := c.rooms[room.ID]; ok {
+		return status.Error(codes.AlreadyExists, "already in this room")
+	}
+	if len(c.rooms) >= maxRoomsPerClient {
+		return status.Error(codes.ResourceExhausted, "too many rooms")
+	}
+	c.rooms[room.ID] = room
+	return nil
+}
+
+// removeRoom forgets a room and reports whether the client was in it.
+func (c *Client) removeRoom(roomID string) bool {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	_, ok := c.rooms[roomID]
+	delete(c.rooms, roomID)
+	return ok
+}
+
+// joinedRooms returns the rooms the client joined.
+func (c *Client) joinedRooms() []*Room {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	rooms := make([]*Room, 0, len(c.rooms))
+	for _, room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// removeRooms forgets every room of the client and returns them.
+func (c *Client) removeRooms() []*Room {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	rooms := make([]*Room, 0, len(c.rooms))
+	for id, room := range c.rooms {
+		rooms = append(rooms, room)
+		delete(c.rooms, id)
+	}
+	return rooms
+}
+
+// reply sends an event to this client only.
+func (c *Client) reply(event *chat.ChatEvent) {
+	select {
+	case c.send <- event:
+	default:
+		log.Printf("Dropping event for slow client %q", c.userID)
+	}
+}
+
+// replyError reports a failed action to the client, hiding internal errors.
+func (c *Client) replyError(roomID string, err error) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.Unknown || st.Code() == codes.Internal {
+		log.Printf("Error handling chat action: %v", err)
+		c.reply(errorEvent(roomID, "internal error"))
+		return
+	}
+	c.reply(errorEvent(roomID, st.Message()))
+}
+
+// disconnect takes a client whose stream ended out of its rooms and the
+// hub.
+func (c *Client) disconnect(hub *Hub) {
+	hub.removeClient(c)
+	for _, room := range c.removeRooms() {
+		c.stopTyping(hub, room)
+		hub.unregister <- Membership{Client: c, Room: room}
+		hub.setPresence(context.Background(), c, room.ID, false)
+	}
+	c.stopAllTyping(hub)
+	hub.setPresence(context.Background(), c, "", false)
+	c.close()
+}
+
+func (c *Client) readPump(hub *Hub, stream chat.ChatService_ChatServer) {
+	defer c.disconnect(hub)
+
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				log.Printf("Chat stream error: %v", err)
+			}
+			return
+		}
+
+		switch req.Action {
+		case "join":
+			if err := c.join(ctx, hub, req); err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		case "leave":
+			if err := c.leave(ctx, hub, req.RoomId); err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		case "typing":
+			room, err := c.actionRoom(hub, req.RoomId)
+			if err != nil {
+				c.replyError(req.RoomId, err)
+				continue
+			}
+			c.setTyping(hub, room, req.State != "stop")
+		case "message":
+			room, err := c.actionRoom(hub, req.RoomId)
+			if err != nil {
+				c.replyError(req.RoomId, err)
+				continue
+			}
+			c.stopTyping(hub, room)
+			draft := StoredMessage{UserID: c.userID, Username: c.name(), Content: req.Content, ParentID: req.ParentId, Emote: req.Emote}
+			if _, err := hub.sendMessage(ctx, room, draft, req.AttachmentIds); err != nil {
+				c.replyError(room.ID, err)
+			}
+		case "dm":
+			room, err := hub.openConversation(ctx, c.userID, req.UserIds)
+			if err != nil {
+				c.replyError("", err)
+				continue
+			}
+			c.stopTyping(hub, room)
+			draft := StoredMessage{UserID: c.userID, Username: c.name(), Content: req.Content, Emote: req.Emote}
+			if _, err := hub.sendMessage(ctx, room, draft, nil); err != nil {
+				c.replyError(room.ID, err)
+			}
+		case "edit":
+			if _, err := hub.editMessage(ctx, c.userID, req.MessageId, req.Content); err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		case "delete":
+			if err := hub.deleteMessage(ctx, c.userID, req.MessageId); err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		case "react", "unreact":
+			if err := hub.react(ctx, c.userID, req.MessageId, req.Emoji, req.Action == "react"); err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		case "nick":
+			if err := c.rename(ctx, hub, req.Content); err != nil {
+				c.replyError("", err)
+			}
+		case "read":
+			room, err := c.actionRoom(hub, req.RoomId)
+			if err == nil {
+				_, err = hub.markRead(ctx, room, c.userID, req.MessageId)
+			}
+			if err != nil {
+				c.replyError(req.RoomId, err)
+			}
+		default:
+			c.reply(errorEvent(req.RoomId, "unknown action"))
+		}
+	}
+}
+
+// join admits the client to a room, then acknowledges with the room's
+// current members followed by its recent history.
+func (c *Client) join(ctx context.Context, hub *Hub, req *chat.ChatRequest) error {
+	room, err := hub.room(req.RoomId)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.joinedRoom(room.ID); ok {
+		return status.Error(codes.AlreadyExists, "already in this room")
+	}
+	if err := hub.admit(ctx, room, c.userID, req.Password); err != nil {
+		return err
+	}
+
+	if err := c.addRoom(room); err != nil {
+		return err
+	}
+	hub.register <- Membership{Client: c, Room: room}
+	hub.setPresence(ctx, c, room.ID, true)
+
+	members, err := hub.roomMembers(ctx, room.ID, c.userID)
+	if err != nil {
+		log.Printf("Error fetching room presence: %v", err)
+	}
+	receipts, err := hub.readReceipts(ctx, room.ID)
+	if err != nil {
+		log.Printf("Error loading read receipts: %v", err)
+	}
+
+	hub.mu.RLock()
+	info := roomInfo(room)
+	hub.mu.RUnlock()
+	c.reply(&chat.ChatEvent{
+		RoomId: room.ID,
+		Event: &chat.ChatEvent_Joined{Joined: &chat.Joined{
+			UserId:    c.userID,
+			Room:      info,
+			Members:   members,
+			Moderator: hub.isModerator(room, c.userID),
+			Receipts:  receipts,
+		}},
+	})
+
+	page, err := hub.messagePage(ctx, room.ID, 0, historyOnJoin)
+	if err != nil {
+		log.Printf("Error loading messages: %v", err)
+		return nil
+	}
+	c.reply(&chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_History{History: page}})
+	return nil
+}
+
+// leave removes the client from one of its rooms. The stream stays open.
+func (c *Client) leave(ctx context.Context, hub *Hub, roomID string) error {
+	room, ok := c.joinedRoom(roomID)
+	if !ok || !c.removeRoom(roomID) {
+		return status.Error(codes.FailedPrecondition, "not in this room")
+	}
+
+	c.stopTyping(hub, room)
+	hub.unregister <- Membership{Client: c, Room: room}
+	hub.setPresence(ctx, c, room.ID, false)
+	c.reply(&chat.ChatEvent{
+		RoomId: roomID,
+		Event:  &chat.ChatEvent_Left{Left: &chat.Notice{Content: "You left the room"}},
+	})
+	return nil
+}
+
+// writePump sends the client's events down the stream until the client is
+// closed, flushing whatever is still queued.
+func (c *Client) writePump(stream chat.ChatService_ChatServer) error {
+	for {
+		select {
+		case event := <-c.send:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-c.done:
+			for {
+				select {
+				case event := <-c.send:
+					if err := stream.Send(event); err != nil {
+						return err
+					}
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
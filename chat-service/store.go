@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StoredMessage is a chat message as persisted by a MessageStore.
+type StoredMessage struct {
+	ID        int64
+	RoomID    string
+	UserID    string
+	Username  string
+	Content   string
+	CreatedAt time.Time
+	EditedAt  time.Time // zero unless edited
+	DeletedAt time.Time // zero unless deleted; deleted messages keep no content
+	ParentID  int64     // the thread's first message, 0 outside threads
+	Emote     bool      // an action of the author, sent with /me
+	Bot       bool      // posted by an integration rather than a user
+	// ReplyCount and LastReplyAt summarize the replies to a message,
+	// deleted ones excluded.
+	ReplyCount  int64
+	LastReplyAt time.Time
+	Reactions   []StoredReaction
+	Attachments []StoredAttachment
+	Previews    []StoredLinkPreview
+}
+
+// StoredLinkPreview describes a page linked from a message.
+type StoredLinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// StoredReaction is everyone who reacted to a message with one emoji.
+type StoredReaction struct {
+	Emoji   string
+	UserIDs []string // in the order they reacted
+}
+
+// StoredEdit is an earlier version of an edited message.
+type StoredEdit struct {
+	Content string
+	// WrittenAt is when this version was sent or last edited.
+	WrittenAt time.Time
+}
+
+// StoredMention records that a message mentioned a user.
+type StoredMention struct {
+	ID     int64
+	UserID string
+	Kind   string // "user", "room" or "here"
+	// Message is the mentioning message; SaveMentions only needs its ID
+	// and RoomID.
+	Message StoredMessage
+	// Read is set once the user's read marker in the room has passed the
+	// message.
+	Read bool
+}
+
+// SearchQuery is a full-text search of messages.
+type SearchQuery struct {
+	// Text is words and "quoted phrases" that must all match a message's
+	// content, author or room name. Room names match anywhere in the
+	// name, ignoring ASCII case.
+	Text string
+	// RoomIDs limits the search to these rooms; an empty list finds
+	// nothing.
+	RoomIDs []string
+	// UserID, After and Before optionally filter by author and sending
+	// time.
+	UserID        string
+	After, Before time.Time
+	Offset, Limit int
+}
+
+// Snippets mark the matched words with these.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// SearchHit is a message found by a search, best matches first.
+type SearchHit struct {
+	Message StoredMessage
+	// Snippet is an excerpt of the message's content with its matches
+	// between snippetStart and snippetEnd, or empty when only the room
+	// name matched.
+	Snippet string
+}
+
+// StoredAttachment is an uploaded file. Its contents live in a BlobStore.
+type StoredAttachment struct {
+	ID          string
+	RoomID      string
+	UploaderID  string
+	Filename    string
+	ContentType string // as sniffed from the contents
+	Size        int64
+	// Width and Height are set for images that could be decoded.
+	Width, Height int
+	// ThumbnailType is the content type of the image's thumbnail, empty
+	// if it has none.
+	ThumbnailType string
+	MessageID     int64 // 0 until the attachment is sent
+	CreatedAt     time.Time
+}
+
+// errAttachmentUnavailable is returned by SaveMessage when an attachment
+// was already sent or was not uploaded by the author to the room.
+var errAttachmentUnavailable = errors.New("attachment unavailable")
+
+// AttachmentStore keeps track of uploaded files.
+type AttachmentStore interface {
+	SaveAttachment(ctx context.Context, attachment *StoredAttachment) error
+	// GetAttachment returns the attachment with the given ID, or
+	// sql.ErrNoRows.
+	GetAttachment(ctx context.Context, id string) (*StoredAttachment, error)
+	RoomAttachments(ctx context.Context, roomID string) ([]StoredAttachment, error)
+	// UnsentAttachments returns the attachments uploaded before a time and
+	// never sent with a message.
+	UnsentAttachments(ctx context.Context, before time.Time) ([]StoredAttachment, error)
+	DeleteAttachments(ctx context.Context, ids []string) error
+}
+
+// StoredWebhook is a URL a room's events are posted to.
+type StoredWebhook struct {
+	ID        string
+	RoomID    string
+	URL       string
+	Secret    string   // signs the deliveries
+	Events    []string // the kinds of events posted
+	CreatedBy string
+	CreatedAt time.Time
+	// Delivered, Pending and Dead count the deliveries by status. Only
+	// Webhooks fills them in.
+	Delivered, Pending, Dead int64
+}
+
+// Webhook delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// StoredIncomingWebhook lets other systems post into a room.
+type StoredIncomingWebhook struct {
+	ID        string
+	RoomID    string
+	Name      string
+	TokenHash []byte // SHA-256 of the token; the token itself is not kept
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// StoredDelivery is an event posted, or to be posted, to a webhook.
+type StoredDelivery struct {
+	ID             int64
+	WebhookID      string
+	Event          string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	LastStatusCode int // 0 if the last attempt got no response
+	LastError      string
+	CreatedAt      time.Time
+	LastAttemptAt  time.Time // zero before the first attempt
+	NextAttemptAt  time.Time
+}
+
+// WebhookStore keeps webhooks, the queue of their deliveries and incoming
+// webhooks.
+type WebhookStore interface {
+	SaveWebhook(ctx context.Context, webhook *StoredWebhook) error
+	// GetWebhook returns the webhook with the given ID, or sql.ErrNoRows.
+	GetWebhook(ctx context.Context, id string) (*StoredWebhook, error)
+	// Webhooks returns the webhooks of a room, or of every room if roomID
+	// is empty, oldest first and with their delivery counts.
+	Webhooks(ctx context.Context, roomID string) ([]StoredWebhook, error)
+	// DeleteWebhook removes a webhook and its deliveries.
+	DeleteWebhook(ctx context.Context, id string) error
+	// QueueDeliveries stores new deliveries, filling in their IDs.
+	QueueDeliveries(ctx context.Context, deliveries []StoredDelivery) error
+	// DueDeliveries returns up to limit pending deliveries whose next
+	// attempt is due at now, oldest first.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]StoredDelivery, error)
+	// UpdateDelivery records the status and attempts of a delivery.
+	UpdateDelivery(ctx context.Context, delivery *StoredDelivery) error
+	// GetDelivery returns the delivery with the given ID, or sql.ErrNoRows.
+	GetDelivery(ctx context.Context, id int64) (*StoredDelivery, error)
+	// Deliveries returns up to limit deliveries older than beforeID,
+	// newest first, of one webhook or of all if webhookID is empty, and
+	// optionally only those with a status. A beforeID of 0 starts from the
+	// latest delivery.
+	Deliveries(ctx context.Context, webhookID, status string, beforeID int64, limit int) ([]StoredDelivery, error)
+	// PruneDeliveries removes the deliveries that succeeded before a time.
+	PruneDeliveries(ctx context.Context, before time.Time) error
+
+	SaveIncomingWebhook(ctx context.Context, webhook *StoredIncomingWebhook) error
+	// GetIncomingWebhook returns the incoming webhook with the given ID, or
+	// sql.ErrNoRows.
+	GetIncomingWebhook(ctx context.Context, id string) (*StoredIncomingWebhook, error)
+	// IncomingWebhooks returns the incoming webhooks of a room, oldest
+	// first.
+	IncomingWebhooks(ctx context.Context, roomID string) ([]StoredIncomingWebhook, error)
+	DeleteIncomingWebhook(ctx context.Context, id string) error
+}
+
+// StoredBot is a bot account.
+type StoredBot struct {
+	ID        string
+	Name      string
+	OwnerID   string
+	KeyHash   []byte // SHA-256 of the API key; the key itself is not kept
+	CreatedAt time.Time
+}
+
+// BotStore keeps bot accounts.
+type BotStore interface {
+	SaveBot(ctx context.Context, bot *StoredBot) error
+	// GetBot returns the bot with the given ID, or sql.ErrNoRows.
+	GetBot(ctx context.Context, id string) (*StoredBot, error)
+	// BotByKey returns the bot with the given API key hash, or
+	// sql.ErrNoRows.
+	BotByKey(ctx context.Context, keyHash []byte) (*StoredBot, error)
+	// Bots returns the bots of an owner, oldest first.
+	Bots(ctx context.Context, ownerID string) ([]StoredBot, error)
+	DeleteBot(ctx context.Context, id string) error
+}
+
+// StoredRoom is a room's metadata as persisted by a RoomStore.
+type StoredRoom struct {
+	ID           string
+	Name         string
+	Owner        string
+	MaxMembers   int
+	PasswordHash []byte
+	CreatedAt    time.Time
+	Archived     bool
+	// Direct rooms are conversations between a fixed set of users.
+	Direct       bool
+	Participants []string
+	Topic        string
+}
+
+// RoomStore persists rooms so they survive restarts and periods without
+// members. Ephemeral rooms are never stored.
+type RoomStore interface {
+	SaveRoom(ctx context.Context, room *StoredRoom) error
+	// ListRooms returns every stored room, archived ones and conversations
+	// included. Participants are only filled in for conversations.
+	ListRooms(ctx context.Context) ([]StoredRoom, error)
+	SetRoomArchived(ctx context.Context, roomID string, archived bool) error
+	SetRoomTopic(ctx context.Context, roomID, topic string) error
+	// DeleteRoom removes the room, its messages, attachment records,
+	// memberships and webhooks.
+	DeleteRoom(ctx context.Context, roomID string) error
+	// AddMember records that the user joined the room. Members may rejoin
+	// without the room's password and read its history.
+	AddMember(ctx context.Context, roomID, userID string) error
+	// RemoveMember forgets that the user joined the room, so password
+	// protected rooms ask them for the password again.
+	RemoveMember(ctx context.Context, roomID, userID string) error
+	IsMember(ctx context.Context, roomID, userID string) (bool, error)
+	// Members returns the IDs of every user who joined the room.
+	Members(ctx context.Context, roomID string) ([]string, error)
+	// KnownUser reports whether a user with this ID, compared
+	// case-insensitively, owns or joined a room or sent a message.
+	KnownUser(ctx context.Context, userID string) (bool, error)
+}
+
+// MessageStore persists chat messages. IDs are assigned by the store and
+// increase with every message, so they also order a room's history.
+type MessageStore interface {
+	// SaveMessage stores msg, filling in its ID and CreatedAt, and links
+	// msg.Attachments to it by ID, filling in their details. It fails with
+	// errAttachmentUnavailable, storing nothing, if any of them cannot be
+	// sent.
+	SaveMessage(ctx context.Context, msg *StoredMessage) error
+	// MessagesBefore returns up to limit messages of a room older than
+	// beforeID, oldest first. A beforeID of 0 returns the latest messages.
+	// Thread replies are left out of a room's history.
+	MessagesBefore(ctx context.Context, roomID string, beforeID int64, limit int) ([]StoredMessage, error)
+	// ThreadReplies returns every reply to a message, oldest first.
+	ThreadReplies(ctx context.Context, parentID int64) ([]StoredMessage, error)
+	// GetMessage returns the message with the given ID, or sql.ErrNoRows.
+	GetMessage(ctx context.Context, id int64) (*StoredMessage, error)
+	// EditMessage replaces a message's content, keeping the previous
+	// version in its edit history, and returns the updated message. The
+	// previews of the old content's links are dropped.
+	EditMessage(ctx context.Context, id int64, content string) (*StoredMessage, error)
+	// DeleteMessage removes a message's content, edit history, reactions,
+	// link previews and attachment records, leaving a tombstone in the
+	// room's history.
+	DeleteMessage(ctx context.Context, id int64) error
+	// SetLinkPreviews replaces the link previews of a message. It fails
+	// with sql.ErrNoRows unless the message exists, is not deleted and was
+	// last edited at editedAt (the zero time if it never was).
+	SetLinkPreviews(ctx context.Context, messageID int64, editedAt time.Time, previews []StoredLinkPreview) error
+	// MessageEdits returns the earlier versions of a message, oldest first.
+	MessageEdits(ctx context.Context, id int64) ([]StoredEdit, error)
+	// AddReaction records the user's reaction to a message and returns how
+	// many users now reacted with emoji. added is false if the user had
+	// already reacted with it.
+	AddReaction(ctx context.Context, messageID int64, userID, emoji string) (count int64, added bool, err error)
+	// RemoveReaction takes back the user's reaction and returns how many
+	// users still reacted with emoji. removed is false if there was none.
+	RemoveReaction(ctx context.Context, messageID int64, userID, emoji string) (count int64, removed bool, err error)
+	// MarkRead records that the user read the room up to messageID and
+	// reports whether their read marker moved. Read markers never move
+	// backwards.
+	MarkRead(ctx context.Context, roomID, userID string, messageID int64) (bool, error)
+	// ReadMarkers returns the last message each user read in a room, by
+	// user ID.
+	ReadMarkers(ctx context.Context, roomID string) (map[string]int64, error)
+	// SaveMentions stores mention records, filling in their IDs. A message
+	// mentions each user at most once.
+	SaveMentions(ctx context.Context, mentions []StoredMention) error
+	// Mentions returns up to limit of the user's mentions older than
+	// beforeID, newest first, leaving out deleted messages. A beforeID of
+	// 0 starts from the latest mention.
+	Mentions(ctx context.Context, userID string, beforeID int64, limit int, unreadOnly bool) ([]StoredMention, error)
+	// Search returns the messages matching a query, best matches first.
+	// Deleted messages are never found.
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, error)
+	// UnreadCount counts the room's messages from other users after the
+	// user's read marker, deleted ones and thread replies excluded.
+	UnreadCount(ctx context.Context, roomID, userID string) (int64, error)
+	Close() error
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const attachmentColumns = `id, room_id, uploader_id, filename, content_type, size, width, height, thumbnail_type, message_id, created_at`
+
+func scanAttachment(row scanner) (*StoredAttachment, error) {
+	var a StoredAttachment
+	var messageID *int64
+	var createdAt int64
+	err := row.Scan(&a.ID, &a.RoomID, &a.UploaderID, &a.Filename, &a.ContentType, &a.Size,
+		&a.Width, &a.Height, &a.ThumbnailType, &messageID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if messageID != nil {
+		a.MessageID = *messageID
+	}
+	a.CreatedAt = time.UnixMilli(createdAt)
+	return &a, nil
+}
+
+func (s *sqliteStore) SaveAttachment(ctx context.Context, a *StoredAttachment) error {
+	a.CreatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO attachments (id, room_id, uploader_id, filename, content_type, size, width, height, thumbnail_type, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.RoomID, a.UploaderID, a.Filename, a.ContentType, a.Size, a.Width, a.Height, a.ThumbnailType, a.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *sqliteStore) GetAttachment(ctx context.Context, id string) (*StoredAttachment, error) {
+	return scanAttachment(s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) RoomAttachments(ctx context.Context, roomID string) ([]StoredAttachment, error) {
+	return s.queryAttachments(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE room_id = ?`, roomID)
+}
+
+func (s *sqliteStore) UnsentAttachments(ctx context.Context, before time.Time) ([]StoredAttachment, error) {
+	return s.queryAttachments(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE message_id IS NULL AND created_at < ?`, before.UnixMilli())
+}
+
+func (s *sqliteStore) DeleteAttachments(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM attachments WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	return err
+}
+
+func (s *sqliteStore) queryAttachments(ctx context.Context, query string, args ...any) ([]StoredAttachment, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []StoredAttachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+	return attachments, rows.Err()
+}
+
+// attachAttachments fills in the attachments of messages, in upload order.
+func attachAttachments(ctx context.Context, q querier, messages []StoredMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*StoredMessage, len(messages))
+	args := make([]any, 0, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+		args = append(args, messages[i].ID)
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments
+		WHERE message_id IN (?`+strings.Repeat(", ?", len(args)-1)+`) ORDER BY created_at, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		msg := byID[a.MessageID]
+		msg.Attachments = append(msg.Attachments, *a)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const botColumns = `id, name, owner_id, key_hash, created_at`
+
+func scanBot(row scanner) (*StoredBot, error) {
+	var b StoredBot
+	var createdAt int64
+	if err := row.Scan(&b.ID, &b.Name, &b.OwnerID, &b.KeyHash, &createdAt); err != nil {
+		return nil, err
+	}
+	b.CreatedAt = time.UnixMilli(createdAt)
+	return &b, nil
+}
+
+func (s *sqliteStore) SaveBot(ctx context.Context, b *StoredBot) error {
+	b.CreatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO bots (id, name, owner_id, key_hash, created_at) VALUES (?, ?, ?, ?, ?)`,
+		b.ID, b.Name, b.OwnerID, b.KeyHash, b.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *sqliteStore) GetBot(ctx context.Context, id string) (*StoredBot, error) {
+	return scanBot(s.db.QueryRowContext(ctx, `SELECT `+botColumns+` FROM bots WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) BotByKey(ctx context.Context, keyHash []byte) (*StoredBot, error) {
+	return scanBot(s.db.QueryRowContext(ctx, `SELECT `+botColumns+` FROM bots WHERE key_hash = ?`, keyHash))
+}
+
+func (s *sqliteStore) Bots(ctx context.Context, ownerID string) ([]StoredBot, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+botColumns+` FROM bots WHERE owner_id = ? ORDER BY created_at`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []StoredBot
+	for rows.Next() {
+		b, err := scanBot(rows)
+		if err != nil {
+			return nil, err
+		}
+		bots = append(bots, *b)
+	}
+	return bots, rows.Err()
+}
+
+func (s *sqliteStore) DeleteBot(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM bots WHERE id = ?`, id)
+	return err
+}
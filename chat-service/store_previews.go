@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+func (s *sqliteStore) SetLinkPreviews(ctx context.Context, messageID int64, editedAt time.Time, previews []StoredLinkPreview) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The previews must match the version of the message they were made for
+	var found int
+	err = tx.QueryRowContext(ctx,
+		`SELECT 1 FROM messages WHERE id = ? AND deleted_at IS NULL AND COALESCE(edited_at, 0) = ?`,
+		messageID, unixMilli(editedAt)).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_previews WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	for i, p := range previews {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO link_previews (message_id, position, url, title, description, image_url, site_name)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			messageID, i, p.URL, p.Title, p.Description, p.ImageURL, p.SiteName)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// attachLinkPreviews fills in the link previews of messages, in the order
+// their links appear.
+func attachLinkPreviews(ctx context.Context, q querier, messages []StoredMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*StoredMessage, len(messages))
+	args := make([]any, 0, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+		args = append(args, messages[i].ID)
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT message_id, url, title, description, image_url, site_name FROM link_previews
+		WHERE message_id IN (?`+strings.Repeat(", ?", len(args)-1)+`) ORDER BY message_id, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int64
+		var p StoredLinkPreview
+		if err := rows.Scan(&messageID, &p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName); err != nil {
+			return err
+		}
+		msg := byID[messageID]
+		msg.Previews = append(msg.Previews, p)
+	}
+	return rows.Err()
+}
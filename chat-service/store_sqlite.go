@@ -0,0 +1,947 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id    TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	username   TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_room ON messages(room_id, id);
+
+CREATE TABLE IF NOT EXISTS rooms (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL,
+	owner         TEXT NOT NULL,
+	max_members   INTEGER NOT NULL,
+	password_hash BLOB,
+	created_at    INTEGER NOT NULL,
+	archived      INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+	room_id   TEXT NOT NULL,
+	user_id   TEXT NOT NULL,
+	joined_at INTEGER NOT NULL,
+	PRIMARY KEY (room_id, user_id)
+);
+`
+
+// sqliteMigrations upgrade databases created with an older schema. The
+// database's user_version counts the migrations already applied; new
+// migrations are only ever appended.
+var sqliteMigrations = []string{
+	`ALTER TABLE rooms ADD COLUMN direct INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE room_reads (
+		room_id      TEXT NOT NULL,
+		user_id      TEXT NOT NULL,
+		last_read_id INTEGER NOT NULL,
+		PRIMARY KEY (room_id, user_id)
+	);`,
+	`ALTER TABLE messages ADD COLUMN edited_at INTEGER;
+	ALTER TABLE messages ADD COLUMN deleted_at INTEGER;
+	CREATE TABLE message_edits (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		content    TEXT NOT NULL,
+		written_at INTEGER NOT NULL
+	);
+	CREATE INDEX message_edits_message ON message_edits(message_id, id);`,
+	`ALTER TABLE messages ADD COLUMN parent_id INTEGER;
+	CREATE INDEX messages_parent ON messages(parent_id, id);`,
+	`CREATE TABLE message_reactions (
+		message_id INTEGER NOT NULL,
+		user_id    TEXT NOT NULL,
+		emoji      TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (message_id, emoji, user_id)
+	);`,
+	`CREATE TABLE mentions (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		room_id    TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		kind       TEXT NOT NULL,
+		UNIQUE (message_id, user_id)
+	);
+	CREATE INDEX mentions_user ON mentions(user_id, id);`,
+	// Room names are not indexed: Search matches them against rooms. The
+	// snippet markers are kept out of the index, so snippets only carry
+	// the ones FTS5 adds.
+	`CREATE VIRTUAL TABLE messages_fts USING fts5(content, username);
+	INSERT INTO messages_fts (rowid, content, username)
+		SELECT id, replace(replace(content, char(2), ''), char(3), ''), replace(replace(username, char(2), ''), char(3), '')
+		FROM messages WHERE deleted_at IS NULL;
+	CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (rowid, content, username)
+		VALUES (new.id, replace(replace(new.content, char(2), ''), char(3), ''), replace(replace(new.username, char(2), ''), char(3), ''));
+	END;
+	CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+		UPDATE messages_fts SET content = replace(replace(new.content, char(2), ''), char(3), '') WHERE rowid = new.id;
+	END;
+	CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+		DELETE FROM messages_fts WHERE rowid = old.id;
+	END;`,
+	`CREATE TABLE attachments (
+		id             TEXT PRIMARY KEY,
+		room_id        TEXT NOT NULL,
+		uploader_id    TEXT NOT NULL,
+		filename       TEXT NOT NULL,
+		content_type   TEXT NOT NULL,
+		size           INTEGER NOT NULL,
+		width          INTEGER NOT NULL DEFAULT 0,
+		height         INTEGER NOT NULL DEFAULT 0,
+		thumbnail_type TEXT NOT NULL DEFAULT '',
+		message_id     INTEGER,
+		created_at     INTEGER NOT NULL
+	);
+	CREATE INDEX attachments_message ON attachments(message_id);
+	CREATE INDEX attachments_room ON attachments(room_id);`,
+	`CREATE TABLE link_previews (
+		message_id  INTEGER NOT NULL,
+		position    INTEGER NOT NULL,
+		url         TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		description TEXT NOT NULL,
+		image_url   TEXT NOT NULL,
+		site_name   TEXT NOT NULL,
+		PRIMARY KEY (message_id, position)
+	);`,
+	`ALTER TABLE messages ADD COLUMN emote INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN topic TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE webhooks (
+		id         TEXT PRIMARY KEY,
+		room_id    TEXT NOT NULL,
+		url        TEXT NOT NULL,
+		secret     TEXT NOT NULL,
+		events     TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX webhooks_room ON webhooks(room_id);
+	CREATE TABLE webhook_deliveries (
+		id               INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id       TEXT NOT NULL,
+		event            TEXT NOT NULL,
+		payload          BLOB NOT NULL,
+		status           TEXT NOT NULL,
+		attempts         INTEGER NOT NULL DEFAULT 0,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error       TEXT NOT NULL DEFAULT '',
+		created_at       INTEGER NOT NULL,
+		last_attempt_at  INTEGER,
+		next_attempt_at  INTEGER NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);`,
+	`ALTER TABLE messages ADD COLUMN bot INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE incoming_webhooks (
+		id         TEXT PRIMARY KEY,
+		room_id    TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		token_hash BLOB NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX incoming_webhooks_room ON incoming_webhooks(room_id);`,
+	`CREATE TABLE bots (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		owner_id   TEXT NOT NULL,
+		key_hash   BLOB NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX bots_owner ON bots(owner_id);`,
+}
+
+// sqliteStore is the default MessageStore and RoomStore, backed by an
+// embedded SQLite database file.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialize access instead of
+	// retrying on SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// migrate applies the migrations the database has not seen yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveMessage(ctx context.Context, msg *StoredMessage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	msg.CreatedAt = time.Now()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO messages (room_id, user_id, username, content, created_at, parent_id, emote, bot) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.RoomID, msg.UserID, msg.Username, msg.Content, msg.CreatedAt.UnixMilli(), sql.NullInt64{Int64: msg.ParentID, Valid: msg.ParentID != 0}, msg.Emote, msg.Bot)
+	if err != nil {
+		return err
+	}
+	if msg.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+
+	for i, a := range msg.Attachments {
+		linked, err := scanAttachment(tx.QueryRowContext(ctx,
+			`UPDATE attachments SET message_id = ?
+			WHERE id = ? AND room_id = ? AND uploader_id = ? AND message_id IS NULL
+			RETURNING `+attachmentColumns,
+			msg.ID, a.ID, msg.RoomID, msg.UserID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errAttachmentUnavailable
+		}
+		if err != nil {
+			return err
+		}
+		msg.Attachments[i] = *linked
+	}
+	return tx.Commit()
+}
+
+// messageColumns selects a StoredMessage from the messages table, along with
+// its thread summary.
+const messageColumns = `id, room_id, user_id, username, content, created_at, edited_at, deleted_at, parent_id, emote, bot,
+	(SELECT COUNT(*) FROM messages r WHERE r.parent_id = messages.id AND r.deleted_at IS NULL),
+	(SELECT MAX(r.created_at) FROM messages r WHERE r.parent_id = messages.id AND r.deleted_at IS NULL)`
+
+// scanner is the Scan method shared by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row scanner) (*StoredMessage, error) {
+	var msg StoredMessage
+	var createdAt int64
+	var editedAt, deletedAt, parentID, lastReplyAt sql.NullInt64
+	if err := row.Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Username, &msg.Content, &createdAt, &editedAt, &deletedAt,
+		&parentID, &msg.Emote, &msg.Bot, &msg.ReplyCount, &lastReplyAt); err != nil {
+		return nil, err
+	}
+	msg.CreatedAt = time.UnixMilli(createdAt)
+	if editedAt.Valid {
+		msg.EditedAt = time.UnixMilli(editedAt.Int64)
+	}
+	if deletedAt.Valid {
+		msg.DeletedAt = time.UnixMilli(deletedAt.Int64)
+	}
+	msg.ParentID = parentID.Int64
+	if lastReplyAt.Valid {
+		msg.LastReplyAt = time.UnixMilli(lastReplyAt.Int64)
+	}
+	return &msg, nil
+}
+
+func (s *sqliteStore) MessagesBefore(ctx context.Context, roomID string, beforeID int64, limit int) ([]StoredMessage, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages
+		WHERE room_id = ? AND parent_id IS NULL AND (? = 0 OR id < ?) ORDER BY id DESC LIMIT ?`
+	rows, err := s.db.QueryContext(ctx, query, roomID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachDetails(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	// Oldest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (s *sqliteStore) ThreadReplies(ctx context.Context, parentID int64) ([]StoredMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE parent_id = ? ORDER BY id`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, attachDetails(ctx, s.db, messages)
+}
+
+// scanMessages reads every message of rows and closes it.
+func scanMessages(rows *sql.Rows) ([]StoredMessage, error) {
+	defer rows.Close()
+
+	var messages []StoredMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqliteStore) GetMessage(ctx context.Context, id int64) (*StoredMessage, error) {
+	msg, err := scanMessage(s.db.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	messages := []StoredMessage{*msg}
+	if err := attachDetails(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// querier is the QueryContext method shared by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// attachDetails fills in the reactions, link previews and attachments of
+// messages.
+func attachDetails(ctx context.Context, q querier, messages []StoredMessage) error {
+	if err := attachReactions(ctx, q, messages); err != nil {
+		return err
+	}
+	if err := attachLinkPreviews(ctx, q, messages); err != nil {
+		return err
+	}
+	return attachAttachments(ctx, q, messages)
+}
+
+// attachReactions fills in the reactions of messages, grouped by emoji in
+// the order each emoji was first used.
+func attachReactions(ctx context.Context, q querier, messages []StoredMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*StoredMessage, len(messages))
+	args := make([]any, 0, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+		args = append(args, messages[i].ID)
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT message_id, emoji, user_id FROM message_reactions
+		WHERE message_id IN (?`+strings.Repeat(", ?", len(args)-1)+`) ORDER BY created_at, rowid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int64
+		var emoji, userID string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return err
+		}
+		msg := byID[messageID]
+		i := slices.IndexFunc(msg.Reactions, func(r StoredReaction) bool { return r.Emoji == emoji })
+		if i < 0 {
+			msg.Reactions = append(msg.Reactions, StoredReaction{Emoji: emoji})
+			i = len(msg.Reactions) - 1
+		}
+		msg.Reactions[i].UserIDs = append(msg.Reactions[i].UserIDs, userID)
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) EditMessage(ctx context.Context, id int64, content string) (*StoredMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO message_edits (message_id, content, written_at)
+		SELECT id, content, COALESCE(edited_at, created_at) FROM messages WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE messages SET content = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		content, time.Now().UnixMilli(), id)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_previews WHERE message_id = ?`, id); err != nil {
+		return nil, err
+	}
+
+	msg, err := scanMessage(tx.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	messages := []StoredMessage{*msg}
+	if err := attachDetails(ctx, tx, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], tx.Commit()
+}
+
+func (s *sqliteStore) DeleteMessage(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_edits WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_reactions WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_previews WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE messages SET content = '', deleted_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		time.Now().UnixMilli(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) MessageEdits(ctx context.Context, id int64) ([]StoredEdit, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT content, written_at FROM message_edits WHERE message_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edits []StoredEdit
+	for rows.Next() {
+		var edit StoredEdit
+		var writtenAt int64
+		if err := rows.Scan(&edit.Content, &writtenAt); err != nil {
+			return nil, err
+		}
+		edit.WrittenAt = time.UnixMilli(writtenAt)
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+func (s *sqliteStore) AddReaction(ctx context.Context, messageID int64, userID, emoji string) (int64, bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)`,
+		messageID, userID, emoji, time.Now().UnixMilli())
+	if err != nil {
+		return 0, false, err
+	}
+	return s.reactionCount(ctx, messageID, emoji, res)
+}
+
+func (s *sqliteStore) RemoveReaction(ctx context.Context, messageID int64, userID, emoji string) (int64, bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?`,
+		messageID, userID, emoji)
+	if err != nil {
+		return 0, false, err
+	}
+	return s.reactionCount(ctx, messageID, emoji, res)
+}
+
+// reactionCount counts a message's reactions with emoji after res changed
+// them, and reports whether res changed anything.
+func (s *sqliteStore) reactionCount(ctx context.Context, messageID int64, emoji string, res sql.Result) (int64, bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	var count int64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM message_reactions WHERE message_id = ? AND emoji = ?`, messageID, emoji).Scan(&count)
+	return count, n > 0, err
+}
+
+func (s *sqliteStore) SaveRoom(ctx context.Context, room *StoredRoom) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO rooms (id, name, owner, max_members, password_hash, created_at, archived, direct, topic) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		room.ID, room.Name, room.Owner, room.MaxMembers, room.PasswordHash, room.CreatedAt.UnixMilli(), room.Archived, room.Direct, room.Topic)
+	if err != nil {
+		return err
+	}
+	for _, userID := range room.Participants {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO room_members (room_id, user_id, joined_at) VALUES (?, ?, ?)`,
+			room.ID, userID, room.CreatedAt.UnixMilli())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ListRooms(ctx context.Context) ([]StoredRoom, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, owner, max_members, password_hash, created_at, archived, direct, topic FROM rooms ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []StoredRoom
+	for rows.Next() {
+		var room StoredRoom
+		var createdAt int64
+		if err := rows.Scan(&room.ID, &room.Name, &room.Owner, &room.MaxMembers, &room.PasswordHash, &createdAt, &room.Archived, &room.Direct, &room.Topic); err != nil {
+			return nil, err
+		}
+		room.CreatedAt = time.UnixMilli(createdAt)
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	participants, err := s.participants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rooms {
+		if rooms[i].Direct {
+			rooms[i].Participants = participants[rooms[i].ID]
+		}
+	}
+	return rooms, nil
+}
+
+// participants returns the members of every conversation, by room ID.
+func (s *sqliteStore) participants(ctx context.Context) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT m.room_id, m.user_id FROM room_members m JOIN rooms r ON r.id = m.room_id
+		WHERE r.direct = 1 ORDER BY m.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make(map[string][]string)
+	for rows.Next() {
+		var roomID, userID string
+		if err := rows.Scan(&roomID, &userID); err != nil {
+			return nil, err
+		}
+		participants[roomID] = append(participants[roomID], userID)
+	}
+	return participants, rows.Err()
+}
+
+func (s *sqliteStore) SetRoomArchived(ctx context.Context, roomID string, archived bool) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE rooms SET archived = ? WHERE id = ?`, archived, roomID)
+	return err
+}
+
+func (s *sqliteStore) SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE rooms SET topic = ? WHERE id = ?`, topic, roomID)
+	return err
+}
+
+func (s *sqliteStore) DeleteRoom(ctx context.Context, roomID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM message_edits WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM message_reactions WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM link_previews WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM room_members WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM room_reads WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mentions WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE room_id = ?)`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM incoming_webhooks WHERE room_id = ?`, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = ?`, roomID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) AddMember(ctx context.Context, roomID, userID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO room_members (room_id, user_id, joined_at) VALUES (?, ?, ?)`,
+		roomID, userID, time.Now().UnixMilli())
+	return err
+}
+
+func (s *sqliteStore) RemoveMember(ctx context.Context, roomID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM room_members WHERE room_id = ? AND user_id = ?`, roomID, userID)
+	return err
+}
+
+func (s *sqliteStore) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = ? AND user_id = ?)`,
+		roomID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqliteStore) KnownUser(ctx context.Context, userID string) (bool, error) {
+	var known bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM room_members WHERE user_id = ? COLLATE NOCASE)
+		OR EXISTS(SELECT 1 FROM rooms WHERE owner = ? COLLATE NOCASE)
+		OR EXISTS(SELECT 1 FROM messages WHERE user_id = ? COLLATE NOCASE)`,
+		userID, userID, userID).Scan(&known)
+	return known, err
+}
+
+func (s *sqliteStore) Members(ctx context.Context, roomID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id FROM room_members WHERE room_id = ? ORDER BY user_id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		members = append(members, userID)
+	}
+	return members, rows.Err()
+}
+
+func (s *sqliteStore) SaveMentions(ctx context.Context, mentions []StoredMention) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range mentions {
+		m := &mentions[i]
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO mentions (message_id, room_id, user_id, kind) VALUES (?, ?, ?, ?)`,
+			m.Message.ID, m.Message.RoomID, m.UserID, m.Kind)
+		if err != nil {
+			return err
+		}
+		if m.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Mentions(ctx context.Context, userID string, beforeID int64, limit int, unreadOnly bool) ([]StoredMention, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, message_id, kind, read FROM (
+			SELECT mn.id, mn.message_id, mn.kind,
+				mn.message_id <= COALESCE(
+					(SELECT last_read_id FROM room_reads r WHERE r.room_id = mn.room_id AND r.user_id = mn.user_id), 0) AS read
+			FROM mentions mn JOIN messages m ON m.id = mn.message_id
+			WHERE mn.user_id = ? AND m.deleted_at IS NULL AND (? = 0 OR mn.id < ?)
+		) WHERE NOT (? AND read) ORDER BY id DESC LIMIT ?`,
+		userID, beforeID, beforeID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []StoredMention
+	var ids []any
+	for rows.Next() {
+		m := StoredMention{UserID: userID}
+		if err := rows.Scan(&m.ID, &m.Message.ID, &m.Kind, &m.Read); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, m)
+		ids = append(ids, m.Message.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	// Load the mentioning messages in one go
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachDetails(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]StoredMessage, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+	for i := range mentions {
+		mentions[i].Message = byID[mentions[i].Message.ID]
+	}
+	return mentions, nil
+}
+
+func (s *sqliteStore) Search(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 || len(q.RoomIDs) == 0 {
+		return nil, nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = ftsQuote(term)
+	}
+
+	// Messages matching any term are ranked, content matches counting
+	// for more than author ones; those found only by their room's name
+	// come last.
+	query := `SELECT ` + messageColumns + `, COALESCE(hits.snippet, '') FROM messages LEFT JOIN (
+			SELECT rowid AS hit_id, bm25(messages_fts, 4.0, 1.0) AS rank,
+				snippet(messages_fts, 0, ?, ?, '…', 16) AS snippet
+			FROM messages_fts WHERE messages_fts MATCH ?
+		) hits ON hits.hit_id = messages.id
+		WHERE messages.deleted_at IS NULL AND messages.room_id IN (?` + strings.Repeat(", ?", len(q.RoomIDs)-1) + `)`
+	args := []any{snippetStart, snippetEnd, strings.Join(quoted, " OR ")}
+	for _, roomID := range q.RoomIDs {
+		args = append(args, roomID)
+	}
+	// Every term has to match the content, the author or the room name
+	for i, term := range terms {
+		query += ` AND (messages.id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)
+			OR messages.room_id IN (SELECT id FROM rooms WHERE instr(lower(name), ?) > 0))`
+		args = append(args, quoted[i], strings.ToLower(term))
+	}
+	if q.UserID != "" {
+		query += ` AND messages.user_id = ?`
+		args = append(args, q.UserID)
+	}
+	if !q.After.IsZero() {
+		query += ` AND messages.created_at >= ?`
+		args = append(args, q.After.UnixMilli())
+	}
+	if !q.Before.IsZero() {
+		query += ` AND messages.created_at < ?`
+		args = append(args, q.Before.UnixMilli())
+	}
+	query += ` ORDER BY hits.rank IS NULL, hits.rank, messages.id DESC LIMIT ? OFFSET ?`
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		msg, err := scanMessage(snippetScanner{rows, &hit.Snippet})
+		if err != nil {
+			return nil, err
+		}
+		hit.Message = *msg
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	messages := make([]StoredMessage, len(hits))
+	for i := range hits {
+		messages[i] = hits[i].Message
+	}
+	if err := attachDetails(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Message = messages[i]
+	}
+	return hits, nil
+}
+
+// snippetScanner scans a message row followed by a search snippet.
+type snippetScanner struct {
+	row     scanner
+	snippet *string
+}
+
+func (s snippetScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.snippet)...)
+}
+
+// searchTerms splits search text into its words and "quoted phrases".
+func searchTerms(text string) []string {
+	var terms []string
+	for i, chunk := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(chunk); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(chunk)...)
+	}
+	return terms
+}
+
+// ftsQuote turns a search term into an FTS5 phrase, so FTS5 operators in
+// it are searched for like any other word.
+func ftsQuote(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
+
+func (s *sqliteStore) MarkRead(ctx context.Context, roomID, userID string, messageID int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO room_reads (room_id, user_id, last_read_id) VALUES (?, ?, ?)
+		ON CONFLICT (room_id, user_id) DO UPDATE SET last_read_id = excluded.last_read_id
+		WHERE excluded.last_read_id > room_reads.last_read_id`,
+		roomID, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqliteStore) ReadMarkers(ctx context.Context, roomID string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, last_read_id FROM room_reads WHERE room_id = ?`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := make(map[string]int64)
+	for rows.Next() {
+		var userID string
+		var messageID int64
+		if err := rows.Scan(&userID, &messageID); err != nil {
+			return nil, err
+		}
+		markers[userID] = messageID
+	}
+	return markers, rows.Err()
+}
+
+func (s *sqliteStore) UnreadCount(ctx context.Context, roomID, userID string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM messages WHERE room_id = ? AND user_id != ? AND deleted_at IS NULL AND parent_id IS NULL AND id > COALESCE(
+			(SELECT last_read_id FROM room_reads WHERE room_id = ? AND user_id = ?), 0)`,
+		roomID, userID, roomID, userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const webhookColumns = `id, room_id, url, secret, events, created_by, created_at`
+
+func scanWebhook(row scanner, extra ...any) (*StoredWebhook, error) {
+	var w StoredWebhook
+	var events string
+	var createdAt int64
+	dest := append([]any{&w.ID, &w.RoomID, &w.URL, &w.Secret, &events, &w.CreatedBy, &createdAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	w.Events = strings.Split(events, ",")
+	w.CreatedAt = time.UnixMilli(createdAt)
+	return &w, nil
+}
+
+func (s *sqliteStore) SaveWebhook(ctx context.Context, w *StoredWebhook) error {
+	w.CreatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhooks (id, room_id, url, secret, events, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		w.ID, w.RoomID, w.URL, w.Secret, strings.Join(w.Events, ","), w.CreatedBy, w.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *sqliteStore) GetWebhook(ctx context.Context, id string) (*StoredWebhook, error) {
+	return scanWebhook(s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) Webhooks(ctx context.Context, roomID string) ([]StoredWebhook, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+webhookColumns+`,
+			(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = webhooks.id AND d.status = 'delivered'),
+			(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = webhooks.id AND d.status = 'pending'),
+			(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = webhooks.id AND d.status = 'dead')
+		FROM webhooks WHERE ? = '' OR room_id = ? ORDER BY created_at`, roomID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []StoredWebhook
+	for rows.Next() {
+		var delivered, pending, dead int64
+		w, err := scanWebhook(rows, &delivered, &pending, &dead)
+		if err != nil {
+			return nil, err
+		}
+		w.Delivered, w.Pending, w.Dead = delivered, pending, dead
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqliteStore) DeleteWebhook(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, last_status_code, last_error,
+	created_at, last_attempt_at, next_attempt_at`
+
+func scanDelivery(row scanner) (*StoredDelivery, error) {
+	var d StoredDelivery
+	var createdAt, nextAttemptAt int64
+	var lastAttemptAt sql.NullInt64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.LastStatusCode, &d.LastError,
+		&createdAt, &lastAttemptAt, &nextAttemptAt)
+	if err != nil {
+		return nil, err
+	}
+	d.CreatedAt = time.UnixMilli(createdAt)
+	if lastAttemptAt.Valid {
+		d.LastAttemptAt = time.UnixMilli(lastAttemptAt.Int64)
+	}
+	d.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+	return &d, nil
+}
+
+func (s *sqliteStore) queryDeliveries(ctx context.Context, query string, args ...any) ([]StoredDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []StoredDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqliteStore) QueueDeliveries(ctx context.Context, deliveries []StoredDelivery) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range deliveries {
+		d := &deliveries[i]
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (webhook_id, event, payload, status, created_at, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			d.WebhookID, d.Event, d.Payload, d.Status, d.CreatedAt.UnixMilli(), d.NextAttemptAt.UnixMilli())
+		if err != nil {
+			return err
+		}
+		if d.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]StoredDelivery, error) {
+	return s.queryDeliveries(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		now.UnixMilli(), limit)
+}
+
+func (s *sqliteStore) UpdateDelivery(ctx context.Context, d *StoredDelivery) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status_code = ?, last_error = ?,
+			last_attempt_at = ?, next_attempt_at = ?
+		WHERE id = ?`,
+		d.Status, d.Attempts, d.LastStatusCode, d.LastError,
+		sql.NullInt64{Int64: unixMilli(d.LastAttemptAt), Valid: !d.LastAttemptAt.IsZero()}, d.NextAttemptAt.UnixMilli(), d.ID)
+	return err
+}
+
+func (s *sqliteStore) GetDelivery(ctx context.Context, id int64) (*StoredDelivery, error) {
+	return scanDelivery(s.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) Deliveries(ctx context.Context, webhookID, status string, beforeID int64, limit int) ([]StoredDelivery, error) {
+	return s.queryDeliveries(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE (? = '' OR webhook_id = ?) AND (? = '' OR status = ?) AND (? = 0 OR id < ?)
+		ORDER BY id DESC LIMIT ?`,
+		webhookID, webhookID, status, status, beforeID, beforeID, limit)
+}
+
+func (s *sqliteStore) PruneDeliveries(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE status = 'delivered' AND created_at < ?`, before.UnixMilli())
+	return err
+}
+
+const incomingWebhookColumns = `id, room_id, name, token_hash, created_by, created_at`
+
+func scanIncomingWebhook(row scanner) (*StoredIncomingWebhook, error) {
+	var w StoredIncomingWebhook
+	var createdAt int64
+	if err := row.Scan(&w.ID, &w.RoomID, &w.Name, &w.TokenHash, &w.CreatedBy, &createdAt); err != nil {
+		return nil, err
+	}
+	w.CreatedAt = time.UnixMilli(createdAt)
+	return &w, nil
+}
+
+func (s *sqliteStore) SaveIncomingWebhook(ctx context.Context, w *StoredIncomingWebhook) error {
+	w.CreatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO incoming_webhooks (id, room_id, name, token_hash, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		w.ID, w.RoomID, w.Name, w.TokenHash, w.CreatedBy, w.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *sqliteStore) GetIncomingWebhook(ctx context.Context, id string) (*StoredIncomingWebhook, error) {
+	return scanIncomingWebhook(s.db.QueryRowContext(ctx,
+		`SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) IncomingWebhooks(ctx context.Context, roomID string) ([]StoredIncomingWebhook, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE room_id = ? ORDER BY created_at`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []StoredIncomingWebhook
+	for rows.Next() {
+		w, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqliteStore) DeleteIncomingWebhook(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM incoming_webhooks WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// threadParent resolves the message a reply in room is sent to. Threads are
+// one level deep: replying to a reply continues the reply's thread.
+func (h *Hub) threadParent(ctx context.Context, room *Room, parentID int64) (*StoredMessage, error) {
+	parent, parentRoom, err := h.message(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parentRoom.ID != room.ID {
+		return nil, status.Error(codes.InvalidArgument, "parent message is in another room")
+	}
+	if parent.ParentID != 0 {
+		return h.messages.GetMessage(ctx, parent.ParentID)
+	}
+	return parent, nil
+}
+
+// broadcastReply tells the room about a new reply along with its thread's
+// updated summary.
+func (h *Hub) broadcastReply(ctx context.Context, room *Room, reply *chat.Message) error {
+	parent, err := h.messages.GetMessage(ctx, reply.ParentId)
+	if err != nil {
+		return err
+	}
+
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event: &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Reply{Reply: &chat.Reply{
+			Message:     reply,
+			ReplyCount:  parent.ReplyCount,
+			LastReplyAt: unixMilli(parent.LastReplyAt),
+		}}},
+	}
+	return nil
+}
+
+// thread returns the thread containing a message, if the user can read its
+// room. The thread's first message may have been deleted since.
+func (h *Hub) thread(ctx context.Context, userID string, messageID int64) (*chat.Thread, error) {
+	msg, err := h.messages.GetMessage(ctx, messageID)
+	if err == nil && msg.ParentID != 0 {
+		msg, err = h.messages.GetMessage(ctx, msg.ParentID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := h.room(msg.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canRead(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	stored, err := h.messages.ThreadReplies(ctx, msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]*chat.Message, 0, len(stored))
+	for _, reply := range stored {
+		replies = append(replies, chatMessageFrom(reply))
+	}
+	return &chat.Thread{Parent: chatMessageFrom(*msg), Replies: replies}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sendReply replies to parentID in the room as userID.
+func sendReply(room *Room, hub *Hub, userID string, parentID int64) (*chat.Message, error) {
+	draft := StoredMessage{UserID: userID, Username: userID, Content: "reply", ParentID: parentID}
+	return hub.sendMessage(context.Background(), room, draft, nil)
+}
+
+func TestThreadReplyCount(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	parent := sendTestMessage(t, hub, room, "alice", "question")
+
+	var replies []int64
+	for _, userID := range []string{"bob", "carol", "bob"} {
+		reply, err := sendReply(room, hub, userID, parent)
+		if err != nil {
+			t.Fatalf("sendMessage: %v", err)
+		}
+		if reply.ParentId != parent {
+			t.Fatalf("reply parent = %d, want %d", reply.ParentId, parent)
+		}
+		replies = append(replies, reply.Id)
+	}
+	if err := hub.deleteMessage(ctx, "bob", replies[0]); err != nil {
+		t.Fatalf("deleteMessage: %v", err)
+	}
+
+	thread, err := hub.thread(ctx, "bob", parent)
+	if err != nil {
+		t.Fatalf("thread: %v", err)
+	}
+	if thread.Parent.ReplyCount != 2 || thread.Parent.LastReplyAt == 0 {
+		t.Fatalf("parent = %v, want two live replies", thread.Parent)
+	}
+	// The deleted reply stays in place as a tombstone
+	if len(thread.Replies) != 3 || !thread.Replies[0].Deleted || thread.Replies[1].Id != replies[1] || thread.Replies[2].Id != replies[2] {
+		t.Fatalf("replies = %v, want %v oldest first", thread.Replies, replies)
+	}
+}
+
+func TestThreadsDoNotNest(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	ctx := context.Background()
+	parent := sendTestMessage(t, hub, room, "alice", "question")
+
+	first, err := sendReply(room, hub, "bob", parent)
+	if err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	nested, err := sendReply(room, hub, "carol", first.Id)
+	if err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	if nested.ParentId != parent {
+		t.Fatalf("reply to a reply has parent %d, want the thread's first message %d", nested.ParentId, parent)
+	}
+
+	// Asking for a reply's thread returns the whole thread
+	thread, err := hub.thread(ctx, "alice", first.Id)
+	if err != nil {
+		t.Fatalf("thread: %v", err)
+	}
+	if thread.Parent.Id != parent || thread.Parent.ReplyCount != 2 || len(thread.Replies) != 2 {
+		t.Fatalf("thread = %v, want both replies under %d", thread, parent)
+	}
+}
+
+func TestThreadParentInAnotherRoom(t *testing.T) {
+	hub := newTestHub(t)
+	room := createTestRoom(t, hub, "alice")
+	other, err := hub.createRoom(context.Background(), "alice", &chat.CreateRoomRequest{Name: "random", MaxMembers: 10})
+	if err != nil {
+		t.Fatalf("createRoom: %v", err)
+	}
+	parent := sendTestMessage(t, hub, other, "alice", "elsewhere")
+
+	if _, err := sendReply(room, hub, "alice", parent); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("reply across rooms = %v, want InvalidArgument", err)
+	}
+	if _, err := sendReply(room, hub, "alice", parent+100); status.Code(err) != codes.NotFound {
+		t.Fatalf("reply to a missing message = %v, want NotFound", err)
+	}
+}
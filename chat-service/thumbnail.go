@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	// Register the GIF decoder with image.Decode
+	_ "image/gif"
+)
+
+const (
+	// thumbnailSize bounds the width and height of thumbnails.
+	thumbnailSize = 256
+	// maxImagePixels keeps small files that decode to huge images from
+	// exhausting memory.
+	maxImagePixels = 40_000_000
+)
+
+var errImageTooLarge = errors.New("image too large")
+
+// imageSize returns the dimensions of an image without decoding it.
+func imageSize(data []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// thumbnail scales an image down to fit thumbnailSize, returning the
+// encoded thumbnail and its content type. JPEG images stay JPEG; everything
+// else becomes PNG to keep transparency.
+func thumbnail(data []byte) ([]byte, string, error) {
+	width, height, err := imageSize(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if width <= 0 || height <= 0 || width*height > maxImagePixels {
+		return nil, "", errImageTooLarge
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	dst := scaleDown(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&buf, dst)
+	return buf.Bytes(), "image/png", err
+}
+
+// scaleDown resizes src to fit within size×size, keeping its aspect ratio,
+// by averaging the source pixels that fall into each destination pixel.
+// Images that already fit are only copied.
+func scaleDown(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, h*size/w)
+		} else {
+			dw, dh = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
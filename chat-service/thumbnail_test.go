@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// pngHeader returns the start of a PNG claiming the given dimensions,
+// which is all DecodeConfig reads.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestThumbnailRefusesHugeImages(t *testing.T) {
+	data := pngHeader(20_000, 20_000)
+	if width, height, err := imageSize(data); err != nil || width*height <= maxImagePixels {
+		t.Fatalf("imageSize = %d×%d, %v, want more than %d pixels", width, height, err, maxImagePixels)
+	}
+	if _, _, err := thumbnail(data); err != errImageTooLarge {
+		t.Fatalf("thumbnail = %v, want errImageTooLarge", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		wantType      string
+		width, height int
+	}{
+		{"wide png", encodePNG(t, 1000, 250), "image/png", thumbnailSize, thumbnailSize / 4},
+		{"tall png", encodePNG(t, 100, 512), "image/png", 50, thumbnailSize},
+		{"small png", encodePNG(t, 40, 30), "image/png", 40, 30},
+		{"jpeg", encodeJPEG(t, 600, 600), "image/jpeg", thumbnailSize, thumbnailSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, contentType, err := thumbnail(tt.data)
+			if err != nil {
+				t.Fatalf("thumbnail: %v", err)
+			}
+			if contentType != tt.wantType {
+				t.Fatalf("content type = %q, want %q", contentType, tt.wantType)
+			}
+			width, height, err := imageSize(thumb)
+			if err != nil || width != tt.width || height != tt.height {
+				t.Fatalf("thumbnail is %d×%d, %v, want %d×%d", width, height, err, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 90, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
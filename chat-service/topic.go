@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxLineLength bounds topics and kick reasons, in characters.
+const maxLineLength = 250
+
+// validLine reports whether s is a single line of at most maxLineLength
+// characters.
+func validLine(s string) bool {
+	return utf8.ValidString(s) && utf8.RuneCountInString(s) <= maxLineLength && !strings.ContainsAny(s, "\r\n")
+}
+
+// setTopic changes a room's topic on behalf of one of its moderators and
+// tells the room. An empty topic clears it.
+func (h *Hub) setTopic(ctx context.Context, room *Room, userID, username, topic string) error {
+	topic = strings.TrimSpace(topic)
+	if !validLine(topic) {
+		return status.Errorf(codes.InvalidArgument, "topics are a single line of at most %d characters", maxLineLength)
+	}
+	if room.Direct {
+		return status.Error(codes.FailedPrecondition, "conversations have no topic")
+	}
+	if !h.isModerator(room, userID) {
+		return status.Error(codes.PermissionDenied, "only moderators can set the topic")
+	}
+	if err := h.notArchived(room); err != nil {
+		return err
+	}
+
+	if !room.Ephemeral {
+		if err := h.roomStore.SetRoomTopic(ctx, room.ID, topic); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	room.Topic = topic
+	h.mu.Unlock()
+
+	change := &chat.TopicChange{UserId: userID, Username: username, Topic: topic}
+	h.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event:  &chat.ChatEvent{RoomId: room.ID, Event: &chat.ChatEvent_Topic{Topic: change}},
+	}
+	return nil
+}
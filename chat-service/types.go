@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+	"go-grpc-basic/proto/presence"
+)
+
+type Room struct {
+	ID           string
+	Name         string
+	PasswordHash []byte
+	MaxMembers   int
+	Members      map[*Client]bool
+	CreatedBy    string
+	CreatedAt    time.Time
+	Ephemeral    bool // deleted when the last member leaves, never stored
+	Archived     bool // kept with its history but closed for joining
+	Direct       bool // a conversation, open to its participants only
+	Participants []string
+	Topic        string
+}
+
+// Client is a single Chat stream, which may follow several rooms.
+type Client struct {
+	send      chan *chat.ChatEvent
+	done      chan struct{} // closed when the hub drops the client
+	closeOnce sync.Once
+	userID    string
+	sessionID string
+
+	nameMu   sync.Mutex
+	username string // shown with the user's messages, changed with "nick"
+
+	roomsMu sync.Mutex
+	rooms   map[string]*Room // room_id -> joined room
+
+	typingMu sync.Mutex
+	typing   map[string]*typingState // room_id -> active typing indicator
+}
+
+// Membership is a client in one of its rooms.
+type Membership struct {
+	Client *Client
+	Room   *Room
+}
+
+// RoomClosure disconnects every member of a room, e.g. when it is deleted
+// or archived, telling them why.
+type RoomClosure struct {
+	RoomID string
+	Reason string
+	// UserID, if set, disconnects only that user's streams, as when they
+	// are kicked.
+	UserID string
+}
+
+type BroadcastMessage struct {
+	RoomID string
+	Event  *chat.ChatEvent
+	// UserID, if set, sends Event to every stream of that user instead of
+	// the room's members.
+	UserID string
+}
+
+type Hub struct {
+	rooms      map[string]*Room
+	clients    map[string]map[*Client]bool // user_id -> open streams
+	mu         sync.RWMutex
+	openMu     sync.Mutex // serializes conversation creation
+	register   chan Membership
+	unregister chan Membership
+	broadcast  chan BroadcastMessage
+	closeRoom  chan RoomClosure
+	presence   presence.PresenceServiceClient
+	messages   MessageStore
+	roomStore  RoomStore
+	moderators map[string]bool // user_ids that may moderate every room
+
+	attachments       AttachmentStore
+	blobs             BlobStore
+	maxAttachmentSize int64 // in bytes
+
+	webhooks       *webhookDispatcher
+	incomingLimits *rateLimiter // per incoming webhook
+	bots           BotStore
+
+	presenceUpdates chan *presence.PresenceUpdate
+	presenceRooms   map[string][]string // user_id -> rooms in their last update; run loop only
+}
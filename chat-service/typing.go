@@ -0,0 +1,113 @@
+package main
+
+import (
+	"time"
+
+	"go-grpc-basic/proto/chat"
+)
+
+const (
+	// typingThrottle is the minimum interval between repeated typing-start
+	// broadcasts for the same client and room.
+	typingThrottle = 2 * time.Second
+	// typingTimeout is how long a typing indicator lives without a refresh
+	// from the client before it is stopped automatically.
+	typingTimeout = 5 * time.Second
+)
+
+// typingState is a client's typing indicator in one room.
+type typingState struct {
+	timer *time.Timer
+	last  time.Time // last typing-start broadcast
+}
+
+// setTyping handles a typing action from the client. Typing events are
+// ephemeral: they are broadcast to the room and never stored.
+func (c *Client) setTyping(hub *Hub, room *Room, typing bool) {
+	if !typing {
+		c.stopTyping(hub, room)
+		return
+	}
+	if c.refreshTyping(hub, room) {
+		c.broadcastTyping(hub, room, true)
+	}
+}
+
+// refreshTyping starts or extends the client's typing indicator in the
+// room and reports whether a typing-start is due.
+func (c *Client) refreshTyping(hub *Hub, room *Room) bool {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	state, ok := c.typing[room.ID]
+	if ok {
+		state.timer.Reset(typingTimeout)
+	} else {
+		state = &typingState{}
+		state.timer = time.AfterFunc(typingTimeout, func() { c.stopTyping(hub, room) })
+		c.typing[room.ID] = state
+	}
+
+	if time.Since(state.last) < typingThrottle {
+		return false
+	}
+	state.last = time.Now()
+	return true
+}
+
+// stopTyping clears any active typing indicator in the room, e.g. when the
+// client sends a message there or leaves.
+func (c *Client) stopTyping(hub *Hub, room *Room) {
+	if c.clearTyping(room.ID) {
+		c.broadcastTyping(hub, room, false)
+	}
+}
+
+// stopAllTyping clears every typing indicator the client still has, e.g.
+// in conversations it never joined, when its stream ends.
+func (c *Client) stopAllTyping(hub *Hub) {
+	c.typingMu.Lock()
+	var stopped []*Room
+	for roomID, state := range c.typing {
+		state.timer.Stop()
+		delete(c.typing, roomID)
+		if room, err := hub.room(roomID); err == nil {
+			stopped = append(stopped, room)
+		}
+	}
+	c.typingMu.Unlock()
+
+	for _, room := range stopped {
+		c.broadcastTyping(hub, room, false)
+	}
+}
+
+// clearTyping drops the typing indicator of a room without telling anyone,
+// e.g. when the room was closed, and reports whether there was one.
+func (c *Client) clearTyping(roomID string) bool {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	state, ok := c.typing[roomID]
+	if ok {
+		state.timer.Stop()
+		delete(c.typing, roomID)
+	}
+	return ok
+}
+
+// broadcastTyping waits on the hub's run loop, so it is never called with
+// typingMu held.
+func (c *Client) broadcastTyping(hub *Hub, room *Room, typing bool) {
+	hub.broadcast <- BroadcastMessage{
+		RoomID: room.ID,
+		Event: &chat.ChatEvent{
+			RoomId: room.ID,
+			Event: &chat.ChatEvent_Typing{Typing: &chat.Typing{
+				UserId:   c.userID,
+				Username: c.name(),
+				Typing:   typing,
+			}},
+		},
+	}
+}
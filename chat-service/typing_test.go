@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypingBroadcastDoesNotHoldLock(t *testing.T) {
+	// No run loop, so broadcasts wait until the test takes them
+	hub := &Hub{broadcast: make(chan BroadcastMessage)}
+	room := &Room{ID: "r1"}
+	c := &Client{userID: "alice", username: "alice", typing: make(map[string]*typingState)}
+
+	sent := make(chan struct{})
+	go func() {
+		c.setTyping(hub, room, true)
+		close(sent)
+	}()
+
+	// While the typing-start waits on the hub, the indicator can still
+	// be cleared, as the timeout would
+	cleared := make(chan struct{})
+	go func() {
+		for !c.clearTyping(room.ID) {
+			time.Sleep(time.Millisecond)
+		}
+		close(cleared)
+	}()
+	select {
+	case <-cleared:
+	case <-time.After(time.Second):
+		t.Fatal("clearTyping blocked behind a pending broadcast")
+	}
+
+	msg := <-hub.broadcast
+	<-sent
+	if typing := msg.Event.GetTyping(); typing == nil || !typing.Typing || typing.UserId != "alice" {
+		t.Fatalf("broadcast %v, want alice typing", msg.Event)
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxWebhooksPerRoom caps the webhooks of one room.
+	maxWebhooksPerRoom = 10
+	maxWebhookURL      = 2048
+	// webhookTimeout bounds one delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// maxConcurrentDeliveries bounds the attempts running at once.
+	maxConcurrentDeliveries = 8
+	// maxRetryDelay caps the backoff between attempts.
+	maxRetryDelay = time.Hour
+	// deliveryRetention is how long successful deliveries stay in the
+	// delivery log.
+	deliveryRetention = 7 * 24 * time.Hour
+)
+
+// webhookEvents are the kinds of events webhooks can subscribe to.
+var webhookEvents = []string{"message", "join", "leave", "reaction"}
+
+// webhookDispatcher posts room events to the rooms' webhooks. Events are
+// stored as pending deliveries first, so they survive restarts, and
+// attempted until they succeed or run out of attempts, which leaves them
+// dead for an admin to look into.
+type webhookDispatcher struct {
+	store       WebhookStore
+	client      *http.Client
+	maxAttempts int
+	// retryDelay is the delay before the second attempt, doubled after
+	// every further failure.
+	retryDelay time.Duration
+	queue      chan queuedEvent
+	wake       chan struct{}
+	slots      chan struct{}
+
+	mu       sync.Mutex
+	byRoom   map[string][]*StoredWebhook
+	byID     map[string]*StoredWebhook
+	inflight map[int64]bool // IDs of the deliveries being attempted
+}
+
+// queuedEvent is an event to store as a delivery to each of webhookIDs.
+type queuedEvent struct {
+	webhookIDs []string
+	kind       string
+	payload    []byte
+	time       time.Time
+}
+
+func newWebhookDispatcher(store WebhookStore, client *http.Client, maxAttempts int, retryDelay time.Duration) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:       store,
+		client:      client,
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+		queue:       make(chan queuedEvent, 1024),
+		wake:        make(chan struct{}, 1),
+		slots:       make(chan struct{}, maxConcurrentDeliveries),
+		byRoom:      make(map[string][]*StoredWebhook),
+		byID:        make(map[string]*StoredWebhook),
+		inflight:    make(map[int64]bool),
+	}
+}
+
+// newWebhookClient returns an HTTP client for delivering webhooks. Unless
+// allowPrivate is set, which is meant for testing against local servers,
+// it only connects to public addresses, checked on the address actually
+// dialed. Redirects are not followed.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("webhook: address %s not allowed", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// Never go through a proxy, which would dial for us unchecked
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: webhookTimeout,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// blockedPrefixes are special-purpose ranges that publicAddr rejects on top
+// of the private, loopback, link-local and multicast ones.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// publicAddr reports whether addr is a public unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the registered webhooks.
+func (d *webhookDispatcher) load(ctx context.Context) error {
+	webhooks, err := d.store.Webhooks(ctx, "")
+	if err != nil {
+		return err
+	}
+	for i := range webhooks {
+		d.add(&webhooks[i])
+	}
+	return nil
+}
+
+func (d *webhookDispatcher) add(w *StoredWebhook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byRoom[w.RoomID] = append(d.byRoom[w.RoomID], w)
+	d.byID[w.ID] = w
+}
+
+func (d *webhookDispatcher) remove(w *StoredWebhook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byRoom[w.RoomID] = slices.DeleteFunc(d.byRoom[w.RoomID], func(o *StoredWebhook) bool { return o.ID == w.ID })
+	if len(d.byRoom[w.RoomID]) == 0 {
+		delete(d.byRoom, w.RoomID)
+	}
+	delete(d.byID, w.ID)
+}
+
+// removeRoom forgets the webhooks of a deleted room.
+func (d *webhookDispatcher) removeRoom(roomID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.byRoom[roomID] {
+		delete(d.byID, w.ID)
+	}
+	delete(d.byRoom, roomID)
+}
+
+func (d *webhookDispatcher) roomWebhookCount(roomID string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.byRoom[roomID])
+}
+
+// emit queues an event of a room for the webhooks that subscribed to its
+// kind. data is a proto message or a value encoding/json can marshal. emit
+// never blocks, so the hub's run loop may call it; if the queue is full,
+// the event is dropped.
+func (d *webhookDispatcher) emit(roomID, kind string, data any) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	var webhookIDs []string
+	for _, w := range d.byRoom[roomID] {
+		if slices.Contains(w.Events, kind) {
+			webhookIDs = append(webhookIDs, w.ID)
+		}
+	}
+	d.mu.Unlock()
+	if len(webhookIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	payload, err := webhookPayload(roomID, kind, data, now)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+	select {
+	case d.queue <- queuedEvent{webhookIDs: webhookIDs, kind: kind, payload: payload, time: now}:
+	default:
+		log.Printf("Webhook queue full, dropping %s event of room %s", kind, roomID)
+	}
+}
+
+// webhookPayload is the JSON body of a delivery. Proto data is encoded
+// the way the gateway encodes events, with the proto field names.
+func webhookPayload(roomID, kind string, data any, now time.Time) ([]byte, error) {
+	var encoded []byte
+	var err error
+	if m, ok := data.(proto.Message); ok {
+		encoded, err = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(m)
+	} else {
+		encoded, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Event  string          `json:"event"`
+		RoomID string          `json:"room_id"`
+		Time   int64           `json:"time"`
+		Data   json.RawMessage `json:"data"`
+	}{kind, roomID, now.UnixMilli(), encoded})
+}
+
+// webhookUser is the data of join and leave events.
+func webhookUser(c *Client) map[string]string {
+	return map[string]string{"user_id": c.userID, "username": c.name()}
+}
+
+// run stores queued events as deliveries and attempts the deliveries that
+// are due, until ctx is done.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.queue:
+			deliveries := make([]StoredDelivery, len(event.webhookIDs))
+			for i, id := range event.webhookIDs {
+				deliveries[i] = StoredDelivery{
+					WebhookID:     id,
+					Event:         event.kind,
+					Payload:       event.payload,
+					Status:        deliveryPending,
+					CreatedAt:     event.time,
+					NextAttemptAt: event.time,
+				}
+			}
+			if err := d.store.QueueDeliveries(ctx, deliveries); err != nil {
+				log.Printf("Error queueing webhook deliveries: %v", err)
+				continue
+			}
+			d.deliverDue(ctx)
+		case <-d.wake:
+			d.deliverDue(ctx)
+		case <-poll.C:
+			d.deliverDue(ctx)
+		case <-prune.C:
+			if err := d.store.PruneDeliveries(ctx, time.Now().Add(-deliveryRetention)); err != nil {
+				log.Printf("Error pruning webhook deliveries: %v", err)
+			}
+		}
+	}
+}
+
+// wakeUp makes run look for due deliveries right away.
+func (d *webhookDispatcher) wakeUp() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDue starts attempting the due deliveries that are not in flight
+// yet.
+func (d *webhookDispatcher) deliverDue(ctx context.Context) {
+	due, err := d.store.DueDeliveries(ctx, time.Now(), 100)
+	if err != nil {
+		log.Printf("Error loading webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range due {
+		d.mu.Lock()
+		webhook, ok := d.byID[delivery.WebhookID]
+		if !ok || d.inflight[delivery.ID] {
+			d.mu.Unlock()
+			continue
+		}
+		d.inflight[delivery.ID] = true
+		d.mu.Unlock()
+
+		go func() {
+			d.slots <- struct{}{}
+			defer func() {
+				<-d.slots
+				d.mu.Lock()
+				delete(d.inflight, delivery.ID)
+				d.mu.Unlock()
+			}()
+			d.attempt(ctx, webhook, &delivery)
+		}()
+	}
+}
+
+// attempt posts a delivery once and records the outcome: delivered, due
+// again after a backoff, or dead after the last attempt.
+func (d *webhookDispatcher) attempt(ctx context.Context, w *StoredWebhook, delivery *StoredDelivery) {
+	code, err := d.post(ctx, w, delivery)
+	delivery.Attempts++
+	delivery.LastAttemptAt = time.Now()
+	delivery.LastStatusCode = code
+	switch {
+	case err == nil:
+		delivery.Status = deliveryDelivered
+		delivery.LastError = ""
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = deliveryDead
+		delivery.LastError = err.Error()
+		log.Printf("Webhook %s: giving up on delivery %d after %d attempts: %v", w.ID, delivery.ID, delivery.Attempts, err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = delivery.LastAttemptAt.Add(d.backoff(delivery.Attempts))
+	}
+	if err := d.store.UpdateDelivery(ctx, delivery); err != nil {
+		log.Printf("Error updating webhook delivery: %v", err)
+	}
+}
+
+// backoff is the delay after the given number of failed attempts, with
+// jitter so deliveries that failed together are not retried together.
+func (d *webhookDispatcher) backoff(attempts int) time.Duration {
+	delay := maxRetryDelay
+	if shift := attempts - 1; shift < 30 {
+		delay = min(d.retryDelay<<shift, maxRetryDelay)
+	}
+	return delay/2 + mathrand.N(delay/2+1)
+}
+
+func (d *webhookDispatcher) post(ctx context.Context, w *StoredWebhook, delivery *StoredDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-webhooks/1.0")
+	req.Header.Set("X-Chat-Event", delivery.Event)
+	req.Header.Set("X-Chat-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Chat-Signature", signPayload(w.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain some of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload is the X-Chat-Signature of a payload.
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// canManageWebhooks reports whether the user may manage a room's webhooks:
+// its owner and the service-wide moderators.
+func (h *Hub) canManageWebhooks(roomID, userID string) bool {
+	if h.moderators[userID] {
+		return true
+	}
+	room, err := h.room(roomID)
+	return err == nil && room.CreatedBy == userID
+}
+
+// manageableWebhook loads a webhook the user may manage.
+func (h *Hub) manageableWebhook(ctx context.Context, userID, id string) (*StoredWebhook, error) {
+	w, err := h.webhooks.store.GetWebhook(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !h.canManageWebhooks(w.RoomID, userID) {
+		return nil, status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+	}
+	return w, nil
+}
+
+func (h *Hub) createWebhook(ctx context.Context, userID string, req *chat.CreateWebhookRequest) (*chat.Webhook, error) {
+	room, err := h.room(req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	if room.Direct || room.Ephemeral {
+		return nil, status.Error(codes.FailedPrecondition, "conversations and ephemeral rooms cannot have webhooks")
+	}
+	if !h.canManageWebhooks(room.ID, userID) {
+		return nil, status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+	}
+	if !validWebhookURL(req.Url) {
+		return nil, status.Error(codes.InvalidArgument, "webhook URLs must be absolute http or https URLs")
+	}
+	events, err := webhookEventFilter(req.Events)
+	if err != nil {
+		return nil, err
+	}
+	if h.webhooks.roomWebhookCount(room.ID) >= maxWebhooksPerRoom {
+		return nil, status.Errorf(codes.ResourceExhausted, "at most %d webhooks per room", maxWebhooksPerRoom)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	w := &StoredWebhook{
+		ID:        uuid.New().String(),
+		RoomID:    room.ID,
+		URL:       req.Url,
+		Secret:    hex.EncodeToString(secret),
+		Events:    events,
+		CreatedBy: userID,
+	}
+	if err := h.webhooks.store.SaveWebhook(ctx, w); err != nil {
+		return nil, err
+	}
+	h.webhooks.add(w)
+
+	created := chatWebhookFrom(*w)
+	created.Secret = w.Secret
+	return created, nil
+}
+
+// listWebhooks returns the webhooks of a room, or every webhook for the
+// service-wide moderators.
+func (h *Hub) listWebhooks(ctx context.Context, userID, roomID string) ([]*chat.Webhook, error) {
+	if roomID == "" {
+		if !h.moderators[userID] {
+			return nil, status.Error(codes.PermissionDenied, "only moderators can list every webhook")
+		}
+	} else {
+		if _, err := h.room(roomID); err != nil {
+			return nil, err
+		}
+		if !h.canManageWebhooks(roomID, userID) {
+			return nil, status.Error(codes.PermissionDenied, "only the room owner can manage its webhooks")
+		}
+	}
+
+	stored, err := h.webhooks.store.Webhooks(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]*chat.Webhook, 0, len(stored))
+	for _, w := range stored {
+		webhooks = append(webhooks, chatWebhookFrom(w))
+	}
+	return webhooks, nil
+}
+
+func (h *Hub) deleteWebhook(ctx context.Context, userID, id string) error {
+	w, err := h.manageableWebhook(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := h.webhooks.store.DeleteWebhook(ctx, w.ID); err != nil {
+		return err
+	}
+	h.webhooks.remove(w)
+	return nil
+}
+
+// webhookDeliveries pages through the deliveries of a webhook, or of every
+// webhook for the service-wide moderators.
+func (h *Hub) webhookDeliveries(ctx context.Context, userID string, req *chat.ListWebhookDeliveriesRequest, limit int) (*chat.ListWebhookDeliveriesResponse, error) {
+	switch req.Status {
+	case "", deliveryPending, deliveryDelivered, deliveryDead:
+	default:
+		return nil, status.Error(codes.InvalidArgument, `status must be "pending", "delivered" or "dead"`)
+	}
+	if req.WebhookId == "" {
+		if !h.moderators[userID] {
+			return nil, status.Error(codes.PermissionDenied, "only moderators can list every delivery")
+		}
+	} else if _, err := h.manageableWebhook(ctx, userID, req.WebhookId); err != nil {
+		return nil, err
+	}
+
+	stored, err := h.webhooks.store.Deliveries(ctx, req.WebhookId, req.Status, req.BeforeId, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	resp := &chat.ListWebhookDeliveriesResponse{}
+	if len(stored) > limit {
+		resp.HasMore = true
+		stored = stored[:limit]
+	}
+	for _, d := range stored {
+		resp.Deliveries = append(resp.Deliveries, chatDeliveryFrom(d))
+	}
+	return resp, nil
+}
+
+// retryDelivery queues a dead delivery to be attempted again, as if it
+// were new.
+func (h *Hub) retryDelivery(ctx context.Context, userID string, id int64) (*chat.WebhookDelivery, error) {
+	delivery, err := h.webhooks.store.GetDelivery(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "delivery not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.manageableWebhook(ctx, userID, delivery.WebhookID); err != nil {
+		return nil, err
+	}
+	if delivery.Status != deliveryDead {
+		return nil, status.Error(codes.FailedPrecondition, "only dead deliveries can be retried")
+	}
+
+	delivery.Status = deliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	if err := h.webhooks.store.UpdateDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	h.webhooks.wakeUp()
+	return chatDeliveryFrom(*delivery), nil
+}
+
+// validWebhookURL reports whether s is an absolute http or https URL.
+func validWebhookURL(s string) bool {
+	if len(s) > maxWebhookURL {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webhookEventFilter checks the events a webhook subscribes to and returns
+// them in a canonical order. No events means all of them.
+func webhookEventFilter(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return slices.Clone(webhookEvents), nil
+	}
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown webhook event %q", event)
+		}
+	}
+	filter := make([]string, 0, len(events))
+	for _, event := range webhookEvents {
+		if slices.Contains(events, event) {
+			filter = append(filter, event)
+		}
+	}
+	return filter, nil
+}
+
+func chatWebhookFrom(w StoredWebhook) *chat.Webhook {
+	return &chat.Webhook{
+		Id:        w.ID,
+		RoomId:    w.RoomID,
+		Url:       w.URL,
+		Events:    w.Events,
+		CreatedBy: w.CreatedBy,
+		CreatedAt: w.CreatedAt.UnixMilli(),
+		Delivered: w.Delivered,
+		Pending:   w.Pending,
+		Dead:      w.Dead,
+	}
+}
+
+func chatDeliveryFrom(d StoredDelivery) *chat.WebhookDelivery {
+	delivery := &chat.WebhookDelivery{
+		Id:             d.ID,
+		WebhookId:      d.WebhookID,
+		Event:          d.Event,
+		Status:         d.Status,
+		Attempts:       int32(d.Attempts),
+		LastStatusCode: int32(d.LastStatusCode),
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt.UnixMilli(),
+		LastAttemptAt:  unixMilli(d.LastAttemptAt),
+		Payload:        string(d.Payload),
+	}
+	if d.Status == deliveryPending {
+		delivery.NextAttemptAt = d.NextAttemptAt.UnixMilli()
+	}
+	return delivery
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// newWebhookHub runs a test hub whose webhooks may reach local servers and
+// give up after three attempts.
+func newWebhookHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := newTestHub(t)
+	hub.webhooks = newWebhookDispatcher(hub.messages.(WebhookStore), newWebhookClient(true), 3, time.Millisecond)
+	hub.incomingLimits = newRateLimiter(60, incomingBurst)
+	return hub
+}
+
+// queueTestDelivery registers a webhook for url and stores one pending
+// delivery to it.
+func queueTestDelivery(t *testing.T, hub *Hub, url string) (*chat.Webhook, *StoredDelivery) {
+	t.Helper()
+	ctx := context.Background()
+	room := createTestRoom(t, hub, "alice")
+	w, err := hub.createWebhook(ctx, "alice", &chat.CreateWebhookRequest{RoomId: room.ID, Url: url})
+	if err != nil {
+		t.Fatalf("createWebhook: %v", err)
+	}
+	now := time.Now()
+	deliveries := []StoredDelivery{{
+		WebhookID:     w.Id,
+		Event:         "message",
+		Payload:       []byte(`{"event":"message"}`),
+		Status:        deliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}}
+	if err := hub.webhooks.store.QueueDeliveries(ctx, deliveries); err != nil {
+		t.Fatalf("QueueDeliveries: %v", err)
+	}
+	return w, &deliveries[0]
+}
+
+// attemptDelivery attempts a delivery once and returns it as stored.
+func attemptDelivery(t *testing.T, hub *Hub, w *chat.Webhook, delivery *StoredDelivery) *StoredDelivery {
+	t.Helper()
+	ctx := context.Background()
+	stored, err := hub.webhooks.store.GetWebhook(ctx, w.Id)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	hub.webhooks.attempt(ctx, stored, delivery)
+	updated, err := hub.webhooks.store.GetDelivery(ctx, delivery.ID)
+	if err != nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	return updated
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	hub := newWebhookHub(t)
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.webhooks.run(ctx)
+
+	room := createTestRoom(t, hub, "alice")
+	w, err := hub.createWebhook(ctx, "alice", &chat.CreateWebhookRequest{RoomId: room.ID, Url: srv.URL, Events: []string{"message"}})
+	if err != nil {
+		t.Fatalf("createWebhook: %v", err)
+	}
+	sendTestMessage(t, hub, room, "alice", "deploy done")
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook was not called")
+	}
+	body := <-bodies
+	if got, want := r.Header.Get("X-Chat-Signature"), signPayload(w.Secret, body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if signPayload("another secret", body) == r.Header.Get("X-Chat-Signature") {
+		t.Error("the signature does not depend on the secret")
+	}
+	if r.Header.Get("X-Chat-Event") != "message" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v, want a JSON message event", r.Header)
+	}
+	if !strings.Contains(string(body), "deploy done") {
+		t.Errorf("payload = %s, want the message", body)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	hub := newWebhookHub(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	w, delivery := queueTestDelivery(t, hub, srv.URL)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		got := attemptDelivery(t, hub, w, delivery)
+		if got.Status != deliveryPending || got.Attempts != attempt || got.LastStatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("after attempt %d: %+v, want pending with a 503", attempt, got)
+		}
+		if !got.NextAttemptAt.After(before) {
+			t.Fatalf("next attempt at %v, want after %v", got.NextAttemptAt, before)
+		}
+	}
+	if got := attemptDelivery(t, hub, w, delivery); got.Status != deliveryDelivered || got.Attempts != 3 || got.LastError != "" {
+		t.Fatalf("after the last attempt: %+v, want delivered", got)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	hub := newWebhookHub(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	w, delivery := queueTestDelivery(t, hub, srv.URL)
+
+	var got *StoredDelivery
+	for range hub.webhooks.maxAttempts {
+		got = attemptDelivery(t, hub, w, delivery)
+	}
+	if got.Status != deliveryDead || got.Attempts != hub.webhooks.maxAttempts || got.LastError == "" {
+		t.Fatalf("delivery = %+v, want dead after %d attempts", got, hub.webhooks.maxAttempts)
+	}
+	due, err := hub.webhooks.store.DueDeliveries(context.Background(), time.Now().Add(maxRetryDelay), 10)
+	if err != nil {
+		t.Fatalf("DueDeliveries: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("due = %v, want the dead delivery left alone", due)
+	}
+	if calls.Load() != int32(hub.webhooks.maxAttempts) {
+		t.Fatalf("calls = %d, want %d", calls.Load(), hub.webhooks.maxAttempts)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := newWebhookDispatcher(nil, nil, 5, time.Second)
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{13, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if delay := d.backoff(tt.attempts); delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempts, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	resp, err := newWebhookClient(false).Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("posted to a loopback address")
+	}
+	if !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("Post = %v, want the address refused", err)
+	}
+
+	resp, err = newWebhookClient(true).Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post with private addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
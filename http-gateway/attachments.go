@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadChunkSize is the size of the chunks an upload is streamed to the
+// chat service in.
+const uploadChunkSize = 32 << 10
+
+// uploadAttachmentHandler streams the "file" field of a multipart form to
+// the chat service as an attachment of ?room_id=, to be sent with a message.
+func uploadAttachmentHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		roomID := r.URL.Query().Get("room_id")
+		if roomID == "" {
+			http.Error(w, "Missing room_id", http.StatusBadRequest)
+			return
+		}
+
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var part io.Reader
+		var filename string
+		for {
+			p, err := reader.NextPart()
+			if err != nil {
+				http.Error(w, "Missing file", http.StatusBadRequest)
+				return
+			}
+			if p.FormName() == "file" {
+				part, filename = p, p.FileName()
+				break
+			}
+		}
+
+		stream, err := chatClient.UploadAttachment(userContext(r.Context(), r))
+		if err != nil {
+			log.Printf("gRPC error: %v", err)
+			http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+			return
+		}
+		info := &chat.AttachmentInfo{RoomId: roomID, Filename: filename}
+		if err := stream.Send(&chat.UploadAttachmentRequest{Part: &chat.UploadAttachmentRequest_Info{Info: info}}); err != nil {
+			writeUploadError(w, stream, err)
+			return
+		}
+
+		buf := make([]byte, uploadChunkSize)
+		for {
+			n, err := part.Read(buf)
+			if n > 0 {
+				chunk := &chat.UploadAttachmentRequest_Chunk{Chunk: buf[:n]}
+				if err := stream.Send(&chat.UploadAttachmentRequest{Part: chunk}); err != nil {
+					writeUploadError(w, stream, err)
+					return
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		attachment, err := stream.CloseAndRecv()
+		if err != nil {
+			writeUploadError(w, stream, err)
+			return
+		}
+		writeProto(w, attachment)
+	})
+}
+
+// writeUploadError reports a failed upload. A failed Send only returns
+// io.EOF; the chat service's reason comes from CloseAndRecv.
+func writeUploadError(w http.ResponseWriter, stream chat.ChatService_UploadAttachmentClient, err error) {
+	if errors.Is(err, io.EOF) {
+		_, err = stream.CloseAndRecv()
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		http.Error(w, status.Convert(err).Message(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	writeChatError(w, err, "Failed to upload file")
+}
+
+// downloadAttachmentHandler serves an attachment, or with ?thumbnail=true
+// its thumbnail. Images are shown inline, everything else is downloaded;
+// neither may run scripts.
+func downloadAttachmentHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &chat.DownloadAttachmentRequest{
+			Id:        query.Get("id"),
+			Thumbnail: query.Get("thumbnail") == "true",
+		}
+		if req.Id == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+
+		stream, err := chatClient.DownloadAttachment(userContext(r.Context(), r), req)
+		if err != nil {
+			writeChatError(w, err, "Failed to download file")
+			return
+		}
+		first, err := stream.Recv()
+		if err != nil {
+			writeChatError(w, err, "Failed to download file")
+			return
+		}
+		info := first.GetInfo()
+		if info == nil {
+			log.Printf("Attachment %s: stream did not start with its info", req.Id)
+			http.Error(w, "Failed to download file", http.StatusInternalServerError)
+			return
+		}
+
+		disposition := "attachment"
+		if strings.HasPrefix(info.ContentType, "image/") {
+			disposition = "inline"
+		}
+		header := w.Header()
+		header.Set("Content-Type", info.ContentType)
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Filename}))
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		header.Set("Cache-Control", "private, max-age=3600")
+		if !req.Thumbnail {
+			header.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		}
+
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				// Headers are sent; all we can do is cut the response short
+				log.Printf("gRPC error: %v", err)
+				return
+			}
+			if _, err := w.Write(resp.GetChunk()); err != nil {
+				return
+			}
+		}
+	})
+}
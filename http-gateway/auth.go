@@ -27,7 +27,7 @@ func loginHandler(client proto.AuthServiceClient) http.HandlerFunc {
 		session, _ := store.Get(r, "session-name")
 		session.Values["authenticated"] = true
 		session.Values["username"] = username
-		session.Values["userID"] = "1"
+		session.Values["userID"] = username
 		session.Save(r, w)
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 	}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// Bots connect to the chat service's BotService over gRPC with an API key.
+// These endpoints let users manage their bots and keys.
+
+// listBotsHandler lists the user's bots.
+func listBotsHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := chatClient.ListBots(userContext(r.Context(), r), &chat.ListBotsRequest{})
+		if err != nil {
+			writeChatError(w, err, "Failed to list bots")
+			return
+		}
+		writeProtoList(w, resp.Bots)
+	})
+}
+
+// createBotHandler registers a bot. The response is the only one that
+// includes its API key.
+func createBotHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req BotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		bot, err := chatClient.CreateBot(userContext(r.Context(), r), &chat.CreateBotRequest{Name: req.Name})
+		if err != nil {
+			writeChatError(w, err, "Failed to create bot")
+			return
+		}
+		writeProto(w, bot)
+	})
+}
+
+// deleteBotHandler removes one of the user's bots, disconnecting it.
+func deleteBotHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req BotActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		_, err := chatClient.DeleteBot(userContext(r.Context(), r), &chat.DeleteBotRequest{Id: req.ID})
+		if err != nil {
+			writeChatError(w, err, "Failed to delete bot")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Messages starting with "/" are commands, such as "/me waves" or
+// "/kick alice spamming", which the gateway runs instead of sending them.
+// A message starting with "//" is sent with the first slash removed.
+// Commands are registered from Go code with registerCommand; the built-in
+// ones are in commands_builtin.go.
+
+// command is a slash command.
+type command struct {
+	name  string
+	usage string // the arguments, as shown by /help
+	help  string
+	// minArgs is how many arguments the command needs.
+	minArgs int
+	// moderator commands can only be used by the moderators of the room
+	// they are sent in.
+	moderator bool
+	run       func(call *commandCall) error
+}
+
+// commandCall is one use of a command, sent in a room.
+type commandCall struct {
+	ctx    context.Context
+	client *Client
+	req    *chat.ChatRequest // the message the command was sent as
+	args   []string
+	// text is everything after the command's name, for commands that
+	// take free text.
+	text string
+}
+
+var commands = make(map[string]*command)
+
+// registerCommand makes a command available to every client. It must be
+// called before the gateway serves connections, e.g. from an init function.
+func registerCommand(cmd *command) {
+	if _, ok := commands[cmd.name]; ok {
+		panic("command /" + cmd.name + " registered twice")
+	}
+	commands[cmd.name] = cmd
+}
+
+// sortedCommands returns the registered commands by name.
+func sortedCommands() []*command {
+	sorted := make([]*command, 0, len(commands))
+	for _, cmd := range commands {
+		sorted = append(sorted, cmd)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	return sorted
+}
+
+// commandFrame is a response to a command that only the caller sees.
+type commandFrame struct {
+	Type    string `json:"type"`
+	RoomID  string `json:"room_id"`
+	Content string `json:"content"`
+	Error   bool   `json:"error"`
+}
+
+// runCommand runs the command in a message, replying with an error if it
+// fails.
+func (c *Client) runCommand(req *chat.ChatRequest) {
+	name, text := strings.TrimPrefix(req.Content, "/"), ""
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, text = name[:i], strings.TrimSpace(name[i:])
+	}
+	name = strings.ToLower(name)
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.replyCommand(req.RoomId, true, "Unknown command /%s. Type /help for a list of commands.", name)
+		return
+	}
+	args, err := parseArgs(text)
+	if err != nil {
+		c.replyCommand(req.RoomId, true, "%s", err.Error())
+		return
+	}
+	if len(args) < cmd.minArgs {
+		c.replyCommand(req.RoomId, true, "Usage: /%s %s", cmd.name, cmd.usage)
+		return
+	}
+	if cmd.moderator && !c.moderates(req.RoomId) {
+		c.replyCommand(req.RoomId, true, "Only moderators can use /%s.", cmd.name)
+		return
+	}
+
+	call := &commandCall{ctx: c.rpcContext(), client: c, req: req, args: args, text: text}
+	if err := cmd.run(call); err != nil {
+		st, ok := status.FromError(err)
+		switch {
+		case !ok:
+			c.replyCommand(req.RoomId, true, "%s", err.Error())
+		case st.Code() == codes.InvalidArgument, st.Code() == codes.NotFound, st.Code() == codes.PermissionDenied,
+			st.Code() == codes.AlreadyExists, st.Code() == codes.FailedPrecondition, st.Code() == codes.ResourceExhausted:
+			c.replyCommand(req.RoomId, true, "%s", st.Message())
+		default:
+			log.Printf("gRPC error: %v", err)
+			c.replyCommand(req.RoomId, true, "/%s failed", cmd.name)
+		}
+	}
+}
+
+// reply sends a response only the caller sees.
+func (call *commandCall) reply(format string, a ...any) {
+	call.client.replyCommand(call.req.RoomId, false, format, a...)
+}
+
+// sendMessage sends content to the command's room like a message typed
+// there, in the same thread and with the same attachments.
+func (call *commandCall) sendMessage(content string, emote bool) error {
+	return call.client.stream.Send(&chat.ChatRequest{
+		Action:        "message",
+		RoomId:        call.req.RoomId,
+		Content:       content,
+		ParentId:      call.req.ParentId,
+		AttachmentIds: call.req.AttachmentIds,
+		Emote:         emote,
+	})
+}
+
+func (c *Client) replyCommand(roomID string, isError bool, format string, a ...any) {
+	frame, err := json.Marshal(commandFrame{
+		Type:    "command",
+		RoomID:  roomID,
+		Content: fmt.Sprintf(format, a...),
+		Error:   isError,
+	})
+	if err != nil {
+		log.Printf("Error marshaling command response: %v", err)
+		return
+	}
+	select {
+	case c.replies <- frame:
+	default:
+		log.Printf("Dropping command response for slow client %q", c.userID)
+	}
+}
+
+// parseArgs splits a command's text into arguments at spaces. Double
+// quotes group words into one argument.
+func parseArgs(text string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// rpcContext is the context for chat service calls on the client's behalf,
+// naming the user as they currently go by.
+func (c *Client) rpcContext() context.Context {
+	ctx := c.stream.Context()
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set("x-username", c.name())
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func (c *Client) name() string {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.username
+}
+
+// moderates reports whether the user moderates a room the client joined.
+func (c *Client) moderates(roomID string) bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	room, ok := c.rooms[roomID]
+	return ok && room.moderator
+}
+
+// topic returns the topic of a room the client joined.
+func (c *Client) topic(roomID string) (topic string, joined bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	room, ok := c.rooms[roomID]
+	if !ok {
+		return "", false
+	}
+	return room.topic, true
+}
+
+// trackEvent updates the state commands need from an event on the stream.
+func (c *Client) trackEvent(event *chat.ChatEvent) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	switch e := event.Event.(type) {
+	case *chat.ChatEvent_Joined:
+		c.rooms[event.RoomId] = &roomState{moderator: e.Joined.Moderator, topic: e.Joined.Room.GetTopic()}
+	case *chat.ChatEvent_Left, *chat.ChatEvent_RoomClosed:
+		delete(c.rooms, event.RoomId)
+	case *chat.ChatEvent_Topic:
+		if room, ok := c.rooms[event.RoomId]; ok {
+			room.topic = e.Topic.Topic
+		}
+	case *chat.ChatEvent_Nick:
+		// Without a room it confirms this stream's own rename
+		if event.RoomId == "" {
+			c.username = e.Nick.NewName
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go-grpc-basic/proto/chat"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "help",
+		usage: "[command]",
+		help:  "Lists the commands, or explains one.",
+		run:   helpCommand,
+	})
+	registerCommand(&command{
+		name:    "me",
+		usage:   "<action>",
+		help:    "Describes what you are doing, e.g. /me waves.",
+		minArgs: 1,
+		run: func(call *commandCall) error {
+			return call.sendMessage(call.text, true)
+		},
+	})
+	registerCommand(&command{
+		name:  "topic",
+		usage: "[topic | -]",
+		help:  "Shows the room's topic. Moderators can set it, or clear it with -.",
+		run:   topicCommand,
+	})
+	registerCommand(&command{
+		name:    "nick",
+		usage:   "<name>",
+		help:    "Changes the name you are shown with until you reconnect.",
+		minArgs: 1,
+		run: func(call *commandCall) error {
+			return call.client.stream.Send(&chat.ChatRequest{Action: "nick", Content: call.args[0]})
+		},
+	})
+	registerCommand(&command{
+		name:    "invite",
+		usage:   "<user>",
+		help:    "Invites a user to the room. They can join it without its password.",
+		minArgs: 1,
+		run: func(call *commandCall) error {
+			userID := call.args[0]
+			_, err := call.client.chatClient.InviteToRoom(call.ctx, &chat.InviteToRoomRequest{
+				RoomId: call.req.RoomId,
+				UserId: userID,
+			})
+			if err != nil {
+				return err
+			}
+			call.reply("Invited %s.", userID)
+			return nil
+		},
+	})
+	registerCommand(&command{
+		name:      "kick",
+		usage:     "<user> [reason]",
+		help:      "Removes a user from the room.",
+		minArgs:   1,
+		moderator: true,
+		run: func(call *commandCall) error {
+			_, err := call.client.chatClient.KickFromRoom(call.ctx, &chat.KickFromRoomRequest{
+				RoomId: call.req.RoomId,
+				UserId: call.args[0],
+				Reason: strings.Join(call.args[1:], " "),
+			})
+			return err
+		},
+	})
+	registerCommand(&command{
+		name:  "shrug",
+		usage: "[message]",
+		help:  `Sends a message followed by ¯\_(ツ)_/¯.`,
+		run: func(call *commandCall) error {
+			return call.sendMessage(strings.TrimSpace(call.text+` ¯\_(ツ)_/¯`), false)
+		},
+	})
+}
+
+func helpCommand(call *commandCall) error {
+	if len(call.args) > 0 {
+		name := strings.ToLower(strings.TrimPrefix(call.args[0], "/"))
+		cmd, ok := commands[name]
+		if !ok {
+			return fmt.Errorf("unknown command /%s", name)
+		}
+		call.reply("/%s %s\n%s", cmd.name, cmd.usage, cmd.help)
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range sortedCommands() {
+		fmt.Fprintf(&b, "\n/%s %s - %s", cmd.name, cmd.usage, cmd.help)
+	}
+	b.WriteString("\nStart a message with // to send it with a single /.")
+	call.reply("%s", b.String())
+	return nil
+}
+
+func topicCommand(call *commandCall) error {
+	if call.text == "" {
+		topic, joined := call.client.topic(call.req.RoomId)
+		switch {
+		case !joined:
+			return fmt.Errorf("join the room first")
+		case topic == "":
+			call.reply("No topic is set.")
+		default:
+			call.reply("The topic is: %s", topic)
+		}
+		return nil
+	}
+
+	topic := call.text
+	if topic == "-" {
+		topic = ""
+	}
+	_, err := call.client.chatClient.SetRoomTopic(call.ctx, &chat.SetRoomTopicRequest{
+		RoomId: call.req.RoomId,
+		Topic:  topic,
+	})
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// conversationsHandler lists the user's direct conversations with their
+// unread counts, most recently active first.
+func conversationsHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := chatClient.ListConversations(userContext(r.Context(), r), &chat.ListConversationsRequest{})
+		if err != nil {
+			writeChatError(w, err, "Failed to list conversations")
+			return
+		}
+		writeProtoList(w, resp.Conversations)
+	})
+}
+
+// openConversationHandler returns the conversation with the given users,
+// creating it if needed. Its ID is used like a room ID from then on.
+func openConversationHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ConversationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		room, err := chatClient.OpenConversation(userContext(r.Context(), r), &chat.OpenConversationRequest{UserIds: req.UserIDs})
+		if err != nil {
+			writeChatError(w, err, "Failed to open conversation")
+			return
+		}
+		writeProto(w, room)
+	})
+}
+
+// markReadHandler moves the user's read marker in a room or conversation
+// and returns the remaining unread count.
+func markReadHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := chatClient.MarkRead(userContext(r.Context(), r), &chat.MarkReadRequest{
+			RoomId:    req.RoomID,
+			MessageId: req.MessageID,
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to mark read")
+			return
+		}
+		writeProto(w, resp)
+	})
+}
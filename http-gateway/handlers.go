@@ -1,6 +1,17 @@
 package main
 
-import "net/http"
+import (
+	"encoding/json"
+	"go-grpc-basic/proto/presence"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
@@ -24,3 +35,246 @@ func loginPage(w http.ResponseWriter, r *http.Request) {
 		Title: "Login Page",
 	})
 }
+
+var statusNames = map[presence.PresenceStatus]string{
+	presence.PresenceStatus_PRESENCE_STATUS_UNKNOWN: "unknown",
+	presence.PresenceStatus_PRESENCE_STATUS_ONLINE:  "online",
+	presence.PresenceStatus_PRESENCE_STATUS_OFFLINE: "offline",
+}
+
+// presenceHandler lists presence for the requested users. Users can be given
+// as repeated userIDs parameters or a comma separated user_ids parameter;
+// with neither, every user sharing their online status with the caller is
+// listed. Results can be filtered with online_only, status and
+// updated_since (unix seconds) and paged with limit and cursor.
+func presenceHandler(client presence.PresenceServiceClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session-name")
+		query := r.URL.Query()
+
+		req := &presence.ListPresenceRequest{
+			UserIds:    query["userIDs"],
+			ViewerId:   session.Values["userID"].(string),
+			OnlineOnly: query.Get("online_only") == "true",
+			Cursor:     query.Get("cursor"),
+		}
+		for _, ids := range query["user_ids"] {
+			for _, id := range strings.Split(ids, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					req.UserIds = append(req.UserIds, id)
+				}
+			}
+		}
+
+		if s := query.Get("status"); s != "" {
+			found := false
+			for st, name := range statusNames {
+				if name == s {
+					req.Status, found = st.Enum(), true
+				}
+			}
+			if !found {
+				http.Error(w, "Status must be one of online, offline, unknown", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := query.Get("updated_since"); s != "" {
+			since, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid updated_since", http.StatusBadRequest)
+				return
+			}
+			req.UpdatedSince = since
+		}
+		if s := query.Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			req.PageSize = int32(limit)
+		}
+
+		resp, err := client.ListPresence(r.Context(), req)
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("gRPC error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		result := PresenceListResponse{
+			Presences:  make([]PresenceEntry, 0, len(resp.GetPresences())),
+			NextCursor: resp.GetNextCursor(),
+		}
+		for _, p := range resp.GetPresences() {
+			result.Presences = append(result.Presences, PresenceEntry{
+				UserID:            p.UserId,
+				Status:            statusNames[p.Status],
+				Online:            p.Online,
+				LastActive:        p.LastActive,
+				ActiveConnections: p.ActiveConnections,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// presenceHistoryHandler returns online sessions and aggregated activity for
+// the comma separated user_ids (default: the caller) over the last days days
+// (default 7). tz_offset is the caller's UTC offset in minutes and sets the
+// day and hour boundaries.
+func presenceHistoryHandler(client presence.PresenceServiceClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session-name")
+		userID := session.Values["userID"].(string)
+		query := r.URL.Query()
+
+		req := &presence.GetPresenceHistoryRequest{ViewerId: userID}
+		for _, id := range strings.Split(query.Get("user_ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				req.UserIds = append(req.UserIds, id)
+			}
+		}
+		if len(req.UserIds) == 0 {
+			req.UserIds = []string{userID}
+		}
+
+		days := 7
+		if s := query.Get("days"); s != "" {
+			d, err := strconv.Atoi(s)
+			if err != nil || d < 1 {
+				http.Error(w, "Invalid days", http.StatusBadRequest)
+				return
+			}
+			days = d
+		}
+		req.To = time.Now().Unix()
+		req.From = req.To - int64(days)*24*60*60
+
+		if s := query.Get("tz_offset"); s != "" {
+			offset, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "Invalid tz_offset", http.StatusBadRequest)
+				return
+			}
+			req.UtcOffsetMinutes = int32(offset)
+		}
+
+		resp, err := client.GetPresenceHistory(r.Context(), req)
+		if err != nil {
+			log.Printf("gRPC error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		histories := make([]UserHistory, 0, len(resp.GetHistories()))
+		for _, h := range resp.GetHistories() {
+			history := UserHistory{
+				UserID:        h.UserId,
+				Sessions:      make([]HistorySession, 0, len(h.Sessions)),
+				Intervals:     make([]HistoryInterval, 0, len(h.Intervals)),
+				Daily:         make([]DailyActivity, 0, len(h.Daily)),
+				HourlySeconds: h.HourlySeconds,
+			}
+			for _, s := range h.Sessions {
+				history.Sessions = append(history.Sessions, HistorySession{
+					SessionID: s.SessionId,
+					RoomID:    s.RoomId,
+					Start:     s.Start,
+					End:       s.End,
+					Ongoing:   s.Ongoing,
+				})
+			}
+			for _, i := range h.Intervals {
+				history.Intervals = append(history.Intervals, HistoryInterval{Start: i.Start, End: i.End})
+			}
+			for _, d := range h.Daily {
+				history.Daily = append(history.Daily, DailyActivity{Date: d.Date, OnlineSeconds: d.OnlineSeconds})
+			}
+			histories = append(histories, history)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"histories": histories})
+	}
+}
+
+var visibilityNames = map[presence.Visibility]string{
+	presence.Visibility_VISIBILITY_EVERYONE: "everyone",
+	presence.Visibility_VISIBILITY_CONTACTS: "contacts",
+	presence.Visibility_VISIBILITY_NOBODY:   "nobody",
+}
+
+func parseVisibility(name string) (presence.Visibility, bool) {
+	for v, n := range visibilityNames {
+		if n == name {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func privacyHandler(client presence.PresenceServiceClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session-name")
+		userID := session.Values["userID"].(string)
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req PrivacyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+
+			onlineStatus, ok := parseVisibility(req.OnlineStatus)
+			lastSeen, ok2 := parseVisibility(req.LastSeen)
+			if !ok || !ok2 {
+				http.Error(w, "Visibility must be one of everyone, contacts, nobody", http.StatusBadRequest)
+				return
+			}
+
+			_, err := client.SetPrivacy(r.Context(), &presence.SetPrivacyRequest{
+				Settings: &presence.PrivacySettings{
+					UserId:       userID,
+					OnlineStatus: onlineStatus,
+					LastSeen:     lastSeen,
+					Contacts:     req.Contacts,
+				},
+			})
+			if err != nil {
+				log.Printf("gRPC error: %v", err)
+				http.Error(w, "Failed to update privacy settings", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		settings, err := client.GetPrivacy(r.Context(), &presence.GetPrivacyRequest{UserId: userID})
+		if err != nil {
+			log.Printf("gRPC error: %v", err)
+			http.Error(w, "Failed to load privacy settings", http.StatusInternalServerError)
+			return
+		}
+
+		contacts := settings.GetContacts()
+		if contacts == nil {
+			contacts = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PrivacyRequest{
+			OnlineStatus: visibilityNames[settings.GetOnlineStatus()],
+			LastSeen:     visibilityNames[settings.GetLastSeen()],
+			Contacts:     contacts,
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxIncomingBody caps what an incoming webhook may post, attachments
+// included, below the chat service's 4 MiB gRPC message limit.
+const maxIncomingBody = 3 << 20
+
+// roomIncomingWebhooksHandler lists the incoming webhooks of ?room_id=.
+func roomIncomingWebhooksHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		roomID := r.URL.Query().Get("room_id")
+		if roomID == "" {
+			http.Error(w, "Missing room_id", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := chatClient.ListIncomingWebhooks(userContext(r.Context(), r), &chat.ListIncomingWebhooksRequest{RoomId: roomID})
+		if err != nil {
+			writeChatError(w, err, "Failed to list webhooks")
+			return
+		}
+		writeProtoList(w, resp.Webhooks)
+	})
+}
+
+// createIncomingWebhookHandler makes an incoming webhook. The response is
+// the only one that includes its token, along with the URL to post to.
+func createIncomingWebhookHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req IncomingWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		webhook, err := chatClient.CreateIncomingWebhook(userContext(r.Context(), r), &chat.CreateIncomingWebhookRequest{
+			RoomId: req.RoomID,
+			Name:   req.Name,
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to create webhook")
+			return
+		}
+
+		// The chat service does not know where the gateway is reached
+		data, err := protoJSON.Marshal(webhook)
+		var created map[string]any
+		if err == nil {
+			err = json.Unmarshal(data, &created)
+		}
+		if err != nil {
+			log.Printf("Error marshaling response: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		created["url"] = fmt.Sprintf("%s://%s/hooks/incoming?id=%s&token=%s", scheme, r.Host, webhook.Id, webhook.Token)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(created)
+	})
+}
+
+func deleteIncomingWebhookHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req WebhookActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		_, err := chatClient.DeleteIncomingWebhook(userContext(r.Context(), r), &chat.DeleteIncomingWebhookRequest{Id: req.ID})
+		if err != nil {
+			writeChatError(w, err, "Failed to delete webhook")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// incomingWebhookHandler posts an IncomingMessage to the room of the
+// incoming webhook ?id=, authenticated by its ?token= instead of a session.
+func incomingWebhookHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+
+		var msg IncomingMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncomingBody)).Decode(&msg); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req := &chat.PostWebhookMessageRequest{
+			WebhookId: query.Get("id"),
+			Token:     query.Get("token"),
+			Text:      msg.Text,
+			Username:  msg.Username,
+		}
+		for _, a := range msg.Attachments {
+			req.Attachments = append(req.Attachments, &chat.WebhookFile{Filename: a.Filename, Data: a.Data})
+		}
+
+		posted, err := chatClient.PostWebhookMessage(r.Context(), req)
+		if err != nil {
+			writeIncomingError(w, err)
+			return
+		}
+		writeProto(w, posted)
+	}
+}
+
+// writeIncomingError reports a failed webhook post. Rate limits come with
+// RetryInfo; other exhausted resources are files that are too large.
+func writeIncomingError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unauthenticated:
+		http.Error(w, st.Message(), http.StatusUnauthorized)
+		return
+	case codes.ResourceExhausted:
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(info.RetryDelay.AsDuration().Seconds())))
+				http.Error(w, st.Message(), http.StatusTooManyRequests)
+				return
+			}
+		}
+		http.Error(w, st.Message(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	writeChatError(w, err, "Failed to post message")
+}
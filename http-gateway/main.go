@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"go-grpc-basic/proto"
+	"go-grpc-basic/proto/chat"
 	"go-grpc-basic/proto/presence"
 
 	"google.golang.org/grpc"
@@ -36,6 +37,24 @@ func main() {
 		presence_port = "50052"
 	}
 
+	chat_host, found := os.LookupEnv("CHAT_HOST")
+	if !found {
+		chat_host = "localhost"
+	}
+
+	chat_port, found := os.LookupEnv("CHAT_PORT")
+	if !found {
+		chat_port = "50054"
+	}
+
+	unfurl_links := true
+	if value, found := os.LookupEnv("UNFURL_LINKS"); found {
+		unfurl_links = value != "false"
+	}
+
+	// Lets the unfurler fetch from local servers, for testing only
+	unfurl_allow_private := os.Getenv("UNFURL_ALLOW_PRIVATE") == "true"
+
 	auth_conn, err := grpc.Dial(strings.Join([]string{auth_host, auth_port}, ":"), grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
@@ -48,17 +67,31 @@ func main() {
 	}
 	defer presence_conn.Close()
 
+	chat_options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if gateway_secret, found := os.LookupEnv("GATEWAY_SECRET"); found {
+		chat_options = append(chat_options, grpc.WithPerRPCCredentials(gatewayCredentials{secret: gateway_secret}))
+	}
+
+	chat_conn, err := grpc.Dial(strings.Join([]string{chat_host, chat_port}, ":"), chat_options...)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer chat_conn.Close()
+
 	auth_client := proto.NewAuthServiceClient(auth_conn)
 	presence_client := presence.NewPresenceServiceClient(presence_conn)
+	chat_client := chat.NewChatServiceClient(chat_conn)
 
-	hub := newHub()
-	go hub.run()
+	var link_unfurler *unfurler
+	if unfurl_links {
+		link_unfurler = newUnfurler(newUnfurlClient(unfurl_allow_private), chat_client)
+	}
 
 	cwd, _ := os.Getwd()
 	staticPath := filepath.Join(cwd, "static")
 	log.Println("Serving static files from:", staticPath)
 
-	initRoutes(hub, auth_client, presence_client, staticPath)
+	initRoutes(chat_client, auth_client, presence_client, link_unfurler, staticPath)
 
 	log.Println("HTTP gateway running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// mentionsHandler is the user's inbox of messages that mentioned them,
+// newest first. ?unread=true leaves out mentions the user has read past.
+func mentionsHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &chat.ListMentionsRequest{UnreadOnly: query.Get("unread") == "true"}
+
+		if s := query.Get("before"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id < 1 {
+				http.Error(w, "Invalid before", http.StatusBadRequest)
+				return
+			}
+			req.BeforeId = id
+		}
+
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			req.Limit = int32(min(l, 1<<30))
+		}
+
+		resp, err := chatClient.ListMentions(userContext(r.Context(), r), req)
+		if err != nil {
+			writeChatError(w, err, "Failed to load mentions")
+			return
+		}
+		writeProto(w, resp)
+	})
+}
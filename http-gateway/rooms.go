@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"go-grpc-basic/proto/chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// protoJSON renders chat service messages with their proto field names,
+// which is the JSON shape the chat page expects. Zero values are kept so
+// every field is present.
+var protoJSON = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// gatewayCredentials proves to the chat service that calls come from the
+// gateway, which it trusts to name the acting user.
+type gatewayCredentials struct {
+	secret string
+}
+
+func (c gatewayCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"x-gateway-secret": c.secret}, nil
+}
+
+// RequireTransportSecurity is false because the services talk over the
+// compose network without TLS.
+func (gatewayCredentials) RequireTransportSecurity() bool { return false }
+
+// userContext attaches the logged in user to ctx as the chat service
+// expects it.
+func userContext(ctx context.Context, r *http.Request) context.Context {
+	session, _ := store.Get(r, "session-name")
+	return metadata.AppendToOutgoingContext(ctx,
+		"x-user-id", session.Values["userID"].(string),
+		"x-username", session.Values["username"].(string),
+	)
+}
+
+// writeChatError maps a chat service error to an HTTP response.
+func writeChatError(w http.ResponseWriter, err error, fallback string) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		http.Error(w, st.Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, st.Message(), http.StatusNotFound)
+	case codes.PermissionDenied:
+		http.Error(w, st.Message(), http.StatusForbidden)
+	case codes.AlreadyExists, codes.FailedPrecondition, codes.ResourceExhausted:
+		http.Error(w, st.Message(), http.StatusConflict)
+	default:
+		log.Printf("gRPC error: %v", err)
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+func writeProto(w http.ResponseWriter, msg proto.Message) {
+	data, err := protoJSON.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling response: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeProtoList writes items as a JSON array.
+func writeProtoList[T proto.Message](w http.ResponseWriter, items []T) {
+	list := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		data, err := protoJSON.Marshal(item)
+		if err != nil {
+			log.Printf("Error marshaling response: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		list = append(list, data)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func createRoomHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		var req RoomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		room, err := chatClient.CreateRoom(userContext(r.Context(), r), &chat.CreateRoomRequest{
+			Name:       req.Name,
+			Password:   req.Password,
+			MaxMembers: int32(req.MaxMembers),
+			Ephemeral:  req.Ephemeral,
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to create room")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":   room.Id,
+			"name": room.Name,
+		})
+	})
+}
+
+// listRoomsHandler lists open rooms, or archived rooms with ?archived=true.
+func listRoomsHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := chatClient.ListRooms(userContext(r.Context(), r), &chat.ListRoomsRequest{
+			Archived: r.URL.Query().Get("archived") == "true",
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to list rooms")
+			return
+		}
+		writeProtoList(w, resp.Rooms)
+	})
+}
+
+// decodeRoomAction reads the RoomActionRequest of a POST endpoint, writing
+// an error response if there is none.
+func decodeRoomAction(w http.ResponseWriter, r *http.Request) (RoomActionRequest, bool) {
+	var req RoomActionRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// deleteRoomHandler removes a room and its history and disconnects its
+// members.
+func deleteRoomHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRoomAction(w, r)
+		if !ok {
+			return
+		}
+
+		_, err := chatClient.DeleteRoom(userContext(r.Context(), r), &chat.DeleteRoomRequest{RoomId: req.RoomID})
+		if err != nil {
+			writeChatError(w, err, "Failed to delete room")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// archiveRoomHandler archives (or with ?archived=false, restores) a room.
+func archiveRoomHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRoomAction(w, r)
+		if !ok {
+			return
+		}
+
+		_, err := chatClient.ArchiveRoom(userContext(r.Context(), r), &chat.ArchiveRoomRequest{
+			RoomId:   req.RoomID,
+			Archived: r.URL.Query().Get("archived") != "false",
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to archive room")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// roomMessagesHandler pages backwards through a room's history. Pass the
+// oldest message ID already seen as before to get the page preceding it.
+func roomMessagesHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &chat.ListMessagesRequest{RoomId: query.Get("room_id")}
+
+		if s := query.Get("before"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id < 1 {
+				http.Error(w, "Invalid before", http.StatusBadRequest)
+				return
+			}
+			req.BeforeId = id
+		}
+
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			req.Limit = int32(min(l, 1<<30))
+		}
+
+		page, err := chatClient.ListMessages(userContext(r.Context(), r), req)
+		if err != nil {
+			writeChatError(w, err, "Failed to load messages")
+			return
+		}
+		writeProto(w, page)
+	})
+}
+
+// messageEditsHandler returns the earlier versions of an edited message.
+func messageEditsHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := chatClient.ListMessageEdits(userContext(r.Context(), r), &chat.ListMessageEditsRequest{MessageId: id})
+		if err != nil {
+			writeChatError(w, err, "Failed to load edits")
+			return
+		}
+		writeProtoList(w, resp.Edits)
+	})
+}
+
+func threadHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		thread, err := chatClient.GetThread(userContext(r.Context(), r), &chat.GetThreadRequest{MessageId: id})
+		if err != nil {
+			writeChatError(w, err, "Failed to load thread")
+			return
+		}
+		writeProto(w, thread)
+	})
+}
+
+func roomMembersHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := chatClient.ListRoomMembers(userContext(r.Context(), r), &chat.ListRoomMembersRequest{
+			RoomId: r.URL.Query().Get("room_id"),
+		})
+		if err != nil {
+			writeChatError(w, err, "Presence unavailable")
+			return
+		}
+		writeProtoList(w, resp.Members)
+	})
+}
+
+func chatHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	renderTemplate(w, "chat", PageData{
+		Title: "Chat",
+		Data: struct{ Username string }{
+			Username: session.Values["username"].(string),
+		},
+	})
+}
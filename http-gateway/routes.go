@@ -1,23 +1,47 @@
 package main
 
 import (
-	"encoding/json"
 	"go-grpc-basic/proto"
+	"go-grpc-basic/proto/chat"
 	"go-grpc-basic/proto/presence"
 	"log"
 	"net/http"
 	"strings"
 )
 
-func initRoutes(hub *Hub, authClient proto.AuthServiceClient, presenceClient presence.PresenceServiceClient, staticPath string) {
+func initRoutes(chatClient chat.ChatServiceClient, authClient proto.AuthServiceClient, presenceClient presence.PresenceServiceClient, unfurler *unfurler, staticPath string) {
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticPath))))
 
-	http.HandleFunc("/rooms", listRoomsHandler(hub))
-	http.HandleFunc("/rooms/create", createRoomHandler(hub))
-	http.HandleFunc("/ws", websocketHandler(hub, presenceClient))
-	http.HandleFunc("/chat", chatHandler(hub))
+	http.HandleFunc("/rooms", listRoomsHandler(chatClient))
+	http.HandleFunc("/rooms/create", createRoomHandler(chatClient))
+	http.HandleFunc("/rooms/delete", deleteRoomHandler(chatClient))
+	http.HandleFunc("/rooms/archive", archiveRoomHandler(chatClient))
+	http.HandleFunc("/rooms/members", roomMembersHandler(chatClient))
+	http.HandleFunc("/rooms/messages", roomMessagesHandler(chatClient))
+	http.HandleFunc("/rooms/messages/edits", messageEditsHandler(chatClient))
+	http.HandleFunc("/rooms/messages/thread", threadHandler(chatClient))
+	http.HandleFunc("/rooms/read", markReadHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks", roomWebhooksHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks/create", createWebhookHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks/delete", deleteWebhookHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks/incoming", roomIncomingWebhooksHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks/incoming/create", createIncomingWebhookHandler(chatClient))
+	http.HandleFunc("/rooms/webhooks/incoming/delete", deleteIncomingWebhookHandler(chatClient))
+	http.HandleFunc("/bots", listBotsHandler(chatClient))
+	http.HandleFunc("/bots/create", createBotHandler(chatClient))
+	http.HandleFunc("/bots/delete", deleteBotHandler(chatClient))
+	http.HandleFunc("/admin/webhooks", allWebhooksHandler(chatClient))
+	http.HandleFunc("/admin/webhooks/deliveries", webhookDeliveriesHandler(chatClient))
+	http.HandleFunc("/admin/webhooks/retry", retryDeliveryHandler(chatClient))
+	http.HandleFunc("/attachments/upload", uploadAttachmentHandler(chatClient))
+	http.HandleFunc("/attachments/download", downloadAttachmentHandler(chatClient))
+	http.HandleFunc("/conversations", conversationsHandler(chatClient))
+	http.HandleFunc("/conversations/open", openConversationHandler(chatClient))
+	http.HandleFunc("/ws", websocketHandler(chatClient, unfurler))
+	http.HandleFunc("/chat", authMiddleware(chatHandler))
 
 	// Public routes
+	http.HandleFunc("/hooks/incoming", incomingWebhookHandler(chatClient))
 	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
 			loginPage(w, r)
@@ -60,25 +84,9 @@ func initRoutes(hub *Hub, authClient proto.AuthServiceClient, presenceClient pre
 		w.Write([]byte("Authenticated!"))
 	})
 
-	http.HandleFunc("/api/presence", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		userIDs := r.URL.Query()["userIDs"]
-		resp, err := presenceClient.GetPresence(r.Context(), &presence.GetPresenceRequest{UserIds: userIDs})
-		if err != nil {
-			log.Printf("gRPC error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		result := make(map[string]bool, len(resp.Presences))
-		for _, pres := range resp.GetPresences() {
-			result[pres.UserId] = pres.Online
-		}
-		json, err := json.Marshal(result)
-		if err != nil {
-			log.Printf("JSON error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		w.Write(json)
-	}))
+	http.HandleFunc("/api/presence", authMiddleware(presenceHandler(presenceClient)))
+	http.HandleFunc("/api/presence/privacy", authMiddleware(privacyHandler(presenceClient)))
+	http.HandleFunc("/api/presence/history", authMiddleware(presenceHistoryHandler(presenceClient)))
+	http.HandleFunc("/api/mentions", mentionsHandler(chatClient))
+	http.HandleFunc("/api/search", searchHandler(chatClient))
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// searchHandler searches the messages of every room the user can read.
+// q holds words and "quoted phrases"; room, from (the author), after and
+// before (dates or RFC 3339 times) narrow the search, and offset and limit
+// page through the results.
+func searchHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &chat.SearchRequest{
+			Query:  query.Get("q"),
+			RoomId: query.Get("room"),
+			UserId: query.Get("from"),
+		}
+
+		for param, dest := range map[string]*int64{"after": &req.After, "before": &req.Before} {
+			if s := query.Get(param); s != "" {
+				t, err := parseSearchTime(s)
+				if err != nil {
+					http.Error(w, "Invalid "+param, http.StatusBadRequest)
+					return
+				}
+				*dest = t.UnixMilli()
+			}
+		}
+
+		for param, dest := range map[string]*int32{"offset": &req.Offset, "limit": &req.Limit} {
+			if s := query.Get(param); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					http.Error(w, "Invalid "+param, http.StatusBadRequest)
+					return
+				}
+				*dest = int32(min(n, 1<<30))
+			}
+		}
+
+		resp, err := chatClient.SearchMessages(userContext(r.Context(), r), req)
+		if err != nil {
+			writeChatError(w, err, "Search failed")
+			return
+		}
+		writeProto(w, resp)
+	})
+}
+
+// parseSearchTime accepts a date, taken as midnight UTC, or an RFC 3339
+// time.
+func parseSearchTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -3,56 +3,138 @@ package main
 import (
 	"html/template"
 	"sync"
-	"time"
+
+	"go-grpc-basic/proto/chat"
 
 	"github.com/gorilla/websocket"
 )
 
-type Room struct {
-	ID         string
-	Name       string
-	Password   string
-	MaxMembers int
-	Members    map[*Client]bool
-	CreatedBy  string
-	CreatedAt  time.Time
-}
-
+// Client bridges one WebSocket connection to a Chat stream.
 type Client struct {
-	conn        *websocket.Conn
-	send        chan []byte
-	username    string
-	currentRoom *Room
-}
-
-type ChatMessage struct {
-	Username string `json:"username"`
-	Message  string `json:"message"`
-	Time     int64  `json:"time"`
-}
+	conn       *websocket.Conn
+	send       chan []byte
+	replies    chan []byte // command responses; never closed
+	stream     chat.ChatService_ChatClient
+	chatClient chat.ChatServiceClient
+	userID     string
+	unfurler   *unfurler // nil if links are not unfurled
 
-type BroadcastMessage struct {
-	RoomID  string
-	Message []byte
+	// The state commands need, as seen on the stream
+	stateMu  sync.Mutex
+	username string                // the name the user goes by
+	rooms    map[string]*roomState // room_id -> joined room
 }
 
-type Hub struct {
-	rooms      map[string]*Room
-	mu         sync.RWMutex // Add mutex for concurrent access
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan BroadcastMessage
+// roomState is what a Client knows about one of its joined rooms.
+type roomState struct {
+	moderator bool
+	topic     string
 }
 
 type RoomRequest struct {
 	Name       string `json:"name"`
 	Password   string `json:"password"`
 	MaxMembers int    `json:"max_members,string"`
+	Ephemeral  bool   `json:"ephemeral"`
+}
+
+type RoomActionRequest struct {
+	RoomID string `json:"room_id"`
+}
+
+type WebhookRequest struct {
+	RoomID string   `json:"room_id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type WebhookActionRequest struct {
+	ID string `json:"id"`
+}
+
+type IncomingWebhookRequest struct {
+	RoomID string `json:"room_id"`
+	Name   string `json:"name"`
+}
+
+// IncomingMessage is what incoming webhooks post. Attachment data is
+// base64 encoded.
+type IncomingMessage struct {
+	Text        string `json:"text"`
+	Username    string `json:"username"`
+	Attachments []struct {
+		Filename string `json:"filename"`
+		Data     []byte `json:"data"`
+	} `json:"attachments"`
+}
+
+type BotRequest struct {
+	Name string `json:"name"`
+}
+
+type BotActionRequest struct {
+	ID string `json:"id"`
+}
+
+type RetryDeliveryRequest struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+type ConversationRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+type ReadRequest struct {
+	RoomID    string `json:"room_id"`
+	MessageID int64  `json:"message_id"`
+}
+
+// PresenceEntry is a single user in the /api/presence response.
+type PresenceEntry struct {
+	UserID            string `json:"user_id"`
+	Status            string `json:"status"`
+	Online            bool   `json:"online"`
+	LastActive        int64  `json:"last_active,omitempty"`
+	ActiveConnections int32  `json:"active_connections,omitempty"`
+}
+
+type PresenceListResponse struct {
+	Presences  []PresenceEntry `json:"presences"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type HistorySession struct {
+	SessionID string `json:"session_id"`
+	RoomID    string `json:"room_id,omitempty"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Ongoing   bool   `json:"ongoing"`
+}
+
+type HistoryInterval struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type DailyActivity struct {
+	Date          string `json:"date"`
+	OnlineSeconds int64  `json:"online_seconds"`
+}
+
+type UserHistory struct {
+	UserID        string            `json:"user_id"`
+	Sessions      []HistorySession  `json:"sessions"`
+	Intervals     []HistoryInterval `json:"intervals"`
+	Daily         []DailyActivity   `json:"daily"`
+	HourlySeconds []int64           `json:"hourly_seconds"`
 }
 
-type JoinRequest struct {
-	RoomID   string `json:"room_id"`
-	Password string `json:"password"`
+// PrivacyRequest is the JSON form of presence.PrivacySettings, using the
+// lowercase visibility names "everyone", "contacts" and "nobody".
+type PrivacyRequest struct {
+	OnlineStatus string   `json:"online_status"`
+	LastSeen     string   `json:"last_seen"`
+	Contacts     []string `json:"contacts"`
 }
 
 type PageData struct {
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"go-grpc-basic/proto/chat"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxUnfurlsPerMessage caps how many links of a message get previews.
+	maxUnfurlsPerMessage = 3
+	// maxUnfurlBytes is how much of a page is read looking for metadata.
+	maxUnfurlBytes = 512 << 10
+	// unfurlTimeout bounds fetching one page, redirects included.
+	unfurlTimeout = 5 * time.Second
+	// maxUnfurlRedirects caps the redirects followed for one link.
+	maxUnfurlRedirects = 3
+	// maxConcurrentUnfurls bounds the fetches running at once.
+	maxConcurrentUnfurls = 8
+
+	// Previews are cached for unfurlCacheTTL, failures for
+	// unfurlErrorTTL, in a cache of up to unfurlCacheSize links.
+	unfurlCacheTTL  = time.Hour
+	unfurlErrorTTL  = 10 * time.Minute
+	unfurlCacheSize = 1000
+
+	maxPreviewTitle       = 200
+	maxPreviewDescription = 300
+)
+
+// linkPattern finds the bare and Markdown links of a message.
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+var errNoPreview = errors.New("page has no preview metadata")
+
+// unfurler makes link previews for the messages users send, fetching the
+// OpenGraph and Twitter card metadata of the linked pages. Pages are
+// fetched with its client, which newUnfurlClient guards against requests
+// to internal addresses.
+type unfurler struct {
+	client     *http.Client
+	chatClient chat.ChatServiceClient
+	slots      chan struct{}
+
+	mu       sync.Mutex
+	cache    map[string]unfurlEntry
+	inflight map[string]*unfurlCall
+	// handled remembers the message versions being unfurled, since an
+	// author with several streams sees each message more than once.
+	handled map[unfurlKey]time.Time
+}
+
+type unfurlEntry struct {
+	preview *chat.LinkPreview // nil if the link has no preview
+	expires time.Time
+}
+
+// unfurlCall is a fetch in progress that other messages with the same link
+// wait for.
+type unfurlCall struct {
+	done    chan struct{}
+	preview *chat.LinkPreview
+}
+
+type unfurlKey struct {
+	messageID int64
+	editedAt  int64
+}
+
+func newUnfurler(client *http.Client, chatClient chat.ChatServiceClient) *unfurler {
+	return &unfurler{
+		client:     client,
+		chatClient: chatClient,
+		slots:      make(chan struct{}, maxConcurrentUnfurls),
+		cache:      make(map[string]unfurlEntry),
+		inflight:   make(map[string]*unfurlCall),
+		handled:    make(map[unfurlKey]time.Time),
+	}
+}
+
+// newUnfurlClient returns an HTTP client for fetching link previews. Unless
+// allowPrivate is set, which is meant for testing against local servers,
+// it only connects to public addresses on the standard ports. The check
+// runs on the address actually dialed, after DNS resolution and on every
+// redirect.
+func newUnfurlClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if port := addrPort.Port(); port != 80 && port != 443 {
+				return fmt.Errorf("unfurl: port %d not allowed", port)
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("unfurl: address %s not allowed", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: unfurlTimeout,
+		Transport: &http.Transport{
+			// Never go through a proxy, which would dial for us unchecked
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   3 * time.Second,
+			ResponseHeaderTimeout: 3 * time.Second,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxUnfurlRedirects {
+				return errors.New("unfurl: too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("unfurl: redirect to unsupported scheme")
+			}
+			return nil
+		},
+	}
+}
+
+// blockedPrefixes are special-purpose ranges that publicAddr rejects on top
+// of the private, loopback, link-local and multicast ones.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// publicAddr reports whether addr is a public unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// unfurlMessage makes previews for the links of a message its author just
+// sent or edited and hands them to the chat service, which tells the room.
+// ctx carries the author's identity; the work happens in the background
+// and is not canceled with ctx.
+func (u *unfurler) unfurlMessage(ctx context.Context, msg *chat.Message) {
+	links := messageLinks(msg.Content)
+	if len(links) == 0 {
+		return
+	}
+
+	key := unfurlKey{messageID: msg.Id, editedAt: msg.EditedAt}
+	u.mu.Lock()
+	now := time.Now()
+	for k, at := range u.handled {
+		if now.Sub(at) > time.Minute {
+			delete(u.handled, k)
+		}
+	}
+	if _, seen := u.handled[key]; seen {
+		u.mu.Unlock()
+		return
+	}
+	u.handled[key] = now
+	u.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*unfurlTimeout)
+		defer cancel()
+
+		var previews []*chat.LinkPreview
+		for _, link := range links {
+			if preview := u.preview(ctx, link); preview != nil {
+				previews = append(previews, preview)
+			}
+		}
+		if len(previews) == 0 {
+			return
+		}
+
+		_, err := u.chatClient.SetLinkPreviews(ctx, &chat.SetLinkPreviewsRequest{
+			MessageId: msg.Id,
+			EditedAt:  msg.EditedAt,
+			Previews:  previews,
+		})
+		if err != nil {
+			log.Printf("gRPC error: %v", err)
+		}
+	}()
+}
+
+// messageLinks returns the distinct links of a message, up to
+// maxUnfurlsPerMessage.
+func messageLinks(content string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, match := range linkPattern.FindAllString(content, -1) {
+		link := trimLink(match)
+		if seen[link] || len(link) > 2048 {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == maxUnfurlsPerMessage {
+			break
+		}
+	}
+	return links
+}
+
+// trimLink drops the punctuation that follows a link in a sentence,
+// keeping closing parentheses that have an opening one in the link.
+func trimLink(link string) string {
+	for link != "" {
+		last := link[len(link)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'*", last) >= 0:
+		case last == ')' && strings.Count(link, "(") < strings.Count(link, ")"):
+		default:
+			return link
+		}
+		link = link[:len(link)-1]
+	}
+	return link
+}
+
+// preview returns the preview of a link, from the cache or by fetching it,
+// or nil if there is none.
+func (u *unfurler) preview(ctx context.Context, link string) *chat.LinkPreview {
+	u.mu.Lock()
+	if entry, ok := u.cache[link]; ok && time.Now().Before(entry.expires) {
+		u.mu.Unlock()
+		return entry.preview
+	}
+	if call, ok := u.inflight[link]; ok {
+		u.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.preview
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	call := &unfurlCall{done: make(chan struct{})}
+	u.inflight[link] = call
+	u.mu.Unlock()
+
+	preview, err := u.fetch(ctx, link)
+	ttl := unfurlCacheTTL
+	if err != nil {
+		if !errors.Is(err, errNoPreview) {
+			log.Printf("Error unfurling %s: %v", link, err)
+		}
+		preview, ttl = nil, unfurlErrorTTL
+	}
+
+	u.mu.Lock()
+	delete(u.inflight, link)
+	u.store(link, unfurlEntry{preview: preview, expires: time.Now().Add(ttl)})
+	u.mu.Unlock()
+
+	call.preview = preview
+	close(call.done)
+	return preview
+}
+
+// store caches an entry, making room by dropping expired entries or, if
+// there are none, an arbitrary one. u.mu must be held.
+func (u *unfurler) store(link string, entry unfurlEntry) {
+	if len(u.cache) >= unfurlCacheSize {
+		now := time.Now()
+		for k, e := range u.cache {
+			if now.After(e.expires) {
+				delete(u.cache, k)
+			}
+		}
+		for k := range u.cache {
+			if len(u.cache) < unfurlCacheSize {
+				break
+			}
+			delete(u.cache, k)
+		}
+	}
+	u.cache[link] = entry
+}
+
+// fetch downloads the start of an HTML page and reads its preview.
+func (u *unfurler) fetch(ctx context.Context, link string) (*chat.LinkPreview, error) {
+	select {
+	case u.slots <- struct{}{}:
+		defer func() { <-u.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "go-grpc-basic-unfurler/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, errNoPreview
+	}
+
+	preview := parsePreview(io.LimitReader(resp.Body, maxUnfurlBytes), resp.Request.URL)
+	if preview == nil {
+		return nil, errNoPreview
+	}
+	preview.Url = link
+	return preview, nil
+}
+
+// parsePreview reads the OpenGraph and Twitter card metadata of a page,
+// falling back to its title and description. It stops at the end of the
+// head. Relative image URLs are resolved against base, the page's final
+// URL.
+func parsePreview(r io.Reader, base *url.URL) *chat.LinkPreview {
+	meta := make(map[string]string)
+	var title string
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		if tt == html.EndTagToken && token.DataAtom == atom.Head || tt == html.StartTagToken && token.DataAtom == atom.Body {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		switch token.DataAtom {
+		case atom.Title:
+			if title == "" && z.Next() == html.TextToken {
+				title = string(z.Text())
+			}
+		case atom.Meta:
+			var key, content string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "property", "name":
+					key = strings.ToLower(attr.Val)
+				case "content":
+					content = attr.Val
+				}
+			}
+			if _, ok := meta[key]; key != "" && !ok {
+				meta[key] = content
+			}
+		}
+	}
+
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v = strings.Join(strings.Fields(v), " "); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	preview := &chat.LinkPreview{
+		Title:       truncate(first(meta["og:title"], meta["twitter:title"], title), maxPreviewTitle),
+		Description: truncate(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxPreviewDescription),
+		SiteName:    truncate(first(meta["og:site_name"]), maxPreviewTitle),
+	}
+	if preview.Title == "" && preview.Description == "" {
+		return nil
+	}
+	if image := first(meta["og:image"], meta["twitter:image"], meta["twitter:image:src"]); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.String()) <= 2048 {
+			preview.ImageUrl = u.String()
+		}
+	}
+	return preview
+}
+
+// truncate shortens s to at most n runes, marking the cut with an
+// ellipsis.
+func truncate(s string, n int) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "")
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
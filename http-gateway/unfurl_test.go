@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const previewPage = `<!doctype html>
+<html><head>
+<title>Page title</title>
+<meta property="og:title" content="OG title">
+<meta property="og:description" content="OG description">
+<meta property="og:site_name" content="Example">
+<meta property="og:image" content="/img/card.png">
+</head><body><meta property="og:title" content="ignored"></body></html>`
+
+// newPageServer serves HTML pages by path, counting the requests.
+func newPageServer(t *testing.T, pages map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestParsePreview(t *testing.T) {
+	base, _ := url.Parse("https://example.com/posts/1")
+	tests := []struct {
+		name string
+		page string
+		want string // title|description|site|image, or "" for no preview
+	}{
+		{"opengraph", previewPage, "OG title|OG description|Example|https://example.com/img/card.png"},
+		{
+			"twitter",
+			`<head><meta name="twitter:title" content="TW title"><meta name="twitter:description" content="TW description"><meta name="twitter:image:src" content="https://cdn.example.com/a.png"></head>`,
+			"TW title|TW description||https://cdn.example.com/a.png",
+		},
+		{
+			"opengraph before twitter",
+			`<head><meta name="twitter:title" content="TW"><meta property="OG:TITLE" content="OG"></head>`,
+			"OG|||",
+		},
+		{
+			"fallback to title and description",
+			`<head><title>  Plain
+			title </title><meta name="description" content="Plain description"></head>`,
+			"Plain title|Plain description||",
+		},
+		{
+			"unsafe image scheme",
+			`<head><meta property="og:title" content="T"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			"T|||",
+		},
+		{"only in body", `<body><meta property="og:title" content="Late"></body>`, ""},
+		{"nothing", `<head><meta name="viewport" content="width=device-width"></head>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview := parsePreview(strings.NewReader(tt.page), base)
+			var got string
+			if preview != nil {
+				got = strings.Join([]string{preview.Title, preview.Description, preview.SiteName, preview.ImageUrl}, "|")
+			}
+			if got != tt.want {
+				t.Fatalf("preview = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePreviewTruncates(t *testing.T) {
+	long := strings.Repeat("é", maxPreviewTitle+50)
+	preview := parsePreview(strings.NewReader(`<head><meta property="og:title" content="`+long+`"></head>`), &url.URL{})
+	if n := len([]rune(preview.Title)); n != maxPreviewTitle || !strings.HasSuffix(preview.Title, "…") {
+		t.Fatalf("title has %d runes ending %q, want %d ending in an ellipsis", n, preview.Title[len(preview.Title)-4:], maxPreviewTitle)
+	}
+}
+
+func TestFetchPreview(t *testing.T) {
+	srv, _ := newPageServer(t, map[string]string{"/post": previewPage})
+	u := newUnfurler(newUnfurlClient(true), nil)
+
+	preview, err := u.fetch(context.Background(), srv.URL+"/post")
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if preview.Url != srv.URL+"/post" || preview.Title != "OG title" || preview.ImageUrl != srv.URL+"/img/card.png" {
+		t.Fatalf("preview = %v", preview)
+	}
+
+	if _, err := u.fetch(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Fatal("fetch of a missing page succeeded")
+	}
+}
+
+func TestFetchReadsAtMostMaxUnfurlBytes(t *testing.T) {
+	meta := `<meta property="og:title" content="Found">`
+	padding := func(n int) string { return "<!--" + strings.Repeat("x", n) + "-->" }
+	srv, _ := newPageServer(t, map[string]string{
+		"/within": "<head>" + padding(maxUnfurlBytes-1024) + meta + "</head>",
+		"/beyond": "<head>" + padding(maxUnfurlBytes) + meta + "</head>",
+	})
+	u := newUnfurler(newUnfurlClient(true), nil)
+
+	if _, err := u.fetch(context.Background(), srv.URL+"/within"); err != nil {
+		t.Fatalf("fetch of metadata within the cap: %v", err)
+	}
+	if _, err := u.fetch(context.Background(), srv.URL+"/beyond"); err != errNoPreview {
+		t.Fatalf("fetch of metadata beyond the cap = %v, want errNoPreview", err)
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	u := newUnfurler(newUnfurlClient(true), nil)
+
+	start := time.Now()
+	if _, err := u.fetch(context.Background(), srv.URL); err == nil {
+		t.Fatal("fetch of a page that never answers succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > unfurlTimeout+time.Second {
+		t.Fatalf("fetch gave up after %s, want at most %s", elapsed, unfurlTimeout)
+	}
+}
+
+func TestFetchFollowsLimitedRedirects(t *testing.T) {
+	// /hop/N redirects N more times before the page
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if n > 0 {
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, previewPage)
+	}))
+	defer srv.Close()
+	u := newUnfurler(newUnfurlClient(true), nil)
+
+	if _, err := u.fetch(context.Background(), srv.URL+"/hop/"+strconv.Itoa(maxUnfurlRedirects)); err != nil {
+		t.Fatalf("fetch with %d redirects: %v", maxUnfurlRedirects, err)
+	}
+	_, err := u.fetch(context.Background(), srv.URL+"/hop/"+strconv.Itoa(maxUnfurlRedirects+1))
+	if err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Fatalf("fetch with %d redirects = %v, want too many redirects", maxUnfurlRedirects+1, err)
+	}
+}
+
+func TestPreviewIsCached(t *testing.T) {
+	srv, hits := newPageServer(t, map[string]string{
+		"/post":  previewPage,
+		"/plain": "<head></head>",
+	})
+	u := newUnfurler(newUnfurlClient(true), nil)
+	ctx := context.Background()
+
+	for range 3 {
+		if preview := u.preview(ctx, srv.URL+"/post"); preview == nil || preview.Title != "OG title" {
+			t.Fatalf("preview = %v", preview)
+		}
+	}
+	// Pages without a preview are remembered too
+	for range 2 {
+		if preview := u.preview(ctx, srv.URL+"/plain"); preview != nil {
+			t.Fatalf("preview of a plain page = %v, want nil", preview)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("server got %d requests, want 2", n)
+	}
+}
+
+func TestUnfurlClientRefusesPrivateAddresses(t *testing.T) {
+	srv, hits := newPageServer(t, map[string]string{"/": previewPage})
+	client := newUnfurlClient(false)
+
+	// The checks run before connecting, so none of these reach a server
+	for _, link := range []string{
+		srv.URL + "/",                // loopback on a test port
+		"http://127.0.0.1:80/",       // loopback on an allowed port
+		"http://10.0.0.1:443/",       // private
+		"http://169.254.169.254:80/", // link-local metadata service
+		"http://93.184.215.14:8080/", // public, but not on 80 or 443
+	} {
+		resp, err := client.Get(link)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("GET %s succeeded", link)
+			continue
+		}
+		if !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("GET %s = %v, want a refusal", link, err)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("server got %d requests, want none", n)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
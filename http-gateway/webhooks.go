@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go-grpc-basic/proto/chat"
+)
+
+// Outgoing webhooks post a room's events to URLs its owner registers. The
+// chat service delivers them; these endpoints manage them and, under
+// /admin, let moderators inspect and retry the deliveries.
+
+// roomWebhooksHandler lists the webhooks of ?room_id= with their delivery
+// counts.
+func roomWebhooksHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		roomID := r.URL.Query().Get("room_id")
+		if roomID == "" {
+			http.Error(w, "Missing room_id", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := chatClient.ListWebhooks(userContext(r.Context(), r), &chat.ListWebhooksRequest{RoomId: roomID})
+		if err != nil {
+			writeChatError(w, err, "Failed to list webhooks")
+			return
+		}
+		writeProtoList(w, resp.Webhooks)
+	})
+}
+
+// createWebhookHandler registers a webhook. The response is the only one
+// that includes its signing secret.
+func createWebhookHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		webhook, err := chatClient.CreateWebhook(userContext(r.Context(), r), &chat.CreateWebhookRequest{
+			RoomId: req.RoomID,
+			Url:    req.URL,
+			Events: req.Events,
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to create webhook")
+			return
+		}
+		writeProto(w, webhook)
+	})
+}
+
+func deleteWebhookHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req WebhookActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		_, err := chatClient.DeleteWebhook(userContext(r.Context(), r), &chat.DeleteWebhookRequest{Id: req.ID})
+		if err != nil {
+			writeChatError(w, err, "Failed to delete webhook")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allWebhooksHandler lists every webhook with its delivered, pending and
+// dead delivery counts, for moderators.
+func allWebhooksHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := chatClient.ListWebhooks(userContext(r.Context(), r), &chat.ListWebhooksRequest{})
+		if err != nil {
+			writeChatError(w, err, "Failed to list webhooks")
+			return
+		}
+		writeProtoList(w, resp.Webhooks)
+	})
+}
+
+// webhookDeliveriesHandler pages backwards through the delivery log,
+// optionally of one ?webhook_id= and with one ?status=. Dead deliveries
+// form the dead-letter list.
+func webhookDeliveriesHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := &chat.ListWebhookDeliveriesRequest{
+			WebhookId: query.Get("webhook_id"),
+			Status:    query.Get("status"),
+		}
+
+		if s := query.Get("before"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id < 1 {
+				http.Error(w, "Invalid before", http.StatusBadRequest)
+				return
+			}
+			req.BeforeId = id
+		}
+
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			req.Limit = int32(min(l, 1<<30))
+		}
+
+		page, err := chatClient.ListWebhookDeliveries(userContext(r.Context(), r), req)
+		if err != nil {
+			writeChatError(w, err, "Failed to load deliveries")
+			return
+		}
+		writeProto(w, page)
+	})
+}
+
+// retryDeliveryHandler queues a dead delivery to be attempted again.
+func retryDeliveryHandler(chatClient chat.ChatServiceClient) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req RetryDeliveryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		delivery, err := chatClient.RetryWebhookDelivery(userContext(r.Context(), r), &chat.RetryWebhookDeliveryRequest{
+			DeliveryId: req.DeliveryID,
+		})
+		if err != nil {
+			writeChatError(w, err, "Failed to retry delivery")
+			return
+		}
+		writeProto(w, delivery)
+	})
+}
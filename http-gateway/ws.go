@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-grpc-basic/proto/chat"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// websocketHandler bridges a WebSocket connection to a ChatService Chat
+// stream. Client frames are ChatRequest JSON; every ChatEvent is sent back
+// as a frame holding the event's fields plus its type and room_id. Messages
+// starting with "/" run commands, which may answer with "command" frames.
+// Links in the user's own messages are unfurled.
+func websocketHandler(chatClient chat.ChatServiceClient, unfurler *unfurler) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		// The stream outlives the request, so it gets its own context.
+		ctx, cancel := context.WithCancel(userContext(context.Background(), r))
+		stream, err := chatClient.Chat(ctx)
+		if err != nil {
+			cancel()
+			log.Printf("gRPC error: %v", err)
+			http.Error(w, "Chat unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+		if err != nil {
+			cancel()
+			log.Println("WebSocket upgrade failed:", err)
+			return
+		}
+
+		session, _ := store.Get(r, "session-name")
+		client := &Client{
+			conn:       conn,
+			send:       make(chan []byte, 256),
+			replies:    make(chan []byte, 16),
+			stream:     stream,
+			chatClient: chatClient,
+			userID:     session.Values["userID"].(string),
+			unfurler:   unfurler,
+			username:   session.Values["username"].(string),
+			rooms:      make(map[string]*roomState),
+		}
+
+		go client.writePump()
+		go client.forwardEvents()
+		go client.readPump(cancel)
+	})
+}
+
+// readPump forwards the client's frames to the chat service.
+func (c *Client) readPump(cancel context.CancelFunc) {
+	defer func() {
+		c.stream.CloseSend()
+		c.conn.Close()
+		cancel()
+	}()
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var req chat.ChatRequest
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(message, &req); err != nil {
+			log.Printf("Error parsing message: %v", err)
+			continue
+		}
+		if req.Action == "message" && strings.HasPrefix(req.Content, "/") {
+			if !strings.HasPrefix(req.Content, "//") {
+				c.runCommand(&req)
+				continue
+			}
+			req.Content = req.Content[1:]
+		}
+		if err := c.stream.Send(&req); err != nil {
+			return
+		}
+	}
+}
+
+// forwardEvents turns chat service events into frames until the stream
+// ends, then lets writePump close the connection.
+func (c *Client) forwardEvents() {
+	defer close(c.send)
+
+	for {
+		event, err := c.stream.Recv()
+		if err != nil {
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				log.Printf("Chat stream error: %v", err)
+			}
+			return
+		}
+		c.trackEvent(event)
+
+		frame, err := eventFrame(event)
+		if err != nil {
+			log.Printf("Error marshaling event: %v", err)
+			continue
+		}
+		select {
+		case c.send <- frame:
+		case <-c.stream.Context().Done():
+			return
+		}
+
+		if msg := c.ownMessage(event); msg != nil && c.unfurler != nil {
+			c.unfurler.unfurlMessage(c.stream.Context(), msg)
+		}
+	}
+}
+
+// ownMessage returns the message of an event that carries a message the
+// client's user just sent or edited.
+func (c *Client) ownMessage(event *chat.ChatEvent) *chat.Message {
+	var msg *chat.Message
+	switch e := event.Event.(type) {
+	case *chat.ChatEvent_Chat:
+		msg = e.Chat
+	case *chat.ChatEvent_Reply:
+		msg = e.Reply.GetMessage()
+	case *chat.ChatEvent_Edited:
+		msg = e.Edited
+	}
+	if msg == nil || msg.UserId != c.userID {
+		return nil
+	}
+	return msg
+}
+
+// eventFrame flattens a ChatEvent into a JSON object: the fields of the
+// event's payload plus "type", the name of the payload, and "room_id".
+func eventFrame(event *chat.ChatEvent) ([]byte, error) {
+	m := event.ProtoReflect()
+	field := m.WhichOneof(m.Descriptor().Oneofs().ByName("event"))
+	if field == nil {
+		return nil, errors.New("empty chat event")
+	}
+
+	payload, err := protoJSON.Marshal(m.Get(field).Message().Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var frame map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return nil, err
+	}
+	frame["type"], _ = json.Marshal(field.Name())
+	if event.RoomId != "" {
+		frame["room_id"], _ = json.Marshal(event.RoomId)
+	}
+	return json.Marshal(frame)
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(15 * time.Second)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			w, err := c.conn.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
+			w.Write(message)
+
+			if err := w.Close(); err != nil {
+				return
+			}
+
+		case reply := <-c.replies:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteMessage(websocket.TextMessage, reply); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// historyRetention is how long presence transitions are kept.
+	historyRetention = 30 * 24 * time.Hour
+	defaultHistory   = 7 * 24 * time.Hour
+)
+
+// historyEvent is a session coming online or going offline. Events are
+// derived from replicated session records, so every replica that sees a
+// record records the same event. History itself is not gossiped: a replica
+// only knows sessions whose records it has seen.
+type historyEvent struct {
+	sessionID string
+	roomID    string
+	online    bool
+	at        int64 // unix nanos
+}
+
+// recordTransition appends history events for a session record replacing
+// old, which may be nil. s.mu must be held.
+func (s *presenceServer) recordTransition(old, rec *presence.SessionRecord) {
+	wasOnline := old != nil && old.Online
+	if rec.StartedAt == 0 || rec.Online == wasOnline {
+		return
+	}
+
+	if old == nil || rec.Online {
+		s.appendEvent(rec.UserId, historyEvent{sessionID: rec.SessionId, roomID: rec.RoomId, online: true, at: rec.StartedAt})
+	}
+	if !rec.Online {
+		s.appendEvent(rec.UserId, historyEvent{sessionID: rec.SessionId, roomID: rec.RoomId, at: rec.UpdatedAt})
+	}
+}
+
+func (s *presenceServer) appendEvent(userID string, event historyEvent) {
+	key := event.sessionID + "/on"
+	if !event.online {
+		key = event.sessionID + "/off"
+	}
+	if _, seen := s.historySeen[key]; seen {
+		return
+	}
+	s.historySeen[key] = struct{}{}
+	s.history[userID] = append(s.history[userID], event)
+}
+
+// trimHistory drops the events of sessions that ended before the retention
+// window. s.mu must be held.
+func (s *presenceServer) trimHistory() {
+	cutoff := time.Now().Add(-historyRetention).UnixNano()
+	for uid, events := range s.history {
+		expired := make(map[string]bool)
+		for _, event := range events {
+			if !event.online && event.at < cutoff {
+				expired[event.sessionID] = true
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		kept := events[:0]
+		for _, event := range events {
+			if expired[event.sessionID] {
+				if event.online {
+					delete(s.historySeen, event.sessionID+"/on")
+				} else {
+					delete(s.historySeen, event.sessionID+"/off")
+				}
+				continue
+			}
+			kept = append(kept, event)
+		}
+		if len(kept) == 0 {
+			delete(s.history, uid)
+		} else {
+			s.history[uid] = kept
+		}
+	}
+}
+
+func (s *presenceServer) GetPresenceHistory(ctx context.Context, req *presence.GetPresenceHistoryRequest) (*presence.GetPresenceHistoryResponse, error) {
+	now := time.Now().Unix()
+	to := req.To
+	if to <= 0 || to > now {
+		to = now
+	}
+	from := req.From
+	if from <= 0 {
+		from = to - int64(defaultHistory/time.Second)
+	}
+	if from >= to {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+	if to-from > int64(historyRetention/time.Second) {
+		from = to - int64(historyRetention/time.Second)
+	}
+	offset := int64(req.UtcOffsetMinutes) * 60
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &presence.GetPresenceHistoryResponse{}
+	for _, uid := range sortedUnique(req.UserIds) {
+		history := &presence.UserHistory{UserId: uid}
+		if s.canSee(req.ViewerId, uid, s.privacy[uid].GetOnlineStatus()) {
+			history.Sessions = s.sessionsBetween(uid, from, to)
+		}
+		history.Intervals = mergeIntervals(history.Sessions)
+		history.Daily, history.HourlySeconds = aggregateActivity(history.Intervals, from, to, offset)
+		resp.Histories = append(resp.Histories, history)
+	}
+	return resp, nil
+}
+
+// sessionsBetween pairs up a user's events into sessions overlapping
+// [from, to], clipped to the range. s.mu must be held.
+func (s *presenceServer) sessionsBetween(userID string, from, to int64) []*presence.PresenceSession {
+	bySession := make(map[string]*presence.PresenceSession)
+	for _, event := range s.history[userID] {
+		session, ok := bySession[event.sessionID]
+		if !ok {
+			session = &presence.PresenceSession{SessionId: event.sessionID, RoomId: event.roomID, Ongoing: true}
+			bySession[event.sessionID] = session
+		}
+		if event.online {
+			session.Start = event.at / int64(time.Second)
+		} else {
+			session.End = event.at / int64(time.Second)
+			session.Ongoing = false
+		}
+	}
+
+	result := make([]*presence.PresenceSession, 0, len(bySession))
+	for _, session := range bySession {
+		if session.Ongoing {
+			session.End = to
+		}
+		if session.Start == 0 || session.Start >= to || session.End <= from {
+			continue
+		}
+		session.Start = max(session.Start, from)
+		session.End = min(session.End, to)
+		result = append(result, session)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	return result
+}
+
+// mergeIntervals returns the union of sessions, which must be sorted by start.
+func mergeIntervals(sessions []*presence.PresenceSession) []*presence.PresenceInterval {
+	var result []*presence.PresenceInterval
+	for _, session := range sessions {
+		if n := len(result); n > 0 && session.Start <= result[n-1].End {
+			result[n-1].End = max(result[n-1].End, session.End)
+			continue
+		}
+		result = append(result, &presence.PresenceInterval{Start: session.Start, End: session.End})
+	}
+	return result
+}
+
+// aggregateActivity sums online time per local day in [from, to] and per
+// local hour of day, with offset seconds added to UTC.
+func aggregateActivity(intervals []*presence.PresenceInterval, from, to, offset int64) ([]*presence.DailyActivity, []int64) {
+	var daily []*presence.DailyActivity
+	dayIndex := make(map[string]*presence.DailyActivity)
+	for day := (from + offset) / 86400 * 86400; day < to+offset; day += 86400 {
+		activity := &presence.DailyActivity{Date: time.Unix(day, 0).UTC().Format(time.DateOnly)}
+		daily = append(daily, activity)
+		dayIndex[activity.Date] = activity
+	}
+
+	hourly := make([]int64, 24)
+	for _, interval := range intervals {
+		for t := interval.Start; t < interval.End; {
+			local := t + offset
+			next := min(interval.End, (local/3600+1)*3600-offset)
+			hourly[(local/3600)%24] += next - t
+			if activity, ok := dayIndex[time.Unix(local, 0).UTC().Format(time.DateOnly)]; ok {
+				activity.OnlineSeconds += next - t
+			}
+			t = next
+		}
+	}
+	return daily, hourly
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+)
+
+// addSession records a session of userID from start until end, or still
+// ongoing if end is zero.
+func addSession(s *presenceServer, userID, sessionID string, start, end time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := &presence.SessionRecord{SessionId: sessionID, UserId: userID, Online: true, UpdatedAt: start.UnixNano(), NodeId: s.nodeID, StartedAt: start.UnixNano()}
+	s.putSession(rec)
+	if !end.IsZero() {
+		closed := &presence.SessionRecord{SessionId: sessionID, UserId: userID, UpdatedAt: end.UnixNano(), NodeId: s.nodeID, StartedAt: start.UnixNano()}
+		s.putSession(closed)
+	}
+}
+
+// historyDay is midnight UTC a few days ago, so that fixed times around it
+// are inside the retention window.
+func historyDay() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour).Add(-3 * 24 * time.Hour)
+}
+
+func getHistory(t *testing.T, s *presenceServer, req *presence.GetPresenceHistoryRequest) *presence.UserHistory {
+	t.Helper()
+	resp, err := s.GetPresenceHistory(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetPresenceHistory: %v", err)
+	}
+	if len(resp.Histories) != 1 {
+		t.Fatalf("got %d histories, want 1", len(resp.Histories))
+	}
+	return resp.Histories[0]
+}
+
+func TestPresenceHistoryBuckets(t *testing.T) {
+	day := historyDay()
+	s := newPresenceServer("a")
+	// 22:30 to 01:30 across midnight, overlapped by 01:00 to 02:00
+	addSession(s, "alice", "s1", day.Add(22*time.Hour+30*time.Minute), day.Add(25*time.Hour+30*time.Minute))
+	addSession(s, "alice", "s2", day.Add(25*time.Hour), day.Add(26*time.Hour))
+
+	from, to := day.Unix(), day.Add(48*time.Hour).Unix()
+	date := func(days int) string { return day.AddDate(0, 0, days).Format(time.DateOnly) }
+	tests := []struct {
+		name          string
+		offsetMinutes int32
+		daily         map[string]int64
+		hourly        map[int]int64
+	}{
+		{"utc", 0,
+			map[string]int64{date(0): 5400, date(1): 7200},
+			map[int]int64{22: 1800, 23: 3600, 0: 3600, 1: 3600}},
+		// 00:30 to 04:00 local, all on the second day
+		{"ahead", 120,
+			map[string]int64{date(0): 0, date(1): 12600, date(2): 0},
+			map[int]int64{0: 1800, 1: 3600, 2: 3600, 3: 3600}},
+		// 21:00 to 00:30 local
+		{"behind", -90,
+			map[string]int64{date(-1): 0, date(0): 10800, date(1): 1800},
+			map[int]int64{21: 3600, 22: 3600, 23: 3600, 0: 1800}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := getHistory(t, s, &presence.GetPresenceHistoryRequest{UserIds: []string{"alice"}, ViewerId: "alice", From: from, To: to, UtcOffsetMinutes: tt.offsetMinutes})
+
+			if len(h.Sessions) != 2 || h.Sessions[0].SessionId != "s1" || h.Sessions[1].SessionId != "s2" {
+				t.Fatalf("sessions = %v, want s1 and s2", h.Sessions)
+			}
+			if len(h.Intervals) != 1 || h.Intervals[0].Start != day.Add(22*time.Hour+30*time.Minute).Unix() || h.Intervals[0].End != day.Add(26*time.Hour).Unix() {
+				t.Fatalf("intervals = %v, want one from 22:30 to 02:00", h.Intervals)
+			}
+
+			daily := make(map[string]int64)
+			for _, d := range h.Daily {
+				daily[d.Date] = d.OnlineSeconds
+			}
+			if len(daily) != len(tt.daily) {
+				t.Errorf("daily = %v, want %v", daily, tt.daily)
+			}
+			for date, want := range tt.daily {
+				if got, ok := daily[date]; !ok || got != want {
+					t.Errorf("%s: %d seconds (listed %v), want %d", date, got, ok, want)
+				}
+			}
+
+			if len(h.HourlySeconds) != 24 {
+				t.Fatalf("hourly has %d entries, want 24", len(h.HourlySeconds))
+			}
+			for hour, got := range h.HourlySeconds {
+				if got != tt.hourly[hour] {
+					t.Errorf("hour %d: %d seconds, want %d", hour, got, tt.hourly[hour])
+				}
+			}
+		})
+	}
+}
+
+func TestPresenceHistoryClipsSessions(t *testing.T) {
+	day := historyDay()
+	s := newPresenceServer("a")
+	addSession(s, "alice", "s1", day.Add(22*time.Hour), day.Add(26*time.Hour))
+	addSession(s, "alice", "s2", day.Add(30*time.Hour), time.Time{})
+	addSession(s, "alice", "s3", day.Add(-10*time.Hour), day.Add(-9*time.Hour))
+
+	from, to := day.Add(23*time.Hour).Unix(), day.Add(31*time.Hour).Unix()
+	h := getHistory(t, s, &presence.GetPresenceHistoryRequest{UserIds: []string{"alice"}, ViewerId: "alice", From: from, To: to})
+	want := []*presence.PresenceSession{
+		{SessionId: "s1", Start: from, End: day.Add(26 * time.Hour).Unix()},
+		{SessionId: "s2", Start: day.Add(30 * time.Hour).Unix(), End: to, Ongoing: true},
+	}
+	if len(h.Sessions) != len(want) {
+		t.Fatalf("sessions = %v, want %v", h.Sessions, want)
+	}
+	for i, session := range h.Sessions {
+		if session.SessionId != want[i].SessionId || session.Start != want[i].Start || session.End != want[i].End || session.Ongoing != want[i].Ongoing {
+			t.Errorf("session %d = %v, want %v", i, session, want[i])
+		}
+	}
+
+	if _, err := s.GetPresenceHistory(context.Background(), &presence.GetPresenceHistoryRequest{UserIds: []string{"alice"}, From: to, To: from}); err == nil {
+		t.Error("GetPresenceHistory accepted from after to")
+	}
+}
+
+func TestPresenceHistoryTrim(t *testing.T) {
+	s := newPresenceServer("a")
+	old := time.Now().Add(-historyRetention - 24*time.Hour)
+	addSession(s, "alice", "old", old, old.Add(time.Hour))
+	addSession(s, "alice", "recent", historyDay(), historyDay().Add(time.Hour))
+	addSession(s, "bob", "old", old, old.Add(time.Hour))
+	// Started before the window but still going
+	addSession(s, "carol", "long", old, time.Time{})
+
+	s.mu.Lock()
+	s.trimHistory()
+	var sessions []string
+	for _, event := range s.history["alice"] {
+		sessions = append(sessions, event.sessionID)
+	}
+	_, bobKept := s.history["bob"]
+	carol := len(s.history["carol"])
+	_, seen := s.historySeen["old/on"]
+	s.mu.Unlock()
+
+	if !slices.Equal(sessions, []string{"recent", "recent"}) {
+		t.Errorf("alice's history has events of %v, want only the recent session", sessions)
+	}
+	if bobKept {
+		t.Error("bob's expired history was kept")
+	}
+	if carol != 1 {
+		t.Errorf("carol's ongoing session has %d events, want 1", carol)
+	}
+	if seen {
+		t.Error("trimmed events are still marked as seen")
+	}
+}
+
+func TestPresenceHistoryPrivacy(t *testing.T) {
+	day := historyDay()
+	s := newPresenceServer("a")
+	ctx := context.Background()
+	addSession(s, "alice", "s1", day.Add(time.Hour), day.Add(2*time.Hour))
+	s.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{
+		UserId: "alice", OnlineStatus: presence.Visibility_VISIBILITY_CONTACTS, Contacts: []string{"carol"},
+	}})
+
+	from, to := day.Unix(), day.Add(24*time.Hour).Unix()
+	for viewer, visible := range map[string]bool{"alice": true, "carol": true, "erin": false, "": false} {
+		h := getHistory(t, s, &presence.GetPresenceHistoryRequest{UserIds: []string{"alice"}, ViewerId: viewer, From: from, To: to})
+		var total int64
+		for _, seconds := range h.HourlySeconds {
+			total += seconds
+		}
+		if visible && (len(h.Sessions) != 1 || len(h.Intervals) != 1 || total != 3600 || h.Daily[0].OnlineSeconds != 3600) {
+			t.Errorf("%q sees %v, want alice's session", viewer, h)
+		}
+		if !visible && (len(h.Sessions) != 0 || len(h.Intervals) != 0 || total != 0 || h.Daily[0].OnlineSeconds != 0) {
+			t.Errorf("%q sees %v, want nothing", viewer, h)
+		}
+	}
+}
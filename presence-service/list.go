@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (s *presenceServer) ListPresence(ctx context.Context, req *presence.ListPresenceRequest) (*presence.ListPresenceResponse, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userIDs := req.UserIds
+	if len(userIDs) == 0 {
+		userIDs = make([]string, 0, len(s.presence))
+		for uid := range s.presence {
+			if s.sharesOnlineStatus(req.ViewerId, uid) {
+				userIDs = append(userIDs, uid)
+			}
+		}
+	}
+	userIDs = sortedUnique(userIDs)
+
+	resp := &presence.ListPresenceResponse{}
+	for _, uid := range userIDs {
+		if uid <= after {
+			continue
+		}
+
+		// Users hiding their online status look like users never seen
+		entry := &presence.UserPresence{UserId: uid, Status: presence.PresenceStatus_PRESENCE_STATUS_UNKNOWN}
+		if p, exists := s.presence[uid]; exists && s.sharesOnlineStatus(req.ViewerId, uid) {
+			entry = s.visiblePresence(req.ViewerId, p)
+		}
+		if !matchesFilters(req, entry) {
+			continue
+		}
+
+		if len(resp.Presences) == pageSize {
+			resp.NextCursor = encodeCursor(resp.Presences[pageSize-1].UserId)
+			break
+		}
+		resp.Presences = append(resp.Presences, entry)
+	}
+	return resp, nil
+}
+
+// sharesOnlineStatus reports whether the viewer may see whether userID is
+// online. s.mu must be held.
+func (s *presenceServer) sharesOnlineStatus(viewerID, userID string) bool {
+	return s.canSee(viewerID, userID, s.privacy[userID].GetOnlineStatus())
+}
+
+// matchesFilters applies the request filters to the presence as the viewer
+// sees it, so that filtering cannot reveal hidden fields.
+func matchesFilters(req *presence.ListPresenceRequest, p *presence.UserPresence) bool {
+	if req.OnlineOnly && !p.Online {
+		return false
+	}
+	if req.Status != nil && *req.Status != p.Status {
+		return false
+	}
+	if req.UpdatedSince > 0 && p.LastActive < req.UpdatedSince {
+		return false
+	}
+	return true
+}
+
+func sortedUnique(ids []string) []string {
+	result := append([]string(nil), ids...)
+	sort.Strings(result)
+
+	n := 0
+	for i, id := range result {
+		if id == "" || (i > 0 && id == result[i-1]) {
+			continue
+		}
+		result[n] = id
+		n++
+	}
+	return result[:n]
+}
+
+// Cursors are the last user ID of the previous page. They are encoded so
+// clients treat them as opaque.
+func encodeCursor(userID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userID))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(b), err
+}
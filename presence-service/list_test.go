@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go-grpc-basic/proto/presence"
+)
+
+func TestListPresenceStatusFilter(t *testing.T) {
+	s := newPresenceServer("a")
+	ctx := context.Background()
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "bob", SessionId: "s2", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "bob", SessionId: "s2", Online: false})
+
+	tests := []struct {
+		name   string
+		status *presence.PresenceStatus
+		want   []string
+	}{
+		{"unset", nil, []string{"alice", "bob", "carol"}},
+		{"online", presence.PresenceStatus_PRESENCE_STATUS_ONLINE.Enum(), []string{"alice"}},
+		{"offline", presence.PresenceStatus_PRESENCE_STATUS_OFFLINE.Enum(), []string{"bob"}},
+		{"unknown", presence.PresenceStatus_PRESENCE_STATUS_UNKNOWN.Enum(), []string{"carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.ListPresence(ctx, &presence.ListPresenceRequest{
+				UserIds: []string{"alice", "bob", "carol"},
+				Status:  tt.status,
+			})
+			if err != nil {
+				t.Fatalf("ListPresence: %v", err)
+			}
+			var got []string
+			for _, p := range resp.Presences {
+				got = append(got, p.UserId)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPresenceHidesUsers(t *testing.T) {
+	s := newPresenceServer("a")
+	ctx := context.Background()
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: uid, SessionId: uid, Online: true})
+	}
+	s.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{UserId: "bob", OnlineStatus: presence.Visibility_VISIBILITY_NOBODY}})
+	s.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{
+		UserId: "carol", OnlineStatus: presence.Visibility_VISIBILITY_CONTACTS, Contacts: []string{"dave"},
+	}})
+
+	list := func(viewerID string, userIDs ...string) map[string]*presence.UserPresence {
+		resp, err := s.ListPresence(ctx, &presence.ListPresenceRequest{ViewerId: viewerID, UserIds: userIDs})
+		if err != nil {
+			t.Fatalf("ListPresence: %v", err)
+		}
+		listed := make(map[string]*presence.UserPresence)
+		for _, p := range resp.Presences {
+			listed[p.UserId] = p
+		}
+		return listed
+	}
+
+	// Listing everyone only enumerates users the viewer may see
+	if got := list("erin"); len(got) != 1 || got["alice"] == nil {
+		t.Fatalf("erin listed %v, want only alice", got)
+	}
+	if got := list("dave"); len(got) != 2 || got["alice"] == nil || got["carol"] == nil {
+		t.Fatalf("dave listed %v, want alice and carol", got)
+	}
+	if got := list("bob"); got["bob"] == nil || !got["bob"].Online {
+		t.Fatalf("bob listed %v, want to see himself online", got)
+	}
+
+	// Asked for by name, hidden users are indistinguishable from strangers
+	got := list("erin", "bob", "carol", "zed")
+	for _, uid := range []string{"bob", "carol"} {
+		hidden, unknown := got[uid], got["zed"]
+		if hidden.Status != unknown.Status || hidden.Online || hidden.LastActive != 0 || hidden.ActiveConnections != 0 {
+			t.Errorf("erin sees %s as %v, want the same as unknown %v", uid, hidden, unknown)
+		}
+	}
+}
@@ -4,43 +4,187 @@ import (
 	"context"
 	"log"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"google.golang.org/grpc"
 	"go-grpc-basic/proto/presence"
+	"google.golang.org/grpc"
 )
 
 type presenceServer struct {
 	presence.UnimplementedPresenceServiceServer
+	nodeID   string
 	mu       sync.RWMutex
-	presence map[string]*presence.UserPresence // user_id -> presence
-	sessions map[string]string                 // session_id -> user_id
+	presence map[string]*presence.UserPresence    // user_id -> presence, derived from sessions
+	sessions map[string]*presence.SessionRecord   // session_id -> record, including tombstones
+	byUser   map[string]map[string]struct{}       // user_id -> session_ids
+	rooms    map[string]map[string]int            // room_id -> user_id -> session count
+	userRoom map[string]map[string]int            // user_id -> room_id -> session count
+	privacy  map[string]*presence.PrivacySettings // user_id -> privacy settings
+	nodes    map[string]int64                     // node_id -> last seen (unix nanos)
+	alive    map[string]bool                      // node_id -> liveness as of the last check
+	watchers map[*watcher]struct{}
+	changed  chan struct{}
+
+	history     map[string][]historyEvent // user_id -> presence transitions
+	historySeen map[string]struct{}       // recorded event keys
+}
+
+// watcher is a single StreamPresence subscription.
+type watcher struct {
+	viewerID string
+	userID   string
+	updates  chan *presence.PresenceUpdate
+}
+
+func newPresenceServer(nodeID string) *presenceServer {
+	return &presenceServer{
+		nodeID:   nodeID,
+		presence: make(map[string]*presence.UserPresence),
+		sessions: make(map[string]*presence.SessionRecord),
+		byUser:   make(map[string]map[string]struct{}),
+		rooms:    make(map[string]map[string]int),
+		userRoom: make(map[string]map[string]int),
+		privacy:  make(map[string]*presence.PrivacySettings),
+		nodes:    make(map[string]int64),
+		watchers: make(map[*watcher]struct{}),
+		changed:  make(chan struct{}, 1),
+		alive:    map[string]bool{nodeID: true},
+
+		history:     make(map[string][]historyEvent),
+		historySeen: make(map[string]struct{}),
+	}
 }
 
 func (s *presenceServer) UpdatePresence(ctx context.Context, req *presence.UpdatePresenceRequest) (*presence.UpdatePresenceResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now().Unix()
+	now := time.Now()
+	existing := s.sessions[req.SessionId]
+	if !req.Online && existing == nil {
+		// Nothing to close, and no reason to start tracking the user
+		return &presence.UpdatePresenceResponse{Success: true}, nil
+	}
+
+	if req.Online || existing.Online {
+		startedAt := now.UnixNano()
+		if existing != nil && existing.Online {
+			startedAt = existing.StartedAt
+		}
+		s.putSession(&presence.SessionRecord{
+			SessionId: req.SessionId,
+			UserId:    req.UserId,
+			RoomId:    req.RoomId,
+			Online:    req.Online,
+			UpdatedAt: now.UnixNano(),
+			NodeId:    s.nodeID,
+			StartedAt: startedAt,
+		})
+		s.userPresence(req.UserId).LastActive = now.Unix()
+		s.notifyChanged()
+	}
+
+	if s.rebuildUser(req.UserId) {
+		s.publish(s.presence[req.UserId])
+	}
+	return &presence.UpdatePresenceResponse{Success: true}, nil
+}
+
+// putSession stores a session record, indexes it by user and records the
+// transition in the user's history. s.mu must be held.
+func (s *presenceServer) putSession(rec *presence.SessionRecord) {
+	old, exists := s.sessions[rec.SessionId]
+	if exists && old.UserId != rec.UserId {
+		delete(s.byUser[old.UserId], rec.SessionId)
+	}
+	s.recordTransition(old, rec)
+	s.sessions[rec.SessionId] = rec
+
+	ids, ok := s.byUser[rec.UserId]
+	if !ok {
+		ids = make(map[string]struct{})
+		s.byUser[rec.UserId] = ids
+	}
+	ids[rec.SessionId] = struct{}{}
+}
+
+func (s *presenceServer) userPresence(userID string) *presence.UserPresence {
+	p, exists := s.presence[userID]
+	if !exists {
+		p = &presence.UserPresence{
+			UserId: userID,
+			Status: presence.PresenceStatus_PRESENCE_STATUS_OFFLINE,
+		}
+		s.presence[userID] = p
+	}
+	return p
+}
+
+// rebuildUser recomputes a user's presence and room membership from their
+// session records and reports whether the user's online state or rooms
+// changed. s.mu must be held.
+func (s *presenceServer) rebuildUser(userID string) bool {
+	p := s.userPresence(userID)
+	wasOnline, hadConnections := p.Online, p.ActiveConnections
+	hadRooms := s.userRoom[userID]
+
+	connections := 0
+	rooms := make(map[string]int)
+	for sid := range s.byUser[userID] {
+		rec := s.sessions[sid]
+		if !rec.Online || !s.alive[rec.NodeId] {
+			continue
+		}
+		connections++
+		if rec.RoomId != "" {
+			rooms[rec.RoomId]++
+		}
+	}
 
-	if existing, exists := s.presence[req.UserId]; exists {
-		existing.ActiveConnections++
-		if req.Online {
-			existing.LastActive = now
-			existing.Online = true
+	for roomID := range s.userRoom[userID] {
+		members := s.rooms[roomID]
+		delete(members, userID)
+		if len(members) == 0 {
+			delete(s.rooms, roomID)
 		}
+	}
+	for roomID, count := range rooms {
+		members, ok := s.rooms[roomID]
+		if !ok {
+			members = make(map[string]int)
+			s.rooms[roomID] = members
+		}
+		members[userID] = count
+	}
+	s.userRoom[userID] = rooms
+
+	p.ActiveConnections = int32(connections)
+	p.Online = connections > 0
+	if p.Online {
+		p.Status = presence.PresenceStatus_PRESENCE_STATUS_ONLINE
 	} else {
-		s.presence[req.UserId] = &presence.UserPresence{
-			UserId:            req.UserId,
-			Online:            req.Online,
-			LastActive:        now,
-			ActiveConnections: 1,
+		p.Status = presence.PresenceStatus_PRESENCE_STATUS_OFFLINE
+	}
+	roomsChanged := len(rooms) != len(hadRooms)
+	for roomID := range rooms {
+		if _, ok := hadRooms[roomID]; !ok {
+			roomsChanged = true
 		}
 	}
+	return p.Online != wasOnline || p.ActiveConnections != hadConnections || roomsChanged
+}
 
-	s.sessions[req.SessionId] = req.UserId
-	return &presence.UpdatePresenceResponse{Success: true}, nil
+// notifyChanged tells the replicator that local state changed and should be
+// pushed to peers without waiting for the next sync interval.
+func (s *presenceServer) notifyChanged() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
 }
 
 func (s *presenceServer) GetPresence(ctx context.Context, req *presence.GetPresenceRequest) (*presence.GetPresenceResponse, error) {
@@ -50,30 +194,137 @@ func (s *presenceServer) GetPresence(ctx context.Context, req *presence.GetPrese
 	result := make([]*presence.UserPresence, 0, len(req.UserIds))
 	for _, uid := range req.UserIds {
 		if p, exists := s.presence[uid]; exists {
-			result = append(result, p)
+			result = append(result, s.visiblePresence(req.ViewerId, p))
 		}
 	}
 	return &presence.GetPresenceResponse{Presences: result}, nil
 }
 
+func (s *presenceServer) GetRoomPresence(ctx context.Context, req *presence.GetRoomPresenceRequest) (*presence.GetRoomPresenceResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := s.rooms[req.RoomId]
+	result := make([]*presence.UserPresence, 0, len(members))
+	for uid := range members {
+		if p, exists := s.presence[uid]; exists {
+			result = append(result, s.visiblePresence(req.ViewerId, p))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserId < result[j].UserId })
+	return &presence.GetRoomPresenceResponse{RoomId: req.RoomId, Members: result}, nil
+}
+
 func (s *presenceServer) StreamPresence(req *presence.StreamPresenceRequest, stream presence.PresenceService_StreamPresenceServer) error {
-	// Implement presence streaming logic
-	// (This would typically use a pub/sub system in production)
-	return nil
+	w := &watcher{
+		viewerID: req.ViewerId,
+		userID:   req.UserId,
+		updates:  make(chan *presence.PresenceUpdate, 64),
+	}
+
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case update := <-w.updates:
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publish fans a presence change and the user's rooms out to every
+// watcher, filtered by the user's privacy settings. Slow watchers miss
+// updates rather than block the caller. s.mu must be held.
+func (s *presenceServer) publish(p *presence.UserPresence) {
+	roomIDs := make([]string, 0, len(s.userRoom[p.UserId]))
+	for roomID := range s.userRoom[p.UserId] {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+
+	for w := range s.watchers {
+		if w.userID != "" && w.userID != p.UserId {
+			continue
+		}
+
+		visible := s.visiblePresence(w.viewerID, p)
+		select {
+		case w.updates <- &presence.PresenceUpdate{
+			UserId:     visible.UserId,
+			Online:     visible.Online,
+			LastActive: visible.LastActive,
+			RoomIds:    roomIDs,
+		}:
+		default:
+			log.Printf("Dropping presence update for slow watcher %q", w.viewerID)
+		}
+	}
 }
 
 func main() {
-	lis, err := net.Listen("tcp", ":50052")
+	port, found := os.LookupEnv("PRESENCE_PORT")
+	if !found {
+		port = "50052"
+	}
+
+	// Replicas trust each other's state, so they gossip on a port of their
+	// own that is only reachable by the other replicas
+	replicationPort, found := os.LookupEnv("REPLICATION_PORT")
+	if !found {
+		replicationPort = "50062"
+	}
+
+	nodeID, found := os.LookupEnv("PRESENCE_NODE_ID")
+	if !found {
+		hostname, _ := os.Hostname()
+		nodeID = hostname + ":" + port
+	}
+
+	var peers []string
+	if peerList, found := os.LookupEnv("PRESENCE_PEERS"); found {
+		for _, peer := range strings.Split(peerList, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	replicationLis, err := net.Listen("tcp", ":"+replicationPort)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	server := newPresenceServer(nodeID)
+	repl := newReplicator(server, peers)
+	go repl.run(context.Background())
+
+	replicationServer := grpc.NewServer()
+	presence.RegisterPresenceReplicationServer(replicationServer, repl)
+	go func() {
+		log.Printf("Presence replication running on :%s with peers %v", replicationPort, peers)
+		log.Fatal(replicationServer.Serve(replicationLis))
+	}()
+
 	s := grpc.NewServer()
-	presence.RegisterPresenceServiceServer(s, &presenceServer{
-		presence: make(map[string]*presence.UserPresence),
-		sessions: make(map[string]string),
-	})
+	presence.RegisterPresenceServiceServer(s, server)
 
-	log.Println("Presence service running on :50052")
+	log.Printf("Presence service %s running on :%s", nodeID, port)
 	log.Fatal(s.Serve(lis))
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go-grpc-basic/proto/presence"
+)
+
+func TestPublishIncludesRooms(t *testing.T) {
+	s := newPresenceServer("a")
+	w := &watcher{updates: make(chan *presence.PresenceUpdate, 8)}
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+	ctx := context.Background()
+
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1/lobby", RoomId: "lobby", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1/kitchen", RoomId: "kitchen", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1/lobby", RoomId: "lobby", Online: false})
+
+	for _, want := range [][]string{{}, {"lobby"}, {"kitchen", "lobby"}, {"kitchen"}} {
+		update := <-w.updates
+		if !update.Online || !slices.Equal(update.RoomIds, want) {
+			t.Fatalf("published %v, want alice online in %v", update, want)
+		}
+	}
+}
+
+func TestUpdatePresencePublishesChangesOnly(t *testing.T) {
+	s := newPresenceServer("a")
+	w := &watcher{updates: make(chan *presence.PresenceUpdate, 8)}
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+	ctx := context.Background()
+
+	// A stranger closing a session that never existed
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "mallory", SessionId: "m1", Online: false})
+	s.mu.RLock()
+	_, tracked := s.presence["mallory"]
+	s.mu.RUnlock()
+	if tracked {
+		t.Fatal("an offline update for an unknown session created presence")
+	}
+
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: true})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: false})
+	s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", Online: false})
+
+	var published []bool
+	for len(w.updates) > 0 {
+		update := <-w.updates
+		if update.UserId != "alice" {
+			t.Fatalf("published %v, want only alice", update)
+		}
+		published = append(published, update.Online)
+	}
+	if !slices.Equal(published, []bool{true, false}) {
+		t.Fatalf("published online states %v, want [true false]", published)
+	}
+}
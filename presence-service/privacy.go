@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *presenceServer) GetPrivacy(ctx context.Context, req *presence.GetPrivacyRequest) (*presence.PrivacySettings, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if settings, exists := s.privacy[req.UserId]; exists {
+		return settings, nil
+	}
+	return &presence.PrivacySettings{UserId: req.UserId}, nil
+}
+
+func (s *presenceServer) SetPrivacy(ctx context.Context, req *presence.SetPrivacyRequest) (*presence.SetPrivacyResponse, error) {
+	settings := req.GetSettings()
+	if settings.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "settings.user_id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.privacy[settings.UserId] = &presence.PrivacySettings{
+		UserId:       settings.UserId,
+		OnlineStatus: settings.OnlineStatus,
+		LastSeen:     settings.LastSeen,
+		Contacts:     append([]string(nil), settings.Contacts...),
+		UpdatedAt:    time.Now().UnixNano(),
+	}
+	s.notifyChanged()
+
+	// Subscribers may now be allowed to see less (or more) of this user
+	if p, exists := s.presence[settings.UserId]; exists {
+		s.publish(p)
+	}
+	return &presence.SetPrivacyResponse{Success: true}, nil
+}
+
+// canSee reports whether viewerID may see information about userID that is
+// guarded by the given visibility.
+func (s *presenceServer) canSee(viewerID, userID string, visibility presence.Visibility) bool {
+	if viewerID != "" && viewerID == userID {
+		return true
+	}
+
+	switch visibility {
+	case presence.Visibility_VISIBILITY_EVERYONE:
+		return true
+	case presence.Visibility_VISIBILITY_CONTACTS:
+		if viewerID == "" {
+			return false
+		}
+		for _, contact := range s.privacy[userID].GetContacts() {
+			if contact == viewerID {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// visiblePresence returns a copy of p with the fields viewerID is not allowed
+// to see cleared. Hidden online status is reported as offline.
+func (s *presenceServer) visiblePresence(viewerID string, p *presence.UserPresence) *presence.UserPresence {
+	settings := s.privacy[p.UserId]
+	result := &presence.UserPresence{
+		UserId: p.UserId,
+		Status: presence.PresenceStatus_PRESENCE_STATUS_OFFLINE,
+	}
+
+	if s.canSee(viewerID, p.UserId, settings.GetOnlineStatus()) {
+		result.Online = p.Online
+		result.ActiveConnections = p.ActiveConnections
+		result.Status = p.Status
+	}
+	if s.canSee(viewerID, p.UserId, settings.GetLastSeen()) {
+		result.LastActive = p.LastActive
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newPrivacyServer has alice, bob and dan online in the lobby. alice shows
+// everything to everyone, bob shows it to carol only and dan to nobody.
+func newPrivacyServer(t *testing.T) *presenceServer {
+	t.Helper()
+	s := newPresenceServer("a")
+	ctx := context.Background()
+	settings := map[string]*presence.PrivacySettings{
+		"alice": {OnlineStatus: presence.Visibility_VISIBILITY_EVERYONE, LastSeen: presence.Visibility_VISIBILITY_EVERYONE},
+		"bob":   {OnlineStatus: presence.Visibility_VISIBILITY_CONTACTS, LastSeen: presence.Visibility_VISIBILITY_CONTACTS, Contacts: []string{"carol"}},
+		"dan":   {OnlineStatus: presence.Visibility_VISIBILITY_NOBODY, LastSeen: presence.Visibility_VISIBILITY_NOBODY},
+	}
+	for uid, privacy := range settings {
+		privacy.UserId = uid
+		if _, err := s.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: privacy}); err != nil {
+			t.Fatalf("SetPrivacy: %v", err)
+		}
+		s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: uid, SessionId: uid, RoomId: "lobby", Online: true})
+	}
+	return s
+}
+
+// privacyCases lists who each viewer may see, "" being an anonymous viewer.
+var privacyCases = []struct {
+	viewer  string
+	visible map[string]bool
+}{
+	{"", map[string]bool{"alice": true}},
+	{"erin", map[string]bool{"alice": true}},
+	{"carol", map[string]bool{"alice": true, "bob": true}},
+	{"bob", map[string]bool{"alice": true, "bob": true}},
+	{"dan", map[string]bool{"alice": true, "dan": true}},
+}
+
+// checkVisible checks that p shows exactly what the viewer may see. Hidden
+// users look offline, or unknown to ListPresence.
+func checkVisible(t *testing.T, viewer string, p *presence.UserPresence, visible bool, hiddenStatus presence.PresenceStatus) {
+	t.Helper()
+	if visible {
+		if !p.Online || p.Status != presence.PresenceStatus_PRESENCE_STATUS_ONLINE || p.LastActive == 0 || p.ActiveConnections != 1 {
+			t.Errorf("%q sees %v, want %s online", viewer, p, p.UserId)
+		}
+		return
+	}
+	if p.Online || p.Status != hiddenStatus || p.LastActive != 0 || p.ActiveConnections != 0 {
+		t.Errorf("%q sees %v, want %s hidden as %v", viewer, p, p.UserId, hiddenStatus)
+	}
+}
+
+func TestPrivacyGetPresence(t *testing.T) {
+	s := newPrivacyServer(t)
+	for _, tt := range privacyCases {
+		resp, err := s.GetPresence(context.Background(), &presence.GetPresenceRequest{UserIds: []string{"alice", "bob", "dan"}, ViewerId: tt.viewer})
+		if err != nil {
+			t.Fatalf("GetPresence: %v", err)
+		}
+		if len(resp.Presences) != 3 {
+			t.Fatalf("%q got %d presences, want 3", tt.viewer, len(resp.Presences))
+		}
+		for _, p := range resp.Presences {
+			checkVisible(t, tt.viewer, p, tt.visible[p.UserId], presence.PresenceStatus_PRESENCE_STATUS_OFFLINE)
+		}
+	}
+}
+
+func TestPrivacyGetRoomPresence(t *testing.T) {
+	s := newPrivacyServer(t)
+	for _, tt := range privacyCases {
+		resp, err := s.GetRoomPresence(context.Background(), &presence.GetRoomPresenceRequest{RoomId: "lobby", ViewerId: tt.viewer})
+		if err != nil {
+			t.Fatalf("GetRoomPresence: %v", err)
+		}
+		if len(resp.Members) != 3 {
+			t.Fatalf("%q got %d members, want 3", tt.viewer, len(resp.Members))
+		}
+		for _, p := range resp.Members {
+			checkVisible(t, tt.viewer, p, tt.visible[p.UserId], presence.PresenceStatus_PRESENCE_STATUS_OFFLINE)
+		}
+	}
+}
+
+func TestPrivacyListPresence(t *testing.T) {
+	s := newPrivacyServer(t)
+	for _, tt := range privacyCases {
+		// By name, everyone is listed
+		resp, err := s.ListPresence(context.Background(), &presence.ListPresenceRequest{UserIds: []string{"alice", "bob", "dan"}, ViewerId: tt.viewer})
+		if err != nil {
+			t.Fatalf("ListPresence: %v", err)
+		}
+		if len(resp.Presences) != 3 {
+			t.Fatalf("%q got %d presences, want 3", tt.viewer, len(resp.Presences))
+		}
+		for _, p := range resp.Presences {
+			checkVisible(t, tt.viewer, p, tt.visible[p.UserId], presence.PresenceStatus_PRESENCE_STATUS_UNKNOWN)
+		}
+
+		// Otherwise only the users the viewer may see
+		resp, err = s.ListPresence(context.Background(), &presence.ListPresenceRequest{ViewerId: tt.viewer})
+		if err != nil {
+			t.Fatalf("ListPresence: %v", err)
+		}
+		if len(resp.Presences) != len(tt.visible) {
+			t.Errorf("%q listed %v, want %v", tt.viewer, resp.Presences, tt.visible)
+		}
+		for _, p := range resp.Presences {
+			checkVisible(t, tt.viewer, p, tt.visible[p.UserId], presence.PresenceStatus_PRESENCE_STATUS_UNKNOWN)
+		}
+	}
+}
+
+func TestPrivacyStreamPresence(t *testing.T) {
+	s := newPrivacyServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	presence.RegisterPresenceServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := presence.NewPresenceServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	streams := make(map[string]presence.PresenceService_StreamPresenceClient)
+	for _, tt := range privacyCases {
+		stream, err := client.StreamPresence(ctx, &presence.StreamPresenceRequest{ViewerId: tt.viewer})
+		if err != nil {
+			t.Fatalf("StreamPresence: %v", err)
+		}
+		streams[tt.viewer] = stream
+	}
+	// Wait until every stream is subscribed
+	for {
+		s.mu.RLock()
+		n := len(s.watchers)
+		s.mu.RUnlock()
+		if n == len(privacyCases) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Each user comes online in another room; watchers see it as allowed
+	for _, uid := range []string{"alice", "bob", "dan"} {
+		s.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: uid, SessionId: uid + "/kitchen", RoomId: "kitchen", Online: true})
+	}
+	for _, tt := range privacyCases {
+		for _, uid := range []string{"alice", "bob", "dan"} {
+			update, err := streams[tt.viewer].Recv()
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			if update.UserId != uid {
+				t.Fatalf("%q got an update for %s, want %s", tt.viewer, update.UserId, uid)
+			}
+			visible := tt.visible[uid]
+			if update.Online != visible || (update.LastActive != 0) != visible {
+				t.Errorf("%q sees %v, want visible %v", tt.viewer, update, visible)
+			}
+		}
+	}
+
+	// Changing privacy republishes to everyone, with the new rules applied
+	s.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{UserId: "alice", OnlineStatus: presence.Visibility_VISIBILITY_NOBODY}})
+	for _, tt := range privacyCases {
+		update, err := streams[tt.viewer].Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if want := tt.viewer == "alice"; update.UserId != "alice" || update.Online != want {
+			t.Errorf("%q sees %v after alice hid, want online %v", tt.viewer, update, want)
+		}
+	}
+}
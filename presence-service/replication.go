@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Replicas gossip their full state to every configured peer whenever local
+// state changes and at least every syncInterval. Each piece of state merges
+// deterministically (latest session record, latest privacy settings, highest
+// last_active), so replicas converge regardless of the order syncs arrive in
+// and peers only need to be connected transitively.
+//
+// Peers are the replication addresses of the other replicas. Sync accepts
+// whatever state it is sent, so the replication port must only be reachable
+// by them. To try a cluster locally, start several replicas with distinct
+// ports that list each other as peers:
+//
+//	PRESENCE_PORT=50052 REPLICATION_PORT=50062 PRESENCE_PEERS=localhost:50063 go run ./presence-service
+//	PRESENCE_PORT=50053 REPLICATION_PORT=50063 PRESENCE_PEERS=localhost:50062 go run ./presence-service
+const (
+	syncInterval = 2 * time.Second
+	syncTimeout  = 2 * time.Second
+	// peerTimeout is how long a replica may go unheard before the sessions
+	// it wrote stop counting as online.
+	peerTimeout = 10 * time.Second
+	// tombstoneTTL is how long offline records and the records of dead
+	// replicas are kept so late syncs cannot resurrect them.
+	tombstoneTTL = 5 * time.Minute
+)
+
+type replicator struct {
+	presence.UnimplementedPresenceReplicationServer
+	server *presenceServer
+	peers  []string
+}
+
+func newReplicator(server *presenceServer, peers []string) *replicator {
+	return &replicator{server: server, peers: peers}
+}
+
+func (r *replicator) Sync(ctx context.Context, req *presence.ReplicaState) (*presence.ReplicaState, error) {
+	r.server.merge(req)
+	return r.server.snapshot(), nil
+}
+
+// run gossips with the peers until ctx is canceled.
+func (r *replicator) run(ctx context.Context) {
+	clients := make(map[string]presence.PresenceReplicationClient, len(r.peers))
+	for _, peer := range r.peers {
+		conn, err := grpc.Dial(peer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Printf("Failed to connect to peer %s: %v", peer, err)
+			continue
+		}
+		defer conn.Close()
+		clients[peer] = presence.NewPresenceReplicationClient(conn)
+	}
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.server.expire()
+		case <-r.server.changed:
+		case <-ctx.Done():
+			return
+		}
+
+		state := r.server.snapshot()
+		var wg sync.WaitGroup
+		for peer, client := range clients {
+			wg.Add(1)
+			go func(peer string, client presence.PresenceReplicationClient) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+				defer cancel()
+
+				resp, err := client.Sync(ctx, state)
+				if err != nil {
+					log.Printf("Sync with peer %s failed: %v", peer, err)
+					return
+				}
+				r.server.merge(resp)
+			}(peer, client)
+		}
+		wg.Wait()
+	}
+}
+
+// snapshot returns the replica's full state for gossiping.
+func (s *presenceServer) snapshot() *presence.ReplicaState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodes[s.nodeID] = time.Now().UnixNano()
+
+	state := &presence.ReplicaState{
+		NodeId:   s.nodeID,
+		Nodes:    make([]*presence.NodeInfo, 0, len(s.nodes)),
+		Sessions: make([]*presence.SessionRecord, 0, len(s.sessions)),
+		Activity: make([]*presence.UserActivity, 0, len(s.presence)),
+		Privacy:  make([]*presence.PrivacySettings, 0, len(s.privacy)),
+	}
+	for nodeID, lastSeen := range s.nodes {
+		state.Nodes = append(state.Nodes, &presence.NodeInfo{NodeId: nodeID, LastSeen: lastSeen})
+	}
+	// Records are replaced, never mutated, so they can be shared.
+	for _, rec := range s.sessions {
+		state.Sessions = append(state.Sessions, rec)
+	}
+	for uid, p := range s.presence {
+		state.Activity = append(state.Activity, &presence.UserActivity{UserId: uid, LastActive: p.LastActive})
+	}
+	for _, settings := range s.privacy {
+		state.Privacy = append(state.Privacy, settings)
+	}
+	return state
+}
+
+// merge folds a peer's state into the local state and publishes the users
+// whose presence changed as a result.
+func (s *presenceServer) merge(state *presence.ReplicaState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, node := range state.Nodes {
+		if node.NodeId != s.nodeID && node.LastSeen > s.nodes[node.NodeId] {
+			s.nodes[node.NodeId] = node.LastSeen
+		}
+	}
+
+	affected := s.refreshLiveness()
+	for _, rec := range state.Sessions {
+		current, exists := s.sessions[rec.SessionId]
+		if exists && !newerRecord(rec, current) {
+			continue
+		}
+		if exists {
+			affected[current.UserId] = true
+		}
+		s.putSession(rec)
+		affected[rec.UserId] = true
+	}
+
+	for _, activity := range state.Activity {
+		p := s.userPresence(activity.UserId)
+		if activity.LastActive > p.LastActive {
+			p.LastActive = activity.LastActive
+			affected[activity.UserId] = true
+		}
+	}
+
+	// A replica only learns privacy settings along with the sessions of
+	// the user who set them
+	hasSessions := make(map[string]bool)
+	for _, rec := range state.Sessions {
+		hasSessions[rec.UserId] = true
+	}
+	for _, settings := range state.Privacy {
+		if !hasSessions[settings.UserId] {
+			continue
+		}
+		current, exists := s.privacy[settings.UserId]
+		if exists && settings.UpdatedAt <= current.UpdatedAt {
+			continue
+		}
+		s.privacy[settings.UserId] = settings
+		affected[settings.UserId] = true
+	}
+
+	for uid := range affected {
+		s.rebuildUser(uid)
+		s.publish(s.presence[uid])
+	}
+}
+
+func newerRecord(a, b *presence.SessionRecord) bool {
+	if a.UpdatedAt != b.UpdatedAt {
+		return a.UpdatedAt > b.UpdatedAt
+	}
+	return a.NodeId > b.NodeId
+}
+
+// refreshLiveness re-evaluates which replicas are alive and returns the
+// users with sessions on replicas whose liveness changed. s.mu must be held.
+func (s *presenceServer) refreshLiveness() map[string]bool {
+	now := time.Now().UnixNano()
+	flipped := make(map[string]bool)
+	for nodeID, lastSeen := range s.nodes {
+		if nodeID == s.nodeID {
+			continue
+		}
+		alive := now-lastSeen < int64(peerTimeout)
+		if alive != s.alive[nodeID] {
+			s.alive[nodeID] = alive
+			flipped[nodeID] = true
+			log.Printf("Replica %s alive: %v", nodeID, alive)
+		}
+	}
+
+	affected := make(map[string]bool)
+	if len(flipped) == 0 {
+		return affected
+	}
+	for _, rec := range s.sessions {
+		if flipped[rec.NodeId] {
+			affected[rec.UserId] = true
+		}
+	}
+	return affected
+}
+
+// expire updates replica liveness, trims history and drops tombstones and
+// the records of replicas that have been gone for longer than tombstoneTTL.
+func (s *presenceServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	affected := s.refreshLiveness()
+
+	cutoff := time.Now().Add(-tombstoneTTL).UnixNano()
+	for sid, rec := range s.sessions {
+		if rec.UpdatedAt > cutoff {
+			continue
+		}
+		if rec.Online && (rec.NodeId == s.nodeID || s.nodes[rec.NodeId] > cutoff) {
+			continue
+		}
+		if rec.Online {
+			// The replica holding this session is gone; close it in the
+			// history at the last time the replica was heard from.
+			s.recordTransition(rec, &presence.SessionRecord{
+				SessionId: rec.SessionId,
+				UserId:    rec.UserId,
+				RoomId:    rec.RoomId,
+				UpdatedAt: max(rec.UpdatedAt, s.nodes[rec.NodeId]),
+				StartedAt: rec.StartedAt,
+			})
+		}
+		delete(s.sessions, sid)
+		delete(s.byUser[rec.UserId], sid)
+		if len(s.byUser[rec.UserId]) == 0 {
+			delete(s.byUser, rec.UserId)
+		}
+		affected[rec.UserId] = true
+	}
+	s.trimHistory()
+	for nodeID, lastSeen := range s.nodes {
+		if nodeID != s.nodeID && lastSeen <= cutoff {
+			delete(s.nodes, nodeID)
+			delete(s.alive, nodeID)
+		}
+	}
+
+	for uid := range affected {
+		if s.rebuildUser(uid) {
+			s.publish(s.presence[uid])
+		}
+	}
+}
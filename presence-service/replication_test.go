@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go-grpc-basic/proto/presence"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// replica is a presence service running on a local port.
+type replica struct {
+	*presenceServer
+	addr string
+	stop func()
+}
+
+// startReplicas runs one replica per node ID, each listing all the others
+// as peers, like a local cluster started by hand.
+func startReplicas(t *testing.T, nodeIDs ...string) []*replica {
+	t.Helper()
+	replicas := make([]*replica, len(nodeIDs))
+	listeners := make([]net.Listener, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		listeners[i] = lis
+		replicas[i] = &replica{presenceServer: newPresenceServer(nodeID), addr: lis.Addr().String()}
+	}
+
+	for i, r := range replicas {
+		var peers []string
+		for j, other := range replicas {
+			if j != i {
+				peers = append(peers, other.addr)
+			}
+		}
+		repl := newReplicator(r.presenceServer, peers)
+		s := grpc.NewServer()
+		presence.RegisterPresenceServiceServer(s, r.presenceServer)
+		presence.RegisterPresenceReplicationServer(s, repl)
+		go s.Serve(listeners[i])
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			repl.run(ctx)
+			close(done)
+		}()
+		r.stop = func() {
+			cancel()
+			<-done
+			s.Stop()
+		}
+		t.Cleanup(r.stop)
+	}
+	return replicas
+}
+
+// eventually polls cond until it holds or a few sync intervals pass.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * syncInterval)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (r *replica) session(id string) *presence.SessionRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sessions[id]
+}
+
+func (r *replica) online(userID string) bool {
+	resp, _ := r.GetPresence(context.Background(), &presence.GetPresenceRequest{UserIds: []string{userID}})
+	return len(resp.Presences) == 1 && resp.Presences[0].Online
+}
+
+func (r *replica) inRoom(roomID, userID string) bool {
+	resp, _ := r.GetRoomPresence(context.Background(), &presence.GetRoomPresenceRequest{RoomId: roomID})
+	for _, m := range resp.Members {
+		if m.UserId == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *replica) privacyOf(userID string) *presence.PrivacySettings {
+	settings, _ := r.GetPrivacy(context.Background(), &presence.GetPrivacyRequest{UserId: userID})
+	return settings
+}
+
+func TestReplicasConvergeOnLatestWrite(t *testing.T) {
+	a := newPresenceServer("a")
+	b := newPresenceServer("b")
+	ctx := context.Background()
+
+	// Conflicting writes made before the replicas ever talked; b's are later
+	a.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", RoomId: "lobby", Online: true})
+	a.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{UserId: "alice", OnlineStatus: presence.Visibility_VISIBILITY_NOBODY}})
+	time.Sleep(time.Millisecond)
+	b.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", RoomId: "kitchen", Online: true})
+	b.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{UserId: "alice", OnlineStatus: presence.Visibility_VISIBILITY_CONTACTS}})
+	want := proto.Clone(b.sessions["s1"]).(*presence.SessionRecord)
+
+	// Whichever side merges first, both end up with b's writes
+	a.merge(b.snapshot())
+	b.merge(a.snapshot())
+	for _, s := range []*presenceServer{a, b} {
+		r := &replica{presenceServer: s}
+		if got := r.session("s1"); !proto.Equal(got, want) {
+			t.Errorf("replica %s has session %v, want %v", s.nodeID, got, want)
+		}
+		if !r.inRoom("kitchen", "alice") || r.inRoom("lobby", "alice") {
+			t.Errorf("replica %s does not have alice in the kitchen only", s.nodeID)
+		}
+		if got := r.privacyOf("alice").OnlineStatus; got != presence.Visibility_VISIBILITY_CONTACTS {
+			t.Errorf("replica %s has alice's online status visible to %v, want contacts", s.nodeID, got)
+		}
+	}
+}
+
+func TestReplicasGossip(t *testing.T) {
+	replicas := startReplicas(t, "a", "b")
+	a, b := replicas[0], replicas[1]
+	ctx := context.Background()
+
+	a.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", RoomId: "lobby", Online: true})
+	eventually(t, "b sees alice in the lobby", func() bool { return b.inRoom("lobby", "alice") })
+
+	// The later write wins, wherever it was made
+	b.UpdatePresence(ctx, &presence.UpdatePresenceRequest{UserId: "alice", SessionId: "s1", RoomId: "lobby", Online: false})
+	eventually(t, "a sees alice leave", func() bool { return !a.online("alice") && !a.inRoom("lobby", "alice") })
+	if rec := a.session("s1"); rec.NodeId != "b" || rec.Online {
+		t.Fatalf("a has session %v, want b's offline record", rec)
+	}
+
+	b.SetPrivacy(ctx, &presence.SetPrivacyRequest{Settings: &presence.PrivacySettings{UserId: "alice", LastSeen: presence.Visibility_VISIBILITY_NOBODY}})
+	eventually(t, "a has b's privacy settings", func() bool {
+		return a.privacyOf("alice").LastSeen == presence.Visibility_VISIBILITY_NOBODY
+	})
+}
+
+func TestDeadReplicaSessionsExpire(t *testing.T) {
+	replicas := startReplicas(t, "a", "b")
+	a, b := replicas[0], replicas[1]
+
+	a.UpdatePresence(context.Background(), &presence.UpdatePresenceRequest{UserId: "bob", SessionId: "s2", RoomId: "lobby", Online: true})
+	eventually(t, "b sees bob online", func() bool { return b.online("bob") })
+
+	// a goes away for longer than peerTimeout
+	a.stop()
+	b.mu.Lock()
+	b.nodes["a"] = time.Now().Add(-peerTimeout - time.Second).UnixNano()
+	b.mu.Unlock()
+	b.expire()
+	if b.online("bob") || b.inRoom("lobby", "bob") {
+		t.Fatal("b still counts the dead replica's session")
+	}
+	if b.session("s2") == nil {
+		t.Fatal("b forgot the dead replica's session before tombstoneTTL")
+	}
+
+	// and then for longer than tombstoneTTL
+	b.mu.Lock()
+	gone := time.Now().Add(-tombstoneTTL - time.Minute).UnixNano()
+	b.nodes["a"] = gone
+	rec := proto.Clone(b.sessions["s2"]).(*presence.SessionRecord)
+	rec.UpdatedAt = gone
+	b.sessions["s2"] = rec
+	b.mu.Unlock()
+	b.expire()
+	if b.session("s2") != nil {
+		t.Fatal("b kept the dead replica's session past tombstoneTTL")
+	}
+	b.mu.RLock()
+	_, known := b.nodes["a"]
+	b.mu.RUnlock()
+	if known {
+		t.Fatal("b still tracks the dead replica")
+	}
+}
+
+func TestMergeIgnoresPrivacyWithoutSessions(t *testing.T) {
+	s := newPresenceServer("a")
+	now := time.Now().UnixNano()
+	s.merge(&presence.ReplicaState{
+		NodeId: "b",
+		Nodes:  []*presence.NodeInfo{{NodeId: "b", LastSeen: now}},
+		Sessions: []*presence.SessionRecord{
+			{SessionId: "s1", UserId: "alice", Online: true, UpdatedAt: now, NodeId: "b", StartedAt: now},
+		},
+		Privacy: []*presence.PrivacySettings{
+			{UserId: "alice", LastSeen: presence.Visibility_VISIBILITY_NOBODY, UpdatedAt: now},
+			// bob has no sessions on b, so b has no business setting his privacy
+			{UserId: "bob", OnlineStatus: presence.Visibility_VISIBILITY_EVERYONE, UpdatedAt: now},
+		},
+	})
+
+	r := &replica{presenceServer: s}
+	if got := r.privacyOf("alice").LastSeen; got != presence.Visibility_VISIBILITY_NOBODY {
+		t.Errorf("alice's last seen is visible to %v, want nobody", got)
+	}
+	s.mu.RLock()
+	_, forged := s.privacy["bob"]
+	s.mu.RUnlock()
+	if forged {
+		t.Error("merge took privacy settings for a user without sessions")
+	}
+}
@@ -24,7 +24,12 @@ const _ = grpc.SupportPackageIsVersion7
 type PresenceServiceClient interface {
 	UpdatePresence(ctx context.Context, in *UpdatePresenceRequest, opts ...grpc.CallOption) (*UpdatePresenceResponse, error)
 	GetPresence(ctx context.Context, in *GetPresenceRequest, opts ...grpc.CallOption) (*GetPresenceResponse, error)
+	ListPresence(ctx context.Context, in *ListPresenceRequest, opts ...grpc.CallOption) (*ListPresenceResponse, error)
 	StreamPresence(ctx context.Context, in *StreamPresenceRequest, opts ...grpc.CallOption) (PresenceService_StreamPresenceClient, error)
+	GetRoomPresence(ctx context.Context, in *GetRoomPresenceRequest, opts ...grpc.CallOption) (*GetRoomPresenceResponse, error)
+	GetPrivacy(ctx context.Context, in *GetPrivacyRequest, opts ...grpc.CallOption) (*PrivacySettings, error)
+	SetPrivacy(ctx context.Context, in *SetPrivacyRequest, opts ...grpc.CallOption) (*SetPrivacyResponse, error)
+	GetPresenceHistory(ctx context.Context, in *GetPresenceHistoryRequest, opts ...grpc.CallOption) (*GetPresenceHistoryResponse, error)
 }
 
 type presenceServiceClient struct {
@@ -53,6 +58,15 @@ func (c *presenceServiceClient) GetPresence(ctx context.Context, in *GetPresence
 	return out, nil
 }
 
+func (c *presenceServiceClient) ListPresence(ctx context.Context, in *ListPresenceRequest, opts ...grpc.CallOption) (*ListPresenceResponse, error) {
+	out := new(ListPresenceResponse)
+	err := c.cc.Invoke(ctx, "/presence.PresenceService/ListPresence", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *presenceServiceClient) StreamPresence(ctx context.Context, in *StreamPresenceRequest, opts ...grpc.CallOption) (PresenceService_StreamPresenceClient, error) {
 	stream, err := c.cc.NewStream(ctx, &PresenceService_ServiceDesc.Streams[0], "/presence.PresenceService/StreamPresence", opts...)
 	if err != nil {
@@ -85,13 +99,54 @@ func (x *presenceServiceStreamPresenceClient) Recv() (*PresenceUpdate, error) {
 	return m, nil
 }
 
+func (c *presenceServiceClient) GetRoomPresence(ctx context.Context, in *GetRoomPresenceRequest, opts ...grpc.CallOption) (*GetRoomPresenceResponse, error) {
+	out := new(GetRoomPresenceResponse)
+	err := c.cc.Invoke(ctx, "/presence.PresenceService/GetRoomPresence", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetPrivacy(ctx context.Context, in *GetPrivacyRequest, opts ...grpc.CallOption) (*PrivacySettings, error) {
+	out := new(PrivacySettings)
+	err := c.cc.Invoke(ctx, "/presence.PresenceService/GetPrivacy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) SetPrivacy(ctx context.Context, in *SetPrivacyRequest, opts ...grpc.CallOption) (*SetPrivacyResponse, error) {
+	out := new(SetPrivacyResponse)
+	err := c.cc.Invoke(ctx, "/presence.PresenceService/SetPrivacy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetPresenceHistory(ctx context.Context, in *GetPresenceHistoryRequest, opts ...grpc.CallOption) (*GetPresenceHistoryResponse, error) {
+	out := new(GetPresenceHistoryResponse)
+	err := c.cc.Invoke(ctx, "/presence.PresenceService/GetPresenceHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PresenceServiceServer is the server API for PresenceService service.
 // All implementations must embed UnimplementedPresenceServiceServer
 // for forward compatibility
 type PresenceServiceServer interface {
 	UpdatePresence(context.Context, *UpdatePresenceRequest) (*UpdatePresenceResponse, error)
 	GetPresence(context.Context, *GetPresenceRequest) (*GetPresenceResponse, error)
+	ListPresence(context.Context, *ListPresenceRequest) (*ListPresenceResponse, error)
 	StreamPresence(*StreamPresenceRequest, PresenceService_StreamPresenceServer) error
+	GetRoomPresence(context.Context, *GetRoomPresenceRequest) (*GetRoomPresenceResponse, error)
+	GetPrivacy(context.Context, *GetPrivacyRequest) (*PrivacySettings, error)
+	SetPrivacy(context.Context, *SetPrivacyRequest) (*SetPrivacyResponse, error)
+	GetPresenceHistory(context.Context, *GetPresenceHistoryRequest) (*GetPresenceHistoryResponse, error)
 	mustEmbedUnimplementedPresenceServiceServer()
 }
 
@@ -105,9 +160,24 @@ func (UnimplementedPresenceServiceServer) UpdatePresence(context.Context, *Updat
 func (UnimplementedPresenceServiceServer) GetPresence(context.Context, *GetPresenceRequest) (*GetPresenceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetPresence not implemented")
 }
+func (UnimplementedPresenceServiceServer) ListPresence(context.Context, *ListPresenceRequest) (*ListPresenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPresence not implemented")
+}
 func (UnimplementedPresenceServiceServer) StreamPresence(*StreamPresenceRequest, PresenceService_StreamPresenceServer) error {
 	return status.Errorf(codes.Unimplemented, "method StreamPresence not implemented")
 }
+func (UnimplementedPresenceServiceServer) GetRoomPresence(context.Context, *GetRoomPresenceRequest) (*GetRoomPresenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoomPresence not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetPrivacy(context.Context, *GetPrivacyRequest) (*PrivacySettings, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPrivacy not implemented")
+}
+func (UnimplementedPresenceServiceServer) SetPrivacy(context.Context, *SetPrivacyRequest) (*SetPrivacyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPrivacy not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetPresenceHistory(context.Context, *GetPresenceHistoryRequest) (*GetPresenceHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPresenceHistory not implemented")
+}
 func (UnimplementedPresenceServiceServer) mustEmbedUnimplementedPresenceServiceServer() {}
 
 // UnsafePresenceServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -157,6 +227,24 @@ func _PresenceService_GetPresence_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PresenceService_ListPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPresenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).ListPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceService/ListPresence",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).ListPresence(ctx, req.(*ListPresenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _PresenceService_StreamPresence_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(StreamPresenceRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -178,6 +266,78 @@ func (x *presenceServiceStreamPresenceServer) Send(m *PresenceUpdate) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _PresenceService_GetRoomPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRoomPresenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetRoomPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceService/GetRoomPresence",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetRoomPresence(ctx, req.(*GetRoomPresenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetPrivacy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPrivacyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetPrivacy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceService/GetPrivacy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetPrivacy(ctx, req.(*GetPrivacyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_SetPrivacy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPrivacyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).SetPrivacy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceService/SetPrivacy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).SetPrivacy(ctx, req.(*SetPrivacyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetPresenceHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPresenceHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetPresenceHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceService/GetPresenceHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetPresenceHistory(ctx, req.(*GetPresenceHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PresenceService_ServiceDesc is the grpc.ServiceDesc for PresenceService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -193,6 +353,26 @@ var PresenceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetPresence",
 			Handler:    _PresenceService_GetPresence_Handler,
 		},
+		{
+			MethodName: "ListPresence",
+			Handler:    _PresenceService_ListPresence_Handler,
+		},
+		{
+			MethodName: "GetRoomPresence",
+			Handler:    _PresenceService_GetRoomPresence_Handler,
+		},
+		{
+			MethodName: "GetPrivacy",
+			Handler:    _PresenceService_GetPrivacy_Handler,
+		},
+		{
+			MethodName: "SetPrivacy",
+			Handler:    _PresenceService_SetPrivacy_Handler,
+		},
+		{
+			MethodName: "GetPresenceHistory",
+			Handler:    _PresenceService_GetPresenceHistory_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -203,3 +383,91 @@ var PresenceService_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "presence.proto",
 }
+
+// PresenceReplicationClient is the client API for PresenceReplication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PresenceReplicationClient interface {
+	// Sync sends the caller's state and returns the callee's state.
+	Sync(ctx context.Context, in *ReplicaState, opts ...grpc.CallOption) (*ReplicaState, error)
+}
+
+type presenceReplicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPresenceReplicationClient(cc grpc.ClientConnInterface) PresenceReplicationClient {
+	return &presenceReplicationClient{cc}
+}
+
+func (c *presenceReplicationClient) Sync(ctx context.Context, in *ReplicaState, opts ...grpc.CallOption) (*ReplicaState, error) {
+	out := new(ReplicaState)
+	err := c.cc.Invoke(ctx, "/presence.PresenceReplication/Sync", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PresenceReplicationServer is the server API for PresenceReplication service.
+// All implementations must embed UnimplementedPresenceReplicationServer
+// for forward compatibility
+type PresenceReplicationServer interface {
+	// Sync sends the caller's state and returns the callee's state.
+	Sync(context.Context, *ReplicaState) (*ReplicaState, error)
+	mustEmbedUnimplementedPresenceReplicationServer()
+}
+
+// UnimplementedPresenceReplicationServer must be embedded to have forward compatible implementations.
+type UnimplementedPresenceReplicationServer struct {
+}
+
+func (UnimplementedPresenceReplicationServer) Sync(context.Context, *ReplicaState) (*ReplicaState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
+func (UnimplementedPresenceReplicationServer) mustEmbedUnimplementedPresenceReplicationServer() {}
+
+// UnsafePresenceReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PresenceReplicationServer will
+// result in compilation errors.
+type UnsafePresenceReplicationServer interface {
+	mustEmbedUnimplementedPresenceReplicationServer()
+}
+
+func RegisterPresenceReplicationServer(s grpc.ServiceRegistrar, srv PresenceReplicationServer) {
+	s.RegisterService(&PresenceReplication_ServiceDesc, srv)
+}
+
+func _PresenceReplication_Sync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplicaState)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceReplicationServer).Sync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/presence.PresenceReplication/Sync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceReplicationServer).Sync(ctx, req.(*ReplicaState))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PresenceReplication_ServiceDesc is the grpc.ServiceDesc for PresenceReplication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PresenceReplication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "presence.PresenceReplication",
+	HandlerType: (*PresenceReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Sync",
+			Handler:    _PresenceReplication_Sync_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "presence.proto",
+}